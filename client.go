@@ -2,28 +2,59 @@ package stereoscope
 
 import (
 	"fmt"
+	"os"
+	"sync"
 
 	"github.com/anchore/stereoscope/internal/bus"
 	dockerClient "github.com/anchore/stereoscope/internal/docker"
 	"github.com/anchore/stereoscope/internal/log"
 	"github.com/anchore/stereoscope/internal/podman"
+	"github.com/anchore/stereoscope/pkg/event"
 	"github.com/anchore/stereoscope/pkg/file"
 	"github.com/anchore/stereoscope/pkg/image"
 	"github.com/anchore/stereoscope/pkg/image/docker"
 	"github.com/anchore/stereoscope/pkg/image/oci"
+	"github.com/anchore/stereoscope/pkg/image/remotearchive"
+	"github.com/anchore/stereoscope/pkg/image/rootfs"
 	"github.com/anchore/stereoscope/pkg/logger"
 	"github.com/wagoodman/go-partybus"
+	"github.com/wagoodman/go-progress"
 )
 
 var tempDirGenerator = file.NewTempDirGenerator()
 
-// GetImageFromSource returns an image from the explicitly provided source.
-func GetImageFromSource(imgStr string, source image.Source, registryOptions *image.RegistryOptions) (*image.Image, error) {
+// providedImages are all images provided since the last Cleanup, so that any resources held for reading their file
+// contents (e.g. memory mapped layer tar caches) can be released along with the temp dirs backing them.
+var providedImages = struct {
+	sync.Mutex
+	images []*image.Image
+}{}
+
+// StdinLocation is the image location that indicates that a docker archive should be read from stdin
+// (e.g. "docker-archive:-").
+const StdinLocation = "-"
+
+// ErrSignatureVerificationUnsupported is returned when a signature verification key is given for an image source that
+// cannot verify signatures (only images fetched from a registry can be verified).
+var ErrSignatureVerificationUnsupported = fmt.Errorf("signature verification is only supported for registry images")
+
+// GetImageFromSource returns an image from the explicitly provided source. Any given additional metadata options are
+// applied to the image before it is read.
+func GetImageFromSource(imgStr string, source image.Source, registryOptions *image.RegistryOptions, additionalMetadata ...image.AdditionalMetadata) (*image.Image, error) {
 	var provider image.Provider
 	log.Debugf("image: source=%+v location=%+v", source, imgStr)
 
+	// never provide an unverified image when verification was requested
+	if registryOptions != nil && registryOptions.SignatureVerificationKey != nil && source != image.OciRegistrySource {
+		return nil, fmt.Errorf("%w: source=%s", ErrSignatureVerificationUnsupported, source)
+	}
+
 	switch source {
 	case image.DockerTarballSource:
+		if imgStr == StdinLocation {
+			provider = docker.NewProviderFromReader(os.Stdin, &tempDirGenerator)
+			break
+		}
 		// note: the imgStr is the path on disk to the tar file
 		provider = docker.NewProviderFromTarball(imgStr, &tempDirGenerator, nil, nil)
 	case image.DockerDaemonSource:
@@ -44,6 +75,12 @@ func GetImageFromSource(imgStr string, source image.Source, registryOptions *ima
 		provider = oci.NewProviderFromTarball(imgStr, &tempDirGenerator)
 	case image.OciRegistrySource:
 		provider = oci.NewProviderFromRegistry(imgStr, &tempDirGenerator, registryOptions)
+	case image.DirectorySource:
+		// note: the imgStr is the path on disk to a root filesystem directory
+		provider = rootfs.NewProviderFromPath(imgStr, &tempDirGenerator)
+	case image.RemoteArchiveSource:
+		// note: the imgStr is the http(s) URL to a docker or OCI archive
+		provider = remotearchive.NewProviderFromURL(imgStr, &tempDirGenerator)
 	default:
 		return nil, fmt.Errorf("unable determine image source")
 	}
@@ -52,10 +89,12 @@ func GetImageFromSource(imgStr string, source image.Source, registryOptions *ima
 	if err != nil {
 		return nil, fmt.Errorf("unable to use %s source: %w", source, err)
 	}
+	img.AddMetadata(additionalMetadata...)
+	trackImage(img)
 
 	err = img.Read()
 	if err != nil {
-		return nil, fmt.Errorf("could not read image: %+v", err)
+		return nil, fmt.Errorf("could not read image: %w", err)
 	}
 
 	return img, nil
@@ -63,12 +102,12 @@ func GetImageFromSource(imgStr string, source image.Source, registryOptions *ima
 
 // GetImage parses the user provided image string and provides an image object;
 // note: the source where the image should be referenced from is automatically inferred.
-func GetImage(userStr string, registryOptions *image.RegistryOptions) (*image.Image, error) {
+func GetImage(userStr string, registryOptions *image.RegistryOptions, additionalMetadata ...image.AdditionalMetadata) (*image.Image, error) {
 	source, imgStr, err := image.DetectSource(userStr)
 	if err != nil {
 		return nil, err
 	}
-	return GetImageFromSource(imgStr, source, registryOptions)
+	return GetImageFromSource(imgStr, source, registryOptions, additionalMetadata...)
 }
 
 func SetLogger(logger logger.Logger) {
@@ -79,7 +118,60 @@ func SetBus(b *partybus.Bus) {
 	bus.SetPublisher(b)
 }
 
+// progressEvents are the event types that carry the progress of an operation (see SubscribeProgress).
+var progressEvents = []event.Type{
+	event.FetchImage,
+	event.ReadImage,
+	event.ReadLayer,
+	event.DecompressLayer,
+}
+
+// SubscribeProgress calls the given function with the progress of each operation as it starts (fetching an image,
+// reading an image, and reading or decompressing each layer), without the caller needing to wire up an event bus. The
+// function is called from a separate goroutine and the progress continues to update after the function returns. If no
+// bus has been set (see SetBus) then one is created. The returned function stops the subscription.
+func SubscribeProgress(fn func(event.Type, progress.Progressable)) (func(), error) {
+	subscription, err := bus.Subscribe(progressEvents...)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		for e := range subscription.Events() {
+			if prog, ok := e.Value.(progress.Progressable); ok {
+				fn(e.Type, prog)
+			}
+		}
+	}()
+
+	return func() {
+		// note: unsubscribing closes the events channel, however, the error cannot be relied upon since some versions
+		// of partybus report an error even when the subscription was found
+		_ = subscription.Unsubscribe()
+	}, nil
+}
+
+// trackImage records the given image to be cleaned up by Cleanup.
+func trackImage(img *image.Image) {
+	providedImages.Lock()
+	defer providedImages.Unlock()
+	providedImages.images = append(providedImages.images, img)
+}
+
+// Cleanup releases the resources held by all images provided since the last Cleanup (e.g. memory mapped layer tar
+// caches, see image.WithMemoryMappedCache) and removes all temp dirs. The images should not be used afterwards.
 func Cleanup() {
+	providedImages.Lock()
+	images := providedImages.images
+	providedImages.images = nil
+	providedImages.Unlock()
+
+	for _, img := range images {
+		if err := img.Cleanup(); err != nil {
+			log.Errorf("failed to cleanup image: %w", err)
+		}
+	}
+
 	if err := tempDirGenerator.Cleanup(); err != nil {
 		log.Errorf("failed to cleanup: %w", err)
 	}
@@ -0,0 +1,93 @@
+package stereoscope
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/anchore/stereoscope/internal/bus"
+	"github.com/anchore/stereoscope/pkg/event"
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/anchore/stereoscope/pkg/image"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wagoodman/go-partybus"
+	"github.com/wagoodman/go-progress"
+)
+
+func TestSubscribeProgress(t *testing.T) {
+	SetBus(partybus.NewBus())
+
+	received := make(chan event.Type, 10)
+	unsubscribe, err := SubscribeProgress(func(t event.Type, _ progress.Progressable) {
+		received <- t
+	})
+	require.NoError(t, err)
+	defer unsubscribe()
+
+	// events that do not carry progress are not dispatched
+	bus.Publish(partybus.Event{Type: event.PullDockerImage, Value: "not progress"})
+	bus.Publish(partybus.Event{Type: event.ReadLayer, Value: progress.Monitorable(&progress.Manual{})})
+	bus.Publish(partybus.Event{Type: event.ReadImage, Value: progress.Progressable(&progress.Manual{})})
+
+	var actual []event.Type
+	for len(actual) < 2 {
+		select {
+		case e := <-received:
+			actual = append(actual, e)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for progress events (got %+v)", actual)
+		}
+	}
+	assert.Equal(t, []event.Type{event.ReadLayer, event.ReadImage}, actual)
+}
+
+func TestGetImageFromSource_SignatureVerificationUnsupported(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	options := &image.RegistryOptions{SignatureVerificationKey: key.Public()}
+
+	for _, source := range []image.Source{
+		image.DockerTarballSource,
+		image.DockerDaemonSource,
+		image.PodmanDaemonSource,
+		image.OciDirectorySource,
+		image.OciTarballSource,
+		image.DirectorySource,
+		image.RemoteArchiveSource,
+	} {
+		t.Run(source.String(), func(t *testing.T) {
+			img, err := GetImageFromSource("some/location", source, options)
+			assert.ErrorIs(t, err, ErrSignatureVerificationUnsupported)
+			assert.Nil(t, img)
+		})
+	}
+
+	// auto-detection resolving to a local source is rejected as well
+	_, err = GetImage("docker-archive:some/archive.tar", options)
+	assert.ErrorIs(t, err, ErrSignatureVerificationUnsupported)
+}
+
+func TestCleanup_UnmapsProvidedImages(t *testing.T) {
+	v1Image, err := random.Image(1024, 1)
+	require.NoError(t, err)
+
+	img := image.NewImage(v1Image, t.TempDir(), image.WithMemoryMappedCache())
+	require.NoError(t, img.Read())
+	trackImage(img)
+
+	refs := img.SquashedTree().AllFiles(file.TypeReg)
+	require.Len(t, refs, 1)
+	contents, err := img.FileContentsByRef(refs[0])
+	require.NoError(t, err)
+
+	Cleanup()
+
+	_, err = ioutil.ReadAll(contents)
+	assert.ErrorIs(t, err, file.ErrMemoryMapClosed)
+	assert.Empty(t, providedImages.images)
+}
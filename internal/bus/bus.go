@@ -1,6 +1,10 @@
 package bus
 
-import "github.com/wagoodman/go-partybus"
+import (
+	"fmt"
+
+	"github.com/wagoodman/go-partybus"
+)
 
 var publisher partybus.Publisher
 var active bool
@@ -17,3 +21,16 @@ func Publish(event partybus.Event) {
 		publisher.Publish(event)
 	}
 }
+
+// Subscribe subscribes to the given event types on the active publisher. If no publisher has been set then a new bus
+// is created and set as the publisher.
+func Subscribe(types ...partybus.EventType) (*partybus.Subscription, error) {
+	if publisher == nil {
+		SetPublisher(partybus.NewBus())
+	}
+	subscriber, ok := publisher.(partybus.Subscriber)
+	if !ok {
+		return nil, fmt.Errorf("unable to subscribe to events: publisher=%T does not support subscriptions", publisher)
+	}
+	return subscriber.Subscribe(types...), nil
+}
@@ -0,0 +1,26 @@
+package internal
+
+import (
+	"io"
+
+	"github.com/wagoodman/go-progress"
+)
+
+// ProgressReader is an io.Reader that records the number of bytes read onto the given progress monitor.
+type ProgressReader struct {
+	reader  io.Reader
+	monitor *progress.Manual
+}
+
+func NewProgressReader(reader io.Reader, monitor *progress.Manual) *ProgressReader {
+	return &ProgressReader{
+		reader:  reader,
+		monitor: monitor,
+	}
+}
+
+func (r *ProgressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.monitor.N += int64(n)
+	return n, err
+}
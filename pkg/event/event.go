@@ -4,9 +4,13 @@ import (
 	"github.com/wagoodman/go-partybus"
 )
 
+// Type is the type of an event published by stereoscope.
+type Type = partybus.EventType
+
 const (
 	PullDockerImage partybus.EventType = "pull-docker-image-event"
 	FetchImage      partybus.EventType = "fetch-image-event"
 	ReadImage       partybus.EventType = "read-image-event"
 	ReadLayer       partybus.EventType = "read-layer-event"
+	DecompressLayer partybus.EventType = "decompress-layer-event"
 )
@@ -107,3 +107,21 @@ func ParseReadLayer(e partybus.Event) (*image.LayerMetadata, progress.Monitorabl
 
 	return &layerMetadata, prog, nil
 }
+
+func ParseDecompressLayer(e partybus.Event) (*image.LayerMetadata, progress.Monitorable, error) {
+	if err := checkEventType(e.Type, event.DecompressLayer); err != nil {
+		return nil, nil, err
+	}
+
+	layerMetadata, ok := e.Source.(image.LayerMetadata)
+	if !ok {
+		return nil, nil, newPayloadErr(e.Type, "Source", e.Source)
+	}
+
+	prog, ok := e.Value.(progress.Monitorable)
+	if !ok {
+		return nil, nil, newPayloadErr(e.Type, "Value", e.Value)
+	}
+
+	return &layerMetadata, prog, nil
+}
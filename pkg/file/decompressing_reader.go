@@ -0,0 +1,107 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrUnsupportedCompression is returned when the content is compressed with a known format that cannot be decompressed.
+var ErrUnsupportedCompression = errors.New("unsupported compression format")
+
+// Compression is a single-file compression format, detected by the magic bytes at the start of the content.
+type Compression string
+
+const (
+	NoCompression    Compression = ""
+	GzipCompression  Compression = "gzip"
+	Bzip2Compression Compression = "bzip2"
+	XzCompression    Compression = "xz"
+)
+
+var compressionMagic = []struct {
+	compression Compression
+	magic       []byte
+	// next, when given, must also accept the byte immediately following the magic bytes
+	next func(byte) bool
+}{
+	{compression: GzipCompression, magic: []byte{0x1f, 0x8b}},
+	// the bzip2 magic is followed by the block size ('1' to '9', in units of 100k)
+	{compression: Bzip2Compression, magic: []byte("BZh"), next: func(b byte) bool { return b >= '1' && b <= '9' }},
+	{compression: XzCompression, magic: []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+}
+
+// decompressingReadCloser is a io.ReadCloser that reads decompressed content while closing the original reader.
+type decompressingReadCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (d *decompressingReadCloser) Close() error {
+	var err error
+	for _, c := range d.closers {
+		if closeErr := c.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// NewDecompressingReader wraps the given reader such that gzip and bzip2 compressed content is transparently
+// decompressed, returning the detected compression format. Content that is not compressed is returned unchanged
+// (with NoCompression). Note that xz support is partial: xz content is detected, however, since xz decompression is
+// not available in the standard library it results in an ErrUnsupportedCompression error (the detected format is
+// still returned). Only the returned reader should be used
+// (and closed) afterwards, which closes the given reader. If an error is returned then the given reader has already
+// been closed.
+//
+// Note: this only affects what is read, any digest of the file (e.g. from the catalog) is still based on the
+// original (compressed) bytes.
+func NewDecompressingReader(reader io.ReadCloser) (io.ReadCloser, Compression, error) {
+	buffered := bufio.NewReader(reader)
+	compression := detectCompression(buffered)
+
+	switch compression {
+	case GzipCompression:
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			_ = reader.Close()
+			return nil, compression, fmt.Errorf("unable to read gzip content: %w", err)
+		}
+		return &decompressingReadCloser{Reader: gzipReader, closers: []io.Closer{gzipReader, reader}}, compression, nil
+	case Bzip2Compression:
+		return &decompressingReadCloser{Reader: bzip2.NewReader(buffered), closers: []io.Closer{reader}}, compression, nil
+	case XzCompression:
+		_ = reader.Close()
+		return nil, compression, fmt.Errorf("%w: %s", ErrUnsupportedCompression, compression)
+	}
+
+	return &decompressingReadCloser{Reader: buffered, closers: []io.Closer{reader}}, NoCompression, nil
+}
+
+// detectCompression peeks at the start of the given reader to determine the compression format (without consuming
+// any content).
+func detectCompression(reader *bufio.Reader) Compression {
+	for _, candidate := range compressionMagic {
+		size := len(candidate.magic)
+		if candidate.next != nil {
+			size++
+		}
+		header, err := reader.Peek(size)
+		if err != nil {
+			continue
+		}
+		if !bytes.Equal(header[:len(candidate.magic)], candidate.magic) {
+			continue
+		}
+		if candidate.next != nil && !candidate.next(header[len(candidate.magic)]) {
+			continue
+		}
+		return candidate.compression
+	}
+	return NoCompression
+}
@@ -0,0 +1,130 @@
+package file
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// bzip2 compressed "hello world!\n" (from `echo "hello world!" | bzip2`)
+var bzip2HelloWorld = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0xa2, 0x9d, 0x5a, 0x47, 0x00, 0x00,
+	0x02, 0xd1, 0x80, 0x00, 0x10, 0x60, 0x00, 0x06, 0x44, 0x90, 0x80, 0x20, 0x00, 0x31, 0x00, 0x30,
+	0x20, 0x34, 0x62, 0x59, 0x04, 0xea, 0x42, 0x19, 0x7e, 0x2e, 0xe4, 0x8a, 0x70, 0xa1, 0x21, 0x45,
+	0x3a, 0xb4, 0x8e,
+}
+
+func gzipped(t *testing.T, contents string) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	writer := gzip.NewWriter(buf)
+	if _, err := writer.Write([]byte(contents)); err != nil {
+		t.Fatalf("could not write gzip content: %+v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("could not close gzip writer: %+v", err)
+	}
+	return buf.Bytes()
+}
+
+// closeTrackingReader records whether the reader has been closed.
+type closeTrackingReader struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeTrackingReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestNewDecompressingReader(t *testing.T) {
+	tests := []struct {
+		name                string
+		input               []byte
+		expected            string
+		expectedCompression Compression
+		expectedErr         error
+	}{
+		{
+			name:                "uncompressed",
+			input:               []byte("hello world!\n"),
+			expected:            "hello world!\n",
+			expectedCompression: NoCompression,
+		},
+		{
+			name:                "short uncompressed",
+			input:               []byte("h"),
+			expected:            "h",
+			expectedCompression: NoCompression,
+		},
+		{
+			name:                "empty",
+			input:               []byte{},
+			expected:            "",
+			expectedCompression: NoCompression,
+		},
+		{
+			name:                "gzip",
+			input:               gzipped(t, "hello world!\n"),
+			expected:            "hello world!\n",
+			expectedCompression: GzipCompression,
+		},
+		{
+			name:                "bzip2",
+			input:               bzip2HelloWorld,
+			expected:            "hello world!\n",
+			expectedCompression: Bzip2Compression,
+		},
+		{
+			name:                "text starting with the bzip2 magic",
+			input:               []byte("BZhello world!\n"),
+			expected:            "BZhello world!\n",
+			expectedCompression: NoCompression,
+		},
+		{
+			name:                "bzip2 magic without a block size",
+			input:               []byte("BZh"),
+			expected:            "BZh",
+			expectedCompression: NoCompression,
+		},
+		{
+			name:                "xz",
+			input:               []byte{0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00, 0x04},
+			expectedCompression: XzCompression,
+			expectedErr:         ErrUnsupportedCompression,
+		},
+		{
+			name:                "truncated gzip header",
+			input:               []byte{0x1f, 0x8b, 0x08},
+			expectedCompression: GzipCompression,
+			expectedErr:         io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := &closeTrackingReader{Reader: bytes.NewReader(test.input)}
+			reader, compression, err := NewDecompressingReader(source)
+			assert.Equal(t, test.expectedCompression, compression)
+			if test.expectedErr != nil {
+				assert.True(t, errors.Is(err, test.expectedErr), "expected %v, got: %+v", test.expectedErr, err)
+				assert.True(t, source.closed, "expected the source reader to be closed")
+				return
+			}
+			assert.NoError(t, err)
+
+			actual, err := ioutil.ReadAll(reader)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, string(actual))
+			assert.False(t, source.closed)
+			assert.NoError(t, reader.Close())
+			assert.True(t, source.closed)
+		})
+	}
+}
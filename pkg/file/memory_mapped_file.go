@@ -0,0 +1,119 @@
+package file
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// ErrMemoryMapUnsupported is returned when memory mapping files is not supported on the current platform, or when a
+// file is too large to be mapped into the address space of the current platform.
+var ErrMemoryMapUnsupported = fmt.Errorf("memory mapped files are not supported on this platform")
+
+// ErrMemoryMapClosed is returned when reading from a memory mapped file that has been unmapped.
+var ErrMemoryMapClosed = fmt.Errorf("memory mapped file has been unmapped")
+
+var _ io.ReadCloser = (*memoryMappedSection)(nil)
+
+// MemoryMappedFile is a read-only memory mapping of an entire file. Any number of readers may read from the mapping
+// concurrently; once the file is unmapped (see Close) all reads fail with ErrMemoryMapClosed.
+type MemoryMappedFile struct {
+	// lock guards the mapped data against being unmapped while it is being read
+	lock   sync.RWMutex
+	data   []byte
+	closed bool
+}
+
+// MapFile maps the entire contents of the file at the given path into memory (read-only). The file should not be
+// modified while mapped.
+func MapFile(path string) (*MemoryMappedFile, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	info, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &MemoryMappedFile{}
+	if info.Size() == 0 {
+		// empty files cannot be mapped, however, there is nothing to read either
+		return m, nil
+	}
+
+	m.data, err = mmapFile(fh, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("unable to map file=%q: %w", path, err)
+	}
+	return m, nil
+}
+
+// Section returns a reader for the given number of bytes of the mapped file starting at the given offset.
+func (m *MemoryMappedFile) Section(offset, size int64) io.ReadCloser {
+	return &memoryMappedSection{
+		file:   m,
+		offset: offset,
+		end:    offset + size,
+	}
+}
+
+// Close unmaps the file. It is safe to call Close more than once.
+func (m *MemoryMappedFile) Close() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+
+	data := m.data
+	m.data = nil
+	if data == nil {
+		return nil
+	}
+	return munmapFile(data)
+}
+
+// readAt copies mapped content at the given offset (bounded by the given end offset) into the given buffer.
+func (m *MemoryMappedFile) readAt(b []byte, offset, end int64) (int, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if m.closed {
+		return 0, ErrMemoryMapClosed
+	}
+	if end > int64(len(m.data)) {
+		end = int64(len(m.data))
+	}
+	if offset >= end {
+		return 0, io.EOF
+	}
+	return copy(b, m.data[offset:end]), nil
+}
+
+// memoryMappedSection is a reader for a contiguous section of a memory mapped file.
+type memoryMappedSection struct {
+	file   *MemoryMappedFile
+	offset int64
+	end    int64
+}
+
+// Read implements the io.Reader interface, copying content out of the mapping.
+func (s *memoryMappedSection) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	n, err := s.file.readAt(b, s.offset, s.end)
+	s.offset += int64(n)
+	return n, err
+}
+
+// Close implements the io.Closer interface (the mapping itself is left intact).
+func (s *memoryMappedSection) Close() error {
+	return nil
+}
@@ -0,0 +1,98 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryMappedFile_Section(t *testing.T) {
+	p := "test-fixtures/a-file.txt"
+	expected, err := ioutil.ReadFile(p)
+	require.NoError(t, err)
+
+	mapped, err := MapFile(p)
+	require.NoError(t, err)
+	defer mapped.Close()
+
+	tests := []struct {
+		name     string
+		offset   int64
+		size     int64
+		expected string
+	}{
+		{
+			name:     "entire file",
+			size:     int64(len(expected)),
+			expected: string(expected),
+		},
+		{
+			name:     "part of the file",
+			offset:   2,
+			size:     5,
+			expected: string(expected[2:7]),
+		},
+		{
+			name:     "section beyond the end of the file is truncated",
+			offset:   int64(len(expected)) - 1,
+			size:     10,
+			expected: string(expected[len(expected)-1:]),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := ioutil.ReadAll(mapped.Section(test.offset, test.size))
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, string(actual))
+		})
+	}
+}
+
+func TestMemoryMappedFile_ConcurrentReadsAndClose(t *testing.T) {
+	p := "test-fixtures/a-file.txt"
+	expected, err := ioutil.ReadFile(p)
+	require.NoError(t, err)
+
+	mapped, err := MapFile(p)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			actual, err := ioutil.ReadAll(mapped.Section(0, int64(len(expected))))
+			assert.NoError(t, err)
+			assert.Equal(t, expected, actual)
+		}()
+	}
+	wg.Wait()
+
+	reader := mapped.Section(0, int64(len(expected)))
+	require.NoError(t, mapped.Close())
+	require.NoError(t, mapped.Close())
+
+	_, err = ioutil.ReadAll(reader)
+	assert.ErrorIs(t, err, ErrMemoryMapClosed)
+}
+
+func TestMemoryMappedFile_EmptyFile(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "empty")
+	require.NoError(t, ioutil.WriteFile(p, nil, 0600))
+
+	mapped, err := MapFile(p)
+	require.NoError(t, err)
+
+	actual, err := ioutil.ReadAll(mapped.Section(0, 0))
+	require.NoError(t, err)
+	assert.Empty(t, actual)
+	assert.NoError(t, mapped.Close())
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package file
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func mmapFile(fh *os.File, size int64) ([]byte, error) {
+	if int64(int(size)) != size {
+		return nil, fmt.Errorf("%w: file is too large to map (size=%d)", ErrMemoryMapUnsupported, size)
+	}
+	return syscall.Mmap(int(fh.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmapFile(data []byte) error {
+	return syscall.Munmap(data)
+}
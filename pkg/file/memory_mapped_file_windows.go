@@ -0,0 +1,16 @@
+//go:build windows
+// +build windows
+
+package file
+
+import (
+	"os"
+)
+
+func mmapFile(*os.File, int64) ([]byte, error) {
+	return nil, ErrMemoryMapUnsupported
+}
+
+func munmapFile([]byte) error {
+	return nil
+}
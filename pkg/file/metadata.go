@@ -5,6 +5,7 @@ import (
 	"io"
 	"os"
 	"path"
+	"time"
 )
 
 // Metadata represents all file metadata of interest (used today for in-tar file resolution).
@@ -26,10 +27,18 @@ type Metadata struct {
 	IsDir    bool
 	Mode     os.FileMode
 	MIMEType string
+	// ModTime is the modification time of the file as recorded in the tar header
+	ModTime time.Time
+	// Digest is the sha256 digest of the file contents (e.g. "sha256:..."), populated only for regular files when
+	// digests are computed as the file is read
+	Digest string
+	// DevMajor and DevMinor are the device numbers, populated only for character and block device entries
+	DevMajor int64
+	DevMinor int64
 }
 
 func NewMetadata(header tar.Header, sequence int64, content io.Reader) Metadata {
-	return Metadata{
+	m := Metadata{
 		Path:          path.Clean(DirSeparator + header.Name),
 		TarHeaderName: header.Name,
 		TarSequence:   sequence,
@@ -41,5 +50,13 @@ func NewMetadata(header tar.Header, sequence int64, content io.Reader) Metadata
 		GroupID:       header.Gid,
 		IsDir:         header.FileInfo().IsDir(),
 		MIMEType:      MIMEType(content),
+		ModTime:       header.ModTime,
 	}
+
+	if Type(header.Typeflag).IsDevice() {
+		m.DevMajor = header.Devmajor
+		m.DevMinor = header.Devminor
+	}
+
+	return m
 }
@@ -4,10 +4,12 @@
 package file
 
 import (
+	"archive/tar"
 	"io"
 	"os"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-test/deep"
 )
@@ -31,7 +33,13 @@ func TestFileMetadataFromTar(t *testing.T) {
 		if strings.HasSuffix(entry.Header.Name, ".txt") {
 			contents = strings.NewReader("#!/usr/bin/env bash\necho 'awesome script'")
 		}
-		actual = append(actual, NewMetadata(entry.Header, entry.Sequence, contents))
+		metadata := NewMetadata(entry.Header, entry.Sequence, contents)
+		// the modification time depends on when the fixture was generated
+		if metadata.ModTime.IsZero() {
+			t.Errorf("missing modification time for %q", entry.Header.Name)
+		}
+		metadata.ModTime = time.Time{}
+		actual = append(actual, metadata)
 		return nil
 	}
 
@@ -43,3 +51,73 @@ func TestFileMetadataFromTar(t *testing.T) {
 		t.Errorf("diff: %s", d)
 	}
 }
+
+func TestFileMetadata_DeviceNumbers(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   tar.Header
+		expected Metadata
+	}{
+		{
+			name: "char device",
+			header: tar.Header{
+				Name:     "dev/null",
+				Typeflag: tar.TypeChar,
+				Mode:     0o666,
+				Devmajor: 1,
+				Devminor: 3,
+			},
+			expected: Metadata{
+				Path:          "/dev/null",
+				TarHeaderName: "dev/null",
+				TypeFlag:      tar.TypeChar,
+				Mode:          os.ModeDevice | os.ModeCharDevice | 0o666,
+				DevMajor:      1,
+				DevMinor:      3,
+			},
+		},
+		{
+			name: "block device",
+			header: tar.Header{
+				Name:     "dev/sda",
+				Typeflag: tar.TypeBlock,
+				Mode:     0o660,
+				Devmajor: 8,
+				Devminor: 0,
+			},
+			expected: Metadata{
+				Path:          "/dev/sda",
+				TarHeaderName: "dev/sda",
+				TypeFlag:      tar.TypeBlock,
+				Mode:          os.ModeDevice | 0o660,
+				DevMajor:      8,
+				DevMinor:      0,
+			},
+		},
+		{
+			name: "regular file ignores device numbers",
+			header: tar.Header{
+				Name:     "etc/hosts",
+				Typeflag: tar.TypeReg,
+				Mode:     0o644,
+				Devmajor: 1,
+				Devminor: 3,
+			},
+			expected: Metadata{
+				Path:          "/etc/hosts",
+				TarHeaderName: "etc/hosts",
+				TypeFlag:      tar.TypeReg,
+				Mode:          0o644,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := NewMetadata(test.header, 0, nil)
+			for _, d := range deep.Equal(test.expected, actual) {
+				t.Errorf("diff: %s", d)
+			}
+		})
+	}
+}
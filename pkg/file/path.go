@@ -8,8 +8,10 @@ import (
 
 const (
 	WhiteoutPrefix = ".wh."
-	OpaqueWhiteout = WhiteoutPrefix + WhiteoutPrefix + ".opq"
-	DirSeparator   = "/"
+	// WhiteoutMetaPrefix is the prefix used by AUFS for its own bookkeeping entries (e.g. .wh..wh.plnk, .wh..wh.aufs)
+	WhiteoutMetaPrefix = WhiteoutPrefix + WhiteoutPrefix
+	OpaqueWhiteout     = WhiteoutMetaPrefix + ".opq"
+	DirSeparator       = "/"
 )
 
 // Path represents a file path
@@ -33,6 +35,17 @@ func (p Path) Normalize() Path {
 	return Path(path.Clean(trimmed))
 }
 
+// NormalizeForLookup returns the normalized absolute path to use when looking up the given path within a file tree:
+// dot segments are resolved, trailing slashes are removed, and relative paths are treated as relative to root
+// (e.g. "etc/./passwd/" becomes "/etc/passwd").
+func (p Path) NormalizeForLookup() Path {
+	normalized := p.Normalize()
+	if normalized.IsAbsolutePath() {
+		return normalized
+	}
+	return Path(DirSeparator + string(normalized)).Normalize()
+}
+
 func (p Path) IsAbsolutePath() bool {
 	return strings.HasPrefix(string(p), DirSeparator)
 }
@@ -52,6 +65,13 @@ func (p Path) IsWhiteout() bool {
 	return strings.HasPrefix(p.Basename(), WhiteoutPrefix)
 }
 
+// IsWhiteoutMetadata indicates if the path basename is an AUFS metadata entry (e.g. the hardlink directory .wh..wh.plnk
+// or the .wh..wh.aufs marker). These are not whiteouts of any real file and should be ignored during squashing.
+func (p Path) IsWhiteoutMetadata() bool {
+	basename := p.Basename()
+	return strings.HasPrefix(basename, WhiteoutMetaPrefix) && basename != OpaqueWhiteout
+}
+
 // UnWhiteoutPath is a representation of the current path with no whiteout prefixes
 func (p Path) UnWhiteoutPath() (Path, error) {
 	basename := p.Basename()
@@ -0,0 +1,41 @@
+package file
+
+import "sync"
+
+// PathInterner deduplicates path strings so that equal paths (e.g. the same directory found in many layer and squash
+// trees) share a single backing string in memory. Interned paths are still plain Path values. It is safe for
+// concurrent use.
+//
+// Whole paths are interned rather than individual path segments: a Path is a single contiguous string, so distinct paths
+// cannot share the storage of a common prefix without changing the Path representation. The duplication removed is that
+// of the same path held by each layer tree, squash tree, and catalog entry (see BenchmarkImage_Read_InternedPaths).
+type PathInterner struct {
+	lock  sync.Mutex
+	paths map[Path]Path
+}
+
+// NewPathInterner returns an empty PathInterner.
+func NewPathInterner() *PathInterner {
+	return &PathInterner{
+		paths: make(map[Path]Path),
+	}
+}
+
+// Intern returns the canonical instance of the given path, storing the path as the canonical instance if it has not
+// been seen before.
+func (i *PathInterner) Intern(p Path) Path {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	if existing, ok := i.paths[p]; ok {
+		return existing
+	}
+	i.paths[p] = p
+	return p
+}
+
+// Len returns the number of distinct paths that have been interned.
+func (i *PathInterner) Len() int {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	return len(i.paths)
+}
@@ -0,0 +1,34 @@
+package file
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// stringData returns the address of the backing array of the given path.
+func stringData(p Path) uintptr {
+	// nolint: gosec
+	return (*reflect.StringHeader)(unsafe.Pointer(&p)).Data
+}
+
+func TestPathInterner_Intern(t *testing.T) {
+	interner := NewPathInterner()
+
+	// build equal paths with distinct backing arrays
+	first := Path(strings.Join([]string{"", "usr", "lib"}, "/"))
+	second := Path(strings.Join([]string{"", "usr", "lib"}, "/"))
+	assert.NotEqual(t, stringData(first), stringData(second))
+
+	internedFirst := interner.Intern(first)
+	internedSecond := interner.Intern(second)
+	other := interner.Intern("/usr/bin")
+
+	assert.Equal(t, first, internedSecond)
+	assert.Equal(t, stringData(internedFirst), stringData(internedSecond))
+	assert.Equal(t, Path("/usr/bin"), other)
+	assert.Equal(t, 2, interner.Len())
+}
@@ -56,6 +56,69 @@ func TestPath_Normalize(t *testing.T) {
 	}
 }
 
+func TestPath_NormalizeForLookup(t *testing.T) {
+	cases := []struct {
+		name     string
+		path     string
+		expected string
+	}{
+		{
+			name:     "already normalized",
+			path:     "/etc/passwd",
+			expected: "/etc/passwd",
+		},
+		{
+			name:     "dot segment",
+			path:     "/etc/./passwd",
+			expected: "/etc/passwd",
+		},
+		{
+			name:     "parent segment",
+			path:     "/usr/lib/../bin/env",
+			expected: "/usr/bin/env",
+		},
+		{
+			name:     "parent segment above root",
+			path:     "/../../etc/passwd",
+			expected: "/etc/passwd",
+		},
+		{
+			name:     "trailing slash",
+			path:     "/usr/bin/",
+			expected: "/usr/bin",
+		},
+		{
+			name:     "relative path",
+			path:     "etc/passwd",
+			expected: "/etc/passwd",
+		},
+		{
+			name:     "relative path with dot segments",
+			path:     "./etc/../etc/passwd/",
+			expected: "/etc/passwd",
+		},
+		{
+			name:     "empty path",
+			path:     "",
+			expected: "/",
+		},
+		{
+			name:     "dot",
+			path:     ".",
+			expected: "/",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := Path(c.path).NormalizeForLookup()
+			if got != Path(c.expected) {
+				t.Errorf("Didn't normalize correctly ('%v' != '%v')", got, c.expected)
+			}
+		})
+	}
+}
+
 func TestPath_AllPaths(t *testing.T) {
 	path := Path("/some/path/to/a/file.txt")
 	expected := []Path{
@@ -134,6 +197,27 @@ func TestPath_Whiteout(t *testing.T) {
 	}
 }
 
+func TestPath_IsWhiteoutMetadata(t *testing.T) {
+	tests := []struct {
+		path     Path
+		expected bool
+	}{
+		{path: "/some/path/.wh..wh.plnk", expected: true},
+		{path: "/.wh..wh.aufs", expected: true},
+		{path: "/some/path/.wh..wh..opq", expected: false},
+		{path: "/some/path/.wh.afile", expected: false},
+		{path: "/some/path/afile", expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.path), func(t *testing.T) {
+			if actual := test.path.IsWhiteoutMetadata(); actual != test.expected {
+				t.Errorf("unexpected result: %v != %v", actual, test.expected)
+			}
+		})
+	}
+}
+
 func TestPath_UnWhiteoutPath(t *testing.T) {
 	path := Path("/some/path/to/.wh..wh..opq")
 
@@ -5,4 +5,5 @@ const (
 	KB = 1 << (10 * iota)
 	MB
 	GB
+	TB
 )
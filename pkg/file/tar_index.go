@@ -55,6 +55,25 @@ func NewTarIndex(tarFilePath string, onIndex TarIndexVisitor) (*TarIndex, error)
 	return t, IterateTar(tarFileHandle, visitor)
 }
 
+// CheckEntry verifies that the contents of the entry with the given tar header name and sequence are still available
+// within the indexed tar file, without reading the contents (e.g. to detect a tar file that was removed or truncated
+// after indexing). If no entry exists for the given name, all entries are searched for the sequence.
+func (t *TarIndex) CheckEntry(name string, sequence int64) error {
+	for _, entry := range t.indexByName[name] {
+		if entry.sequence == sequence {
+			return entry.check()
+		}
+	}
+	for _, entries := range t.indexByName {
+		for _, entry := range entries {
+			if entry.sequence == sequence {
+				return entry.check()
+			}
+		}
+	}
+	return fmt.Errorf("no tar entry=%q with sequence=%d", name, sequence)
+}
+
 // EntriesByName fetches all TarFileEntries for the given tar header name.
 func (t *TarIndex) EntriesByName(name string) ([]TarFileEntry, error) {
 	if indexes, exists := t.indexByName[name]; exists {
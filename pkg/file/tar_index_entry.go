@@ -2,7 +2,9 @@ package file
 
 import (
 	"archive/tar"
+	"fmt"
 	"io"
+	"os"
 )
 
 type TarIndexEntry struct {
@@ -23,3 +25,20 @@ func (t *TarIndexEntry) ToTarFileEntry() TarFileEntry {
 func (t *TarIndexEntry) Open() io.ReadCloser {
 	return newLazyBoundedReadCloser(t.path, t.seekPosition, t.header.Size)
 }
+
+// OpenMapped returns a reader for the entry contents from the given memory mapping of the indexed tar file.
+func (t *TarIndexEntry) OpenMapped(m *MemoryMappedFile) io.ReadCloser {
+	return m.Section(t.seekPosition, t.header.Size)
+}
+
+// check verifies that the entry contents are present within the indexed tar file (without reading the contents).
+func (t *TarIndexEntry) check() error {
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return err
+	}
+	if end := t.seekPosition + t.header.Size; info.Size() < end {
+		return fmt.Errorf("tar file=%q is truncated: size=%d but entry=%q ends at %d", t.path, info.Size(), t.header.Name, end)
+	}
+	return nil
+}
@@ -13,6 +13,9 @@ import (
 
 const perFileReadLimit = 2 * GB
 
+// maxTarEntrySize is the largest entry size that is considered valid, anything larger indicates a malformed header
+const maxTarEntrySize = 1 * TB
+
 var ErrTarStopIteration = fmt.Errorf("halt iterating tar")
 
 // tarFile is a ReadCloser of a tar file on disk.
@@ -40,9 +43,40 @@ func (e *ErrFileNotFound) Error() string {
 	return fmt.Sprintf("file not found (path=%s)", e.Path)
 }
 
+// ErrMalformedTar is returned from IterateTar when the tar stream cannot be trusted, such as a truncated stream, a
+// corrupt header, or a header declaring an invalid entry size.
+type ErrMalformedTar struct {
+	Sequence int64
+	Name     string
+	Err      error
+}
+
+func (e *ErrMalformedTar) Error() string {
+	if e.Name != "" {
+		return fmt.Sprintf("malformed tar at entry=%d (name=%q): %v", e.Sequence, e.Name, e.Err)
+	}
+	return fmt.Sprintf("malformed tar at entry=%d: %v", e.Sequence, e.Err)
+}
+
+func (e *ErrMalformedTar) Unwrap() error {
+	return e.Err
+}
+
+// validateTarHeader checks header fields that would otherwise cause unbounded reads or allocations downstream.
+func validateTarHeader(hdr *tar.Header) error {
+	if hdr.Size < 0 {
+		return fmt.Errorf("negative entry size: %d", hdr.Size)
+	}
+	if hdr.Size > maxTarEntrySize {
+		return fmt.Errorf("entry size exceeds maximum (%d > %d)", hdr.Size, int64(maxTarEntrySize))
+	}
+	return nil
+}
+
 // IterateTar is a function that reads across a tar and invokes a visitor function for each entry discovered. The iterator
 // stops when there are no more entries to read, if there is an error in the underlying reader or visitor function,
-// or if the visitor function returns a ErrTarStopIteration sentinel error.
+// or if the visitor function returns a ErrTarStopIteration sentinel error. A truncated or corrupt tar results in an
+// ErrMalformedTar error.
 func IterateTar(reader io.Reader, visitor TarFileVisitor) error {
 	tarReader := tar.NewReader(reader)
 	var sequence int64 = -1
@@ -53,6 +87,9 @@ func IterateTar(reader io.Reader, visitor TarFileVisitor) error {
 		if errors.Is(err, io.EOF) {
 			break
 		}
+		if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, tar.ErrHeader) {
+			return &ErrMalformedTar{Sequence: sequence, Err: err}
+		}
 		if err != nil {
 			return err
 		}
@@ -60,6 +97,10 @@ func IterateTar(reader io.Reader, visitor TarFileVisitor) error {
 			continue
 		}
 
+		if err := validateTarHeader(hdr); err != nil {
+			return &ErrMalformedTar{Sequence: sequence, Name: hdr.Name, Err: err}
+		}
+
 		if err := visitor(TarFileEntry{
 			Sequence: sequence,
 			Header:   *hdr,
@@ -68,6 +109,9 @@ func IterateTar(reader io.Reader, visitor TarFileVisitor) error {
 			if errors.Is(err, ErrTarStopIteration) {
 				return nil
 			}
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return &ErrMalformedTar{Sequence: sequence, Name: hdr.Name, Err: err}
+			}
 			return fmt.Errorf("failed to visit tar entry=%q : %w", hdr.Name, err)
 		}
 	}
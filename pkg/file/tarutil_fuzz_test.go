@@ -0,0 +1,30 @@
+//go:build go1.18 && !windows
+// +build go1.18,!windows
+
+package file
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func FuzzIterateTar(f *testing.F) {
+	valid := newTarBytes(f, "a.txt", "dir/b.txt")
+	f.Add(valid)
+	f.Add(valid[:512+5])
+	f.Add(valid[:100])
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		visitor := func(entry TarFileEntry) error {
+			_ = NewMetadata(entry.Header, entry.Sequence, nil)
+			_, err := io.Copy(ioutil.Discard, entry.Reader)
+			return err
+		}
+
+		// malformed input must never panic or hang, only return an error
+		_ = IterateTar(bytes.NewReader(data), visitor)
+	})
+}
@@ -4,7 +4,10 @@
 package file
 
 import (
+	"archive/tar"
+	"bytes"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -13,6 +16,7 @@ import (
 	"path"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -99,6 +103,9 @@ func TestMetadataFromTar(t *testing.T) {
 			f := getTarFixture(t, "fixture-1")
 			metadata, err := MetadataFromTar(f, test.name)
 			assert.NoError(t, err)
+			// the modification time depends on when the fixture was generated
+			assert.False(t, metadata.ModTime.IsZero())
+			metadata.ModTime = time.Time{}
 			assert.Equal(t, test.expected, metadata)
 		})
 	}
@@ -179,3 +186,89 @@ func fileExists(t testing.TB, filename string) bool {
 	}
 	return !info.IsDir()
 }
+
+// newTarBytes creates an in-memory tar with a regular file entry for each of the given names.
+func newTarBytes(t testing.TB, names ...string) []byte {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	writer := tar.NewWriter(buf)
+	for _, name := range names {
+		contents := []byte("contents of " + name)
+		if err := writer.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(contents))}); err != nil {
+			t.Fatalf("could not write header: %+v", err)
+		}
+		if _, err := writer.Write(contents); err != nil {
+			t.Fatalf("could not write contents: %+v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("could not close tar writer: %+v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestIterateTar_Malformed(t *testing.T) {
+	valid := newTarBytes(t, "a.txt", "b.txt")
+
+	oversized := &bytes.Buffer{}
+	writer := tar.NewWriter(oversized)
+	if err := writer.WriteHeader(&tar.Header{Name: "huge.bin", Typeflag: tar.TypeReg, Mode: 0644, Size: 2 * TB}); err != nil {
+		t.Fatalf("could not write header: %+v", err)
+	}
+
+	corruptHeader := make([]byte, 1024)
+	copy(corruptHeader, valid[:512])
+	// invalidate the header checksum
+	copy(corruptHeader[148:156], "garbage!")
+
+	tests := []struct {
+		name      string
+		input     []byte
+		malformed bool
+	}{
+		{
+			name:  "valid tar",
+			input: valid,
+		},
+		{
+			name:      "truncated mid-entry",
+			input:     valid[:512+5],
+			malformed: true,
+		},
+		{
+			name:      "truncated mid-header",
+			input:     valid[:100],
+			malformed: true,
+		},
+		{
+			name:      "corrupt header",
+			input:     corruptHeader,
+			malformed: true,
+		},
+		{
+			name:      "absurd entry size",
+			input:     oversized.Bytes(),
+			malformed: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			visitor := func(entry TarFileEntry) error {
+				_, err := ioutil.ReadAll(entry.Reader)
+				return err
+			}
+
+			err := IterateTar(bytes.NewReader(test.input), visitor)
+			if !test.malformed {
+				assert.NoError(t, err)
+				return
+			}
+
+			var malformedErr *ErrMalformedTar
+			if !errors.As(err, &malformedErr) {
+				t.Fatalf("expected ErrMalformedTar, got: %+v", err)
+			}
+		})
+	}
+}
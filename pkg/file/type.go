@@ -23,3 +23,38 @@ var AllTypes = []Type{
 }
 
 type Type rune
+
+// TypeFromTarType returns the file type for the given tar header typeflag. Older tar archives mark regular files with
+// a NUL typeflag (tar.TypeRegA), which is mapped to TypeReg. Any other typeflag is returned as-is.
+func TypeFromTarType(typeFlag byte) Type {
+	if typeFlag == 0 {
+		return TypeReg
+	}
+	return Type(typeFlag)
+}
+
+// IsDevice indicates if the type represents a character or block device node.
+func (t Type) IsDevice() bool {
+	return t == TypeCharacterDevice || t == TypeBlockDevice
+}
+
+// String returns a human readable name for the file type.
+func (t Type) String() string {
+	switch t {
+	case TypeReg:
+		return "RegularFile"
+	case TypeDir:
+		return "Directory"
+	case TypeSymlink:
+		return "SymbolicLink"
+	case TypeHardLink:
+		return "HardLink"
+	case TypeCharacterDevice:
+		return "CharacterDevice"
+	case TypeBlockDevice:
+		return "BlockDevice"
+	case TypeFifo:
+		return "FIFONode"
+	}
+	return "Unknown"
+}
@@ -0,0 +1,34 @@
+package file
+
+import (
+	"archive/tar"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTypeFromTarType(t *testing.T) {
+	tests := []struct {
+		typeFlag byte
+		expected Type
+		name     string
+	}{
+		{typeFlag: tar.TypeReg, expected: TypeReg, name: "RegularFile"},
+		{typeFlag: 0, expected: TypeReg, name: "RegularFile"},
+		{typeFlag: tar.TypeDir, expected: TypeDir, name: "Directory"},
+		{typeFlag: tar.TypeSymlink, expected: TypeSymlink, name: "SymbolicLink"},
+		{typeFlag: tar.TypeLink, expected: TypeHardLink, name: "HardLink"},
+		{typeFlag: tar.TypeChar, expected: TypeCharacterDevice, name: "CharacterDevice"},
+		{typeFlag: tar.TypeBlock, expected: TypeBlockDevice, name: "BlockDevice"},
+		{typeFlag: tar.TypeFifo, expected: TypeFifo, name: "FIFONode"},
+		{typeFlag: tar.TypeXGlobalHeader, expected: Type(tar.TypeXGlobalHeader), name: "Unknown"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual := TypeFromTarType(test.typeFlag)
+			assert.Equal(t, test.expected, actual)
+			assert.Equal(t, test.name, actual.String())
+		})
+	}
+}
@@ -50,6 +50,14 @@ func NewHardLink(p, linkPath file.Path, ref *file.Reference) *FileNode {
 	}
 }
 
+func NewDevice(p file.Path, deviceType file.Type, ref *file.Reference) *FileNode {
+	return &FileNode{
+		RealPath:  p,
+		FileType:  deviceType,
+		Reference: ref,
+	}
+}
+
 func (n *FileNode) ID() node.ID {
 	return IDByPath(n.RealPath)
 }
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/anchore/stereoscope/internal"
@@ -18,9 +19,17 @@ import (
 var ErrRemovingRoot = errors.New("cannot remove the root path (`/`) from the FileTree")
 var ErrLinkCycleDetected = errors.New("cycle during symlink resolution")
 
+// maxLinkResolutionDepth bounds how deeply link resolution may recurse (through links found in ancestors of link
+// destinations), which guards against cycles that span multiple links.
+const maxLinkResolutionDepth = 40
+
 // FileTree represents a file/directory Tree
 type FileTree struct {
 	tree *tree.Tree
+	// resolutionRoot is the path that symlink destinations are resolved relative to (empty means the tree root)
+	resolutionRoot file.Path
+	// interner optionally deduplicates the path strings stored for each node
+	interner *file.PathInterner
 }
 
 // NewFileTree creates a new FileTree instance.
@@ -35,13 +44,43 @@ func NewFileTree() *FileTree {
 	}
 }
 
-// Copy returns a Copy of the current FileTree.
+// Copy returns a deep copy of the current FileTree: all nodes (and the structure between them) are duplicated, so
+// adding, removing, or replacing paths in the copy never affects the original (and vice versa). File references are
+// shared between both trees since they identify the underlying files (e.g. within an image file catalog), which makes
+// the copy suitable for building overlays or patches on top of an existing tree (such as an image squash tree).
 func (t *FileTree) Copy() (*FileTree, error) {
 	ct := NewFileTree()
 	ct.tree = t.tree.Copy()
+	ct.resolutionRoot = t.resolutionRoot
+	ct.interner = t.interner
 	return ct, nil
 }
 
+// SetPathInterner configures the tree to intern the paths of all nodes added from this point on with the given
+// interner. Sharing a single interner across many trees (e.g. all layer and squash trees of an image) allows equal
+// paths to share memory. Copies of the tree use the same interner.
+func (t *FileTree) SetPathInterner(interner *file.PathInterner) {
+	t.interner = interner
+}
+
+// WithResolutionRoot returns a view of the current FileTree where symlinks found under the given root path are
+// resolved as if the root path were "/" (chroot-style): absolute link destinations are rebased under the root and
+// relative link destinations cannot escape the root. Links outside of the root path are resolved as usual. Note that
+// the root only affects links that are followed, so basename links are only rebased when FollowBasenameLinks is
+// given (ancestor links are always followed). The returned view shares the same underlying tree, so changes to
+// either are reflected in both.
+func (t *FileTree) WithResolutionRoot(root file.Path) *FileTree {
+	root = root.Normalize()
+	if root == file.DirSeparator {
+		root = ""
+	}
+	return &FileTree{
+		tree:           t.tree,
+		resolutionRoot: root,
+		interner:       t.interner,
+	}
+}
+
 // AllFiles returns all files within the FileTree (defaults to regular files only, but you can provide one or more allow types).
 func (t *FileTree) AllFiles(types ...file.Type) []file.Reference {
 	if len(types) == 0 {
@@ -168,7 +207,7 @@ func (t *FileTree) node(p file.Path, strategy linkResolutionStrategy) (*filenode
 	var currentNode *filenode.FileNode
 	var err error
 	if strategy.FollowAncestorLinks {
-		currentNode, err = t.resolveAncestorLinks(normalizedPath)
+		currentNode, err = t.resolveAncestorLinks(normalizedPath, 0)
 		if err != nil {
 			return currentNode, err
 		}
@@ -185,14 +224,15 @@ func (t *FileTree) node(p file.Path, strategy linkResolutionStrategy) (*filenode
 	}
 
 	if strategy.FollowBasenameLinks {
-		currentNode, err = t.resolveNodeLinks(currentNode, !strategy.DoNotFollowDeadBasenameLinks)
+		currentNode, err = t.resolveNodeLinks(currentNode, !strategy.DoNotFollowDeadBasenameLinks, 0)
 	}
 	return currentNode, err
 }
 
 // return FileNode of the basename in the given path (no resolution is done at or past the basename). Note: it is
-// assumed that the given path has already been normalized.
-func (t *FileTree) resolveAncestorLinks(path file.Path) (*filenode.FileNode, error) {
+// assumed that the given path has already been normalized. The depth is the current level of recursive link
+// resolution.
+func (t *FileTree) resolveAncestorLinks(path file.Path, depth int) (*filenode.FileNode, error) {
 	// performance optimization... see if there is a node at the path (as if it is a real path). If so,
 	// use it, otherwise, continue with ancestor resolution
 	currentNode, err := t.node(path, linkResolutionStrategy{})
@@ -248,7 +288,7 @@ func (t *FileTree) resolveAncestorLinks(path file.Path) (*filenode.FileNode, err
 		// links until the next Node is resolved (or not).
 		isLastPart := idx == len(pathParts)-1
 		if !isLastPart && currentNode.IsLink() {
-			currentNode, err = t.resolveNodeLinks(currentNode, true)
+			currentNode, err = t.resolveNodeLinks(currentNode, true, depth+1)
 			if err != nil {
 				// only expected to happen on cycles
 				return currentNode, err
@@ -266,11 +306,15 @@ func (t *FileTree) resolveAncestorLinks(path file.Path) (*filenode.FileNode, err
 
 // followNode takes the given FileNode and resolves all links at the base of the real path for the node (this implies
 // that NO ancestors are considered).
-func (t *FileTree) resolveNodeLinks(n *filenode.FileNode, followDeadBasenameLinks bool) (*filenode.FileNode, error) {
+func (t *FileTree) resolveNodeLinks(n *filenode.FileNode, followDeadBasenameLinks bool, depth int) (*filenode.FileNode, error) {
 	if n == nil {
 		return nil, fmt.Errorf("cannot resolve links with nil Node given")
 	}
 
+	if depth > maxLinkResolutionDepth {
+		return nil, ErrLinkCycleDetected
+	}
+
 	// note: this assumes that callers are passing paths in which the constituent parts are NOT symlinks
 	var lastNode *filenode.FileNode
 
@@ -299,7 +343,13 @@ func (t *FileTree) resolveNodeLinks(n *filenode.FileNode, followDeadBasenameLink
 		alreadySeen.Add(string(currentNode.RealPath))
 
 		var nextPath file.Path
-		if currentNode.LinkPath.IsAbsolutePath() {
+		if currentNode.FileType == file.TypeSymlink {
+			nextPath, err = t.symlinkDestination(currentNode, depth)
+			if err != nil {
+				return nil, err
+			}
+			nextPath = t.rebaseLinkDestination(currentNode, nextPath)
+		} else if currentNode.LinkPath.IsAbsolutePath() {
 			// use links with absolute paths blindly
 			nextPath = currentNode.LinkPath
 		} else {
@@ -319,7 +369,7 @@ func (t *FileTree) resolveNodeLinks(n *filenode.FileNode, followDeadBasenameLink
 		lastNode = currentNode
 
 		// get the next Node (based on the next path)
-		currentNode, err = t.resolveAncestorLinks(nextPath)
+		currentNode, err = t.resolveAncestorLinks(nextPath, depth+1)
 		if err != nil {
 			// only expected to occur upon cycle detection
 			return currentNode, err
@@ -333,7 +383,90 @@ func (t *FileTree) resolveNodeLinks(n *filenode.FileNode, followDeadBasenameLink
 	return currentNode, nil
 }
 
-// File fetches zero to many file.References for the given glob pattern (considers symlinks).
+// symlinkDestination returns the real path that the given symlink Node points to, excluding resolution of the
+// basename of the destination. The link path is walked one element at a time (as the kernel does): symlinks found in
+// the directory elements are followed before any following ".." element is applied (so "dir/../file" is relative to
+// wherever "dir" resolves to), and ".." elements that would go above the root stay at the root.
+func (t *FileTree) symlinkDestination(n *filenode.FileNode, depth int) (file.Path, error) {
+	current := file.DirSeparator
+	if !n.LinkPath.IsAbsolutePath() {
+		parentDir, _ := filepath.Split(string(n.RealPath))
+		current = path.Clean(parentDir)
+	}
+
+	parts := strings.Split(string(n.LinkPath), file.DirSeparator)
+	for idx, part := range parts {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			current = path.Dir(current)
+			continue
+		}
+
+		current = path.Join(current, part)
+
+		// the basename of the destination is resolved by the caller
+		if idx == len(parts)-1 {
+			break
+		}
+
+		candidate, err := t.node(file.Path(current), linkResolutionStrategy{})
+		if err != nil {
+			return "", err
+		}
+		if candidate == nil || !candidate.IsLink() {
+			continue
+		}
+
+		resolved, err := t.resolveNodeLinks(candidate, true, depth+1)
+		if err != nil {
+			return "", err
+		}
+		if resolved != nil {
+			current = string(resolved.RealPath)
+		}
+	}
+	return file.Path(current), nil
+}
+
+// rebaseLinkDestination adjusts the given resolved link destination for the given symlink Node relative to the
+// configured resolution root (if any). Links that do not reside under the resolution root are not adjusted.
+func (t *FileTree) rebaseLinkDestination(n *filenode.FileNode, destination file.Path) file.Path {
+	if t.resolutionRoot == "" {
+		return destination
+	}
+
+	root := string(t.resolutionRoot)
+	realPath := string(n.RealPath)
+	if !strings.HasPrefix(realPath, root+file.DirSeparator) {
+		return destination
+	}
+
+	var rootedPath string
+	if n.LinkPath.IsAbsolutePath() {
+		rootedPath = path.Clean(string(n.LinkPath))
+	} else {
+		// cleaning a path relative to "/" ensures that ".." elements cannot escape the resolution root
+		parentDir, _ := filepath.Split(strings.TrimPrefix(realPath, root))
+		rootedPath = path.Clean(path.Join(file.DirSeparator, parentDir, string(n.LinkPath)))
+	}
+	return file.Path(path.Join(root, rootedPath))
+}
+
+// FilesByGlob fetches zero to many file.References for the given glob pattern (considers symlinks). Each result
+// captures both the path that matched the pattern and the real path the match resolved to within the tree. Patterns
+// are always relative to the root of the tree and support the following shell-like syntax:
+//   - "*" matches any sequence of characters within a single path segment.
+//   - "**" matches any number of path segments (including none), e.g. "/usr/**/*.so".
+//   - "?" matches any single character within a path segment.
+//   - "[abc]" matches any single character in the class, including ranges such as "[0-9]" and "[a-z]".
+//   - "[!abc]" (or "[^abc]") matches any single character not in the class.
+//   - "{a,b}" matches any of the comma separated alternatives, which may contain any of the above, span path
+//     segments, and be nested (e.g. "/etc/{passwd,group}" or "/usr/{lib,lib64}/lib{ssl,crypto}.so.*").
+//   - "\" escapes the following character so that it is matched literally (e.g. "\*" or "\{").
+//
+// A malformed pattern (such as an unclosed "[" or "{") results in an error.
 func (t *FileTree) FilesByGlob(query string, options ...LinkResolutionOption) ([]GlobResult, error) {
 	results := make([]GlobResult, 0)
 
@@ -481,6 +614,40 @@ func (t *FileTree) AddHardLink(realPath file.Path, linkPath file.Path) (*file.Re
 	return newFn.Reference, t.setFileNode(newFn)
 }
 
+// AddDevice adds a new path representing a character or block DEVICE to the Tree. It also adds any ancestors of the
+// path that are not already present in the Tree. The resulting file.Reference of the new (leaf) addition is returned.
+// Note: NO symlink or hardlink resolution is performed on the given path --which implies that the given path MUST
+// be a real path (have no links in constituent paths)
+func (t *FileTree) AddDevice(realPath file.Path, deviceType file.Type) (*file.Reference, error) {
+	if !deviceType.IsDevice() {
+		return nil, fmt.Errorf("type=%q is not a device type", string(deviceType))
+	}
+
+	fn, err := t.node(realPath, linkResolutionStrategy{})
+	if err != nil {
+		return nil, err
+	}
+	if fn != nil {
+		// this path already exists
+		if fn.FileType != deviceType {
+			return nil, fmt.Errorf("path=%q already exists but is NOT a device of type=%q", realPath, string(deviceType))
+		}
+		// this is a device file, provide a new or existing file.Reference
+		if fn.Reference == nil {
+			fn.Reference = file.NewFileReference(realPath)
+		}
+		return fn.Reference, nil
+	}
+
+	// this is a new path... add the new Node + parents
+	if err := t.addParentPaths(realPath); err != nil {
+		return nil, err
+	}
+
+	newFn := filenode.NewDevice(realPath, deviceType, file.NewFileReference(realPath))
+	return newFn.Reference, t.setFileNode(newFn)
+}
+
 // AddDir adds a new path representing a DIRECTORY to the Tree. It also adds any ancestors of the path that are
 // not already present in the Tree. The resulting file.Reference of the new (leaf) addition is returned.
 // Note: NO symlink or hardlink resolution is performed on the given path --which implies that the given path MUST
@@ -560,6 +727,16 @@ func (t *FileTree) setFileNode(fn *filenode.FileNode) error {
 		return fmt.Errorf("must provide a FileNode when adding paths")
 	}
 
+	if t.interner != nil {
+		fn.RealPath = t.interner.Intern(fn.RealPath)
+		if fn.LinkPath != "" {
+			fn.LinkPath = t.interner.Intern(fn.LinkPath)
+		}
+		if fn.Reference != nil {
+			fn.Reference.RealPath = t.interner.Intern(fn.Reference.RealPath)
+		}
+	}
+
 	if existingNode := t.tree.Node(filenode.IDByPath(fn.RealPath)); existingNode != nil {
 		return t.tree.Replace(existingNode, fn)
 	}
@@ -687,11 +864,61 @@ func (t *FileTree) Walk(fn func(path file.Path, f filenode.FileNode) error, cond
 	return NewDepthFirstPathWalker(t, fn, conditions).WalkAll()
 }
 
+// WalkBreadthFirst invokes the given visitor for all paths within the FileTree in breadth-first ordering (siblings are
+// visited in path order), along with the depth of each path ("/" is at depth 0, "/etc" is at depth 1, and so on). Links
+// are not followed. The walk stops when the visitor returns an error, which is then returned.
+func (t *FileTree) WalkBreadthFirst(fn func(f filenode.FileNode, depth int) error) error {
+	type queued struct {
+		node  *filenode.FileNode
+		depth int
+	}
+
+	var queue []queued
+	for _, root := range sortedFileNodes(t.tree.Roots()) {
+		queue = append(queue, queued{node: root})
+	}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		if err := fn(*current.node, current.depth); err != nil {
+			return err
+		}
+
+		for _, child := range sortedFileNodes(t.tree.Children(current.node)) {
+			queue = append(queue, queued{node: child, depth: current.depth + 1})
+		}
+	}
+	return nil
+}
+
+// sortedFileNodes returns the given nodes as file nodes sorted by path.
+func sortedFileNodes(nodes node.Nodes) []*filenode.FileNode {
+	fileNodes := make([]*filenode.FileNode, 0, len(nodes))
+	for _, n := range nodes {
+		if n == nil {
+			continue
+		}
+		fileNodes = append(fileNodes, n.(*filenode.FileNode))
+	}
+	sort.Slice(fileNodes, func(i, j int) bool {
+		return fileNodes[i].RealPath < fileNodes[j].RealPath
+	})
+	return fileNodes
+}
+
 // merge takes the given Tree and combines it with the current Tree, preferring files in the other Tree if there
 // are path conflicts. This is the basis function for squashing (where the current Tree is the bottom Tree and the
 // given Tree is the top Tree).
-// nolint:gocognit,funlen
 func (t *FileTree) merge(upper *FileTree) error {
+	return t.mergeTree(upper, nil)
+}
+
+// mergeTree combines the given Tree with the current Tree (see merge). When opaqueAffected is given, opaque directory
+// whiteouts are not applied; instead the lower paths they would have removed are appended to opaqueAffected.
+// nolint:gocognit,funlen
+func (t *FileTree) mergeTree(upper *FileTree, opaqueAffected *[]file.Path) error {
 	conditions := tree.WalkConditions{
 		ShouldContinueBranch: func(n node.Node) bool {
 			p := file.Path(n.ID())
@@ -699,7 +926,9 @@ func (t *FileTree) merge(upper *FileTree) error {
 		},
 		ShouldVisit: func(n node.Node) bool {
 			p := file.Path(n.ID())
-			return !p.IsDirWhiteout()
+			// opaque markers are handled when visiting the parent directory, and AUFS metadata entries
+			// (e.g. .wh..wh.plnk) do not white out anything in the lower tree
+			return !p.IsDirWhiteout() && !p.IsWhiteoutMetadata()
 		},
 	}
 
@@ -710,9 +939,8 @@ func (t *FileTree) merge(upper *FileTree) error {
 		upperNode := n.(*filenode.FileNode)
 		// opaque directories must be processed first
 		if upper.hasOpaqueDirectory(upperNode.RealPath) {
-			err := t.RemoveChildPaths(upperNode.RealPath)
-			if err != nil {
-				return fmt.Errorf("filetree merge failed to remove child paths (upperPath=%s): %w", upperNode.RealPath, err)
+			if err := t.applyOpaqueDirectory(upperNode.RealPath, upper, opaqueAffected); err != nil {
+				return err
 			}
 		}
 
@@ -773,6 +1001,49 @@ func (t *FileTree) merge(upper *FileTree) error {
 	return tree.NewDepthFirstWalkerWithConditions(upper.Reader(), visitor, conditions).WalkAll()
 }
 
+// applyOpaqueDirectory removes all children of the given directory (an opaque directory in the upper Tree) from the
+// current Tree. When opaqueAffected is given the children are not removed, but are appended to opaqueAffected instead.
+func (t *FileTree) applyOpaqueDirectory(directoryPath file.Path, upper *FileTree, opaqueAffected *[]file.Path) error {
+	if opaqueAffected == nil {
+		if err := t.RemoveChildPaths(directoryPath); err != nil {
+			return fmt.Errorf("filetree merge failed to remove child paths (upperPath=%s): %w", directoryPath, err)
+		}
+		return nil
+	}
+
+	affected, err := t.opaqueAffectedPaths(directoryPath, upper)
+	if err != nil {
+		return fmt.Errorf("filetree merge failed to find opaque whiteout paths (upperPath=%s): %w", directoryPath, err)
+	}
+	*opaqueAffected = append(*opaqueAffected, affected...)
+	return nil
+}
+
+// opaqueAffectedPaths returns all paths beneath the given directory (in the current Tree) that an opaque whiteout for
+// the directory would remove, excluding paths that are present in the given upper Tree.
+func (t *FileTree) opaqueAffectedPaths(directoryPath file.Path, upper *FileTree) ([]file.Path, error) {
+	fn, err := t.node(directoryPath, linkResolutionStrategy{
+		FollowAncestorLinks: true,
+		FollowBasenameLinks: true,
+	})
+	if err != nil || fn == nil {
+		return nil, err
+	}
+
+	var affected []file.Path
+	queue := t.tree.Children(fn)
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		p := file.Path(n.ID())
+		if !upper.tree.HasNode(n.ID()) {
+			affected = append(affected, p)
+		}
+		queue = append(queue, t.tree.Children(n)...)
+	}
+	return affected, nil
+}
+
 func (t *FileTree) hasOpaqueDirectory(directoryPath file.Path) bool {
 	opaqueWhiteoutChild := file.Path(path.Join(string(directoryPath), file.OpaqueWhiteout))
 	return t.HasPath(opaqueWhiteoutChild)
@@ -3,12 +3,14 @@ package filetree
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"testing"
 
 	"github.com/anchore/stereoscope/internal"
 	"github.com/anchore/stereoscope/pkg/file"
 	"github.com/anchore/stereoscope/pkg/filetree/filenode"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestFileTree_AddPath(t *testing.T) {
@@ -325,6 +327,119 @@ func TestFileTree_FilesByGlob(t *testing.T) {
 
 }
 
+func TestFileTree_FilesByGlob_MatchAndRealPaths(t *testing.T) {
+	tr := NewFileTree()
+	_, err := tr.AddFile("/usr/lib/libc.so")
+	require.NoError(t, err)
+	_, err = tr.AddSymLink("/lib", "/usr/lib")
+	require.NoError(t, err)
+
+	results, err := tr.FilesByGlob("/lib/*.so")
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+
+	assert.Equal(t, file.Path("/lib/libc.so"), results[0].MatchPath)
+	assert.Equal(t, file.Path("/usr/lib/libc.so"), results[0].RealPath)
+	assert.Equal(t, file.Path("/usr/lib/libc.so"), results[0].Reference.RealPath)
+	assert.False(t, results[0].IsDeadLink)
+}
+
+func TestFileTree_FilesByGlob_ShellSyntax(t *testing.T) {
+	tr := NewFileTree()
+	for _, p := range []file.Path{
+		"/etc/passwd",
+		"/etc/group",
+		"/etc/shadow",
+		"/etc/rc0.d/K01",
+		"/etc/rc1.d/K01",
+		"/etc/rcS.d/S01",
+		"/usr/lib/libssl.so.1",
+		"/usr/lib/libssl.so.3",
+		"/usr/lib/libcrypto.so.3",
+		"/usr/lib64/libz.so.1",
+		"/usr/share/doc/a{b}.txt",
+		"/usr/share/doc/[x].txt",
+		"/usr/share/doc/star*.txt",
+	} {
+		_, err := tr.AddFile(p)
+		require.NoError(t, err)
+	}
+
+	tests := []struct {
+		pattern  string
+		expected []file.Path
+		wantErr  bool
+	}{
+		{
+			pattern:  "/etc/{passwd,group}",
+			expected: []file.Path{"/etc/group", "/etc/passwd"},
+		},
+		{
+			pattern:  "/etc/rc[0-9].d/*",
+			expected: []file.Path{"/etc/rc0.d/K01", "/etc/rc1.d/K01"},
+		},
+		{
+			pattern:  "/etc/rc[!0-9].d/*",
+			expected: []file.Path{"/etc/rcS.d/S01"},
+		},
+		{
+			pattern:  "/etc/rc[^0-9].d/*",
+			expected: []file.Path{"/etc/rcS.d/S01"},
+		},
+		{
+			pattern:  "/etc/rc?.d/S*",
+			expected: []file.Path{"/etc/rcS.d/S01"},
+		},
+		{
+			pattern:  "/usr/{lib,lib64}/lib{ssl,z}.so.[13]",
+			expected: []file.Path{"/usr/lib/libssl.so.1", "/usr/lib/libssl.so.3", "/usr/lib64/libz.so.1"},
+		},
+		{
+			// alternatives may contain wildcards and span directories
+			pattern:  "/{etc/*sha*,usr/**/libcrypto*}",
+			expected: []file.Path{"/etc/shadow", "/usr/lib/libcrypto.so.3"},
+		},
+		{
+			// nested alternatives
+			pattern:  "/etc/{pass{wd,word},gr{oup,p}}",
+			expected: []file.Path{"/etc/group", "/etc/passwd"},
+		},
+		{
+			// escaped special characters match literally
+			pattern:  "/usr/share/doc/{a\\{b\\},\\[x\\],star\\*}.txt",
+			expected: []file.Path{"/usr/share/doc/[x].txt", "/usr/share/doc/a{b}.txt", "/usr/share/doc/star*.txt"},
+		},
+		{
+			pattern: "/etc/{passwd",
+			wantErr: true,
+		},
+		{
+			pattern: "/etc/rc[0-9.d/*",
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			results, err := tr.FilesByGlob(test.pattern)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var actual []file.Path
+			for _, r := range results {
+				actual = append(actual, r.MatchPath)
+			}
+			sort.Slice(actual, func(i, j int) bool {
+				return actual[i] < actual[j]
+			})
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
+
 func TestFileTree_Merge(t *testing.T) {
 	tr1 := NewFileTree()
 	tr1.AddFile("/home/wagoodman/awesome/file-1.txt")
@@ -361,6 +476,40 @@ func TestFileTree_Merge_Overwrite(t *testing.T) {
 
 }
 
+func TestFileTree_Copy(t *testing.T) {
+	original := NewFileTree()
+	hostsRef, err := original.AddFile("/etc/hosts")
+	require.NoError(t, err)
+	_, err = original.AddFile("/etc/passwd")
+	require.NoError(t, err)
+	_, err = original.AddSymLink("/bin/sh", "busybox")
+	require.NoError(t, err)
+
+	cp, err := original.Copy()
+	require.NoError(t, err)
+
+	// mutate the copy
+	_, err = cp.AddFile("/etc/patched")
+	require.NoError(t, err)
+	require.NoError(t, cp.RemovePath("/etc/passwd"))
+	require.NoError(t, cp.RemovePath("/bin/sh"))
+	_, err = cp.AddDir("/bin/sh")
+	require.NoError(t, err)
+
+	// the original is unaffected
+	assert.False(t, original.HasPath("/etc/patched"))
+	assert.True(t, original.HasPath("/etc/passwd"))
+	entries := original.AllFiles(file.TypeSymlink)
+	require.Len(t, entries, 1)
+	assert.Equal(t, file.Path("/bin/sh"), entries[0].RealPath)
+
+	// file references still identify the same files in both trees
+	_, copiedRef, err := cp.File("/etc/hosts")
+	require.NoError(t, err)
+	require.NotNil(t, copiedRef)
+	assert.Equal(t, hostsRef.ID(), copiedRef.ID())
+}
+
 func TestFileTree_Merge_OpaqueWhiteout(t *testing.T) {
 	tr1 := NewFileTree()
 	tr1.AddFile("/home/wagoodman/awesome/file.txt")
@@ -429,6 +578,37 @@ func TestFileTree_Merge_Whiteout(t *testing.T) {
 
 }
 
+func TestFileTree_Merge_AufsMetadata(t *testing.T) {
+	tr1 := NewFileTree()
+	tr1.AddFile("/.wh.plnk")
+	tr1.AddFile("/.wh.aufs")
+	tr1.AddFile("/home/wagoodman/awesome/file.txt")
+
+	tr2 := NewFileTree()
+	tr2.AddFile("/.wh..wh.aufs")
+	tr2.AddDir("/.wh..wh.plnk")
+	tr2.AddFile("/.wh..wh.plnk/123.456")
+	tr2.AddFile("/home/wagoodman/.wh..wh..opq")
+	tr2.AddFile("/home/wagoodman/new.txt")
+
+	if err := tr1.merge(tr2); err != nil {
+		t.Fatalf("error on merge : %+v", err)
+	}
+
+	// AUFS metadata entries do not whiteout anything in the lower tree, and are not carried into the squash
+	for _, p := range []file.Path{"/.wh.plnk", "/.wh.aufs", "/home/wagoodman", "/home/wagoodman/new.txt"} {
+		if !tr1.HasPath(p) {
+			t.Errorf("missing expected path: %s", p)
+		}
+	}
+
+	for _, p := range []file.Path{"/.wh..wh.aufs", "/.wh..wh.plnk", "/.wh..wh.plnk/123.456", "/home/wagoodman/.wh..wh..opq", "/home/wagoodman/awesome/file.txt"} {
+		if tr1.HasPath(p) {
+			t.Errorf("expected path to be absent: %s", p)
+		}
+	}
+}
+
 func TestFileTree_Merge_DirOverride(t *testing.T) {
 	tr1 := NewFileTree()
 	tr1.AddFile("/home/wagoodman/awesome/place")
@@ -761,6 +941,90 @@ func TestFileTree_File_MultipleIndirections(t *testing.T) {
 	}
 }
 
+func TestFileTree_File_RelativeLinkResolution(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    []file.Path
+		links    map[file.Path]file.Path
+		request  file.Path
+		expected file.Path
+	}{
+		{
+			name:     "deeply nested relative link",
+			files:    []file.Path{"/bin/sh"},
+			links:    map[file.Path]file.Path{"/usr/lib/x86_64/deep/sh": "../../../../bin/sh"},
+			request:  "/usr/lib/x86_64/deep/sh",
+			expected: "/bin/sh",
+		},
+		{
+			name:     "relative link escaping the root is clamped at the root",
+			files:    []file.Path{"/bin/sh"},
+			links:    map[file.Path]file.Path{"/usr/bin/sh": "../../../../../../bin/sh"},
+			request:  "/usr/bin/sh",
+			expected: "/bin/sh",
+		},
+		{
+			name:     "absolute link escaping the root is clamped at the root",
+			files:    []file.Path{"/bin/sh"},
+			links:    map[file.Path]file.Path{"/usr/bin/sh": "/../../usr/../bin/./sh"},
+			request:  "/usr/bin/sh",
+			expected: "/bin/sh",
+		},
+		{
+			name:  "parent element is applied after following a directory link",
+			files: []file.Path{"/opt/app/config", "/opt/app/current/README", "/etc/config"},
+			links: map[file.Path]file.Path{
+				"/etc/app":        "/opt/app/current",
+				"/etc/app-config": "app/../config",
+			},
+			request: "/etc/app-config",
+			// not /etc/config (which is what a purely lexical resolution would find)
+			expected: "/opt/app/config",
+		},
+		{
+			name:  "multiple relative directory links",
+			files: []file.Path{"/usr/lib/libc.so"},
+			links: map[file.Path]file.Path{
+				"/lib":         "usr/lib",
+				"/lib64":       "lib",
+				"/var/libc.so": "../lib64/libc.so",
+			},
+			request:  "/var/libc.so",
+			expected: "/usr/lib/libc.so",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tr := NewFileTree()
+			for _, f := range test.files {
+				_, err := tr.AddFile(f)
+				require.NoError(t, err)
+			}
+			for src, dest := range test.links {
+				_, err := tr.AddSymLink(src, dest)
+				require.NoError(t, err)
+			}
+
+			exists, ref, err := tr.File(test.request, FollowBasenameLinks)
+			require.NoError(t, err)
+			require.True(t, exists)
+			assert.Equal(t, test.expected, ref.RealPath)
+		})
+	}
+}
+
+func TestFileTree_File_CycleThroughAncestorLinks(t *testing.T) {
+	tr := NewFileTree()
+	_, err := tr.AddSymLink("/a", "/b/x")
+	require.NoError(t, err)
+	_, err = tr.AddSymLink("/b", "/a/y")
+	require.NoError(t, err)
+
+	_, _, err = tr.File("/a", FollowBasenameLinks)
+	assert.ErrorIs(t, err, ErrLinkCycleDetected)
+}
+
 func TestFileTree_File_CycleDetection(t *testing.T) {
 	tr := NewFileTree()
 	// first indirection
@@ -787,6 +1051,91 @@ func TestFileTree_File_CycleDetection(t *testing.T) {
 
 }
 
+func TestFileTree_WithResolutionRoot(t *testing.T) {
+	tr := NewFileTree()
+
+	for _, p := range []file.Path{"/rootfs/etc/alternatives/editor", "/rootfs/etc/passwd", "/etc/passwd"} {
+		if _, err := tr.AddFile(p); err != nil {
+			t.Fatalf("could not add path=%q: %+v", p, err)
+		}
+	}
+
+	links := map[file.Path]file.Path{
+		"/rootfs/usr/bin/editor":  "/etc/alternatives/editor",
+		"/rootfs/usr/bin/escaped": "../../../../etc/passwd",
+		"/rootfs/usr/lib":         "/etc",
+		"/outside":                "/etc/passwd",
+	}
+	for p, link := range links {
+		if _, err := tr.AddSymLink(p, link); err != nil {
+			t.Fatalf("could not add link=%q: %+v", p, err)
+		}
+	}
+
+	tests := []struct {
+		name       string
+		root       file.Path
+		path       file.Path
+		expected   file.Path
+		shouldFind bool
+	}{
+		{
+			name:       "absolute link is dead without a resolution root",
+			path:       "/rootfs/usr/bin/editor",
+			shouldFind: false,
+		},
+		{
+			name:       "absolute link is rebased under the resolution root",
+			root:       "/rootfs",
+			path:       "/rootfs/usr/bin/editor",
+			expected:   "/rootfs/etc/alternatives/editor",
+			shouldFind: true,
+		},
+		{
+			name:       "relative link cannot escape the resolution root",
+			root:       "/rootfs/",
+			path:       "/rootfs/usr/bin/escaped",
+			expected:   "/rootfs/etc/passwd",
+			shouldFind: true,
+		},
+		{
+			name:       "relative link escapes without a resolution root",
+			path:       "/rootfs/usr/bin/escaped",
+			expected:   "/etc/passwd",
+			shouldFind: true,
+		},
+		{
+			name:       "ancestor links are rebased under the resolution root",
+			root:       "/rootfs",
+			path:       "/rootfs/usr/lib/passwd",
+			expected:   "/rootfs/etc/passwd",
+			shouldFind: true,
+		},
+		{
+			name:       "links outside of the resolution root are unaffected",
+			root:       "/rootfs",
+			path:       "/outside",
+			expected:   "/etc/passwd",
+			shouldFind: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			view := tr
+			if test.root != "" {
+				view = tr.WithResolutionRoot(test.root)
+			}
+			exists, ref, err := view.File(test.path, FollowBasenameLinks)
+			assert.NoError(t, err)
+			assert.Equal(t, test.shouldFind, exists && ref != nil)
+			if test.shouldFind && ref != nil {
+				assert.Equal(t, test.expected, ref.RealPath)
+			}
+		})
+	}
+}
+
 func TestFileTree_AllFiles(t *testing.T) {
 	tr := NewFileTree()
 
@@ -823,6 +1172,12 @@ func TestFileTree_AllFiles(t *testing.T) {
 		t.Fatalf("could not setup link: %+v", err)
 	}
 
+	// character device
+	_, err = tr.AddDevice("/dev/null", file.TypeCharacterDevice)
+	if err != nil {
+		t.Fatalf("could not setup device: %+v", err)
+	}
+
 	tests := []struct {
 		name     string
 		types    []file.Type
@@ -853,6 +1208,11 @@ func TestFileTree_AllFiles(t *testing.T) {
 			types:    []file.Type{file.TypeReg, file.TypeSymlink},
 			expected: []string{"/home/a-file.txt", "/sym-linked-dest/a-.gif", "/hard-linked-dest/b-.gif", "/home/symlink"},
 		},
+		{
+			name:     "char-device",
+			types:    []file.Type{file.TypeCharacterDevice},
+			expected: []string{"/dev/null"},
+		},
 		{
 			name:  "dir",
 			types: []file.Type{file.TypeDir},
@@ -19,11 +19,18 @@ var _ fs.FS = (*osAdapter)(nil)
 var _ fs.FileInfo = (*fileinfoAdapter)(nil)
 var _ fs.DirEntry = (*fileinfoAdapter)(nil)
 
+// GlobResult is a single match from FileTree.FilesByGlob.
 type GlobResult struct {
-	MatchPath  file.Path
-	RealPath   file.Path
+	// MatchPath is the (virtual) path that matched the glob pattern, which may traverse symlinks (e.g. /lib/libc.so
+	// when /lib is a link to /usr/lib)
+	MatchPath file.Path
+	// RealPath is the path where the matched file is stored in the tree after all links have been resolved
+	// (e.g. /usr/lib/libc.so)
+	RealPath file.Path
+	// IsDeadLink indicates that the match is a link that could not be resolved
 	IsDeadLink bool
-	Reference  file.Reference
+	// Reference is the file reference stored at RealPath
+	Reference file.Reference
 }
 
 // fileAdapter is an object meant to implement the doublestar.File for getting Lstat results for an entire directory.
@@ -0,0 +1,108 @@
+package filetree
+
+import (
+	"errors"
+	"path"
+
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/anchore/stereoscope/pkg/filetree/filenode"
+	"github.com/anchore/stereoscope/pkg/tree/node"
+)
+
+// MaterializeSymlinks returns a copy of the tree where every symlink that resolves to a directory is replaced by a
+// directory containing a copy of the target directory contents, so that consumers can find all paths without following
+// links themselves. Within a materialized directory:
+//   - nodes share the file.Reference of the path they were copied from (so the same reference may appear at several
+//     paths, and the reference RealPath is the path of the original file).
+//   - symlinks to directories are materialized as well, unless the target directory is already being materialized
+//     (a cycle, e.g. "/usr/lib/self -> /usr/lib"), in which case the symlink is kept as-is.
+//   - all other symlinks are copied as-is (relative link destinations are not rewritten for their new location).
+//
+// Symlinks that are dead or do not resolve to a directory are kept as-is. Note that the resulting tree may be much
+// larger than the original (every symlinked directory duplicates the nodes of its target), though no file contents
+// are duplicated.
+func (t *FileTree) MaterializeSymlinks() (*FileTree, error) {
+	materialized, err := t.Copy()
+	if err != nil {
+		return nil, err
+	}
+
+	var links node.Nodes
+	for _, n := range t.tree.Nodes() {
+		if n.(*filenode.FileNode).FileType == file.TypeSymlink {
+			links = append(links, n)
+		}
+	}
+
+	for _, link := range sortedFileNodes(links) {
+		if err := materialized.materializeLink(t, link, link.RealPath, nil); err != nil {
+			return nil, err
+		}
+	}
+	return materialized, nil
+}
+
+// materializeLink replaces the node at the given path (a copy of the given symlink from the source tree) with a copy of
+// the directory the symlink resolves to in the source tree. The active paths are the target directories currently being
+// materialized, which are used to detect cycles.
+func (t *FileTree) materializeLink(src *FileTree, link *filenode.FileNode, at file.Path, active []file.Path) error {
+	target, err := src.node(link.RealPath, linkResolutionStrategy{
+		FollowAncestorLinks:          true,
+		FollowBasenameLinks:          true,
+		DoNotFollowDeadBasenameLinks: true,
+	})
+	if errors.Is(err, ErrLinkCycleDetected) {
+		// the symlink cannot be resolved, keep it as-is
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if target == nil || target.FileType != file.TypeDir {
+		return nil
+	}
+
+	for _, p := range active {
+		if p == target.RealPath {
+			// this directory is already being materialized, keep the symlink as-is to break the cycle
+			return nil
+		}
+	}
+	if len(active) >= maxLinkResolutionDepth {
+		return nil
+	}
+
+	if err := t.setFileNode(filenode.NewDir(at, target.Reference)); err != nil {
+		return err
+	}
+	return t.copyChildren(src, target, at, append(active, target.RealPath))
+}
+
+// copyChildren copies all descendants of the given source directory to the given destination directory, materializing
+// any symlinks to directories along the way.
+func (t *FileTree) copyChildren(src *FileTree, dir *filenode.FileNode, at file.Path, active []file.Path) error {
+	for _, child := range sortedFileNodes(src.tree.Children(dir)) {
+		childPath := file.Path(path.Join(string(at), child.RealPath.Basename()))
+
+		if err := t.setFileNode(&filenode.FileNode{
+			RealPath:  childPath,
+			FileType:  child.FileType,
+			LinkPath:  child.LinkPath,
+			Reference: child.Reference,
+		}); err != nil {
+			return err
+		}
+
+		switch child.FileType {
+		case file.TypeSymlink:
+			if err := t.materializeLink(src, child, childPath, active); err != nil {
+				return err
+			}
+		case file.TypeDir:
+			if err := t.copyChildren(src, child, childPath, active); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
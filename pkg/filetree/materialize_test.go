@@ -0,0 +1,61 @@
+package filetree
+
+import (
+	"testing"
+
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileTree_MaterializeSymlinks(t *testing.T) {
+	original := NewFileTree()
+	libcRef, err := original.AddFile("/usr/lib/libc.so")
+	require.NoError(t, err)
+	_, err = original.AddFile("/usr/lib/python/site.py")
+	require.NoError(t, err)
+	_, err = original.AddSymLink("/lib", "usr/lib")
+	require.NoError(t, err)
+	// a cycle: materializing /usr/lib would otherwise include itself forever
+	_, err = original.AddSymLink("/usr/lib/self", "/usr/lib")
+	require.NoError(t, err)
+	// links that do not resolve to a directory are kept as-is
+	_, err = original.AddSymLink("/usr/lib/libc.so.6", "libc.so")
+	require.NoError(t, err)
+	_, err = original.AddSymLink("/dead", "/does/not/exist")
+	require.NoError(t, err)
+
+	materialized, err := original.MaterializeSymlinks()
+	require.NoError(t, err)
+
+	// the original tree is unaffected
+	n, err := original.node("/lib/libc.so", linkResolutionStrategy{})
+	require.NoError(t, err)
+	assert.Nil(t, n)
+	assert.Len(t, original.AllFiles(file.TypeSymlink), 4)
+
+	// symlinked directories are now real directories with copies of the target contents
+	for _, p := range []file.Path{"/lib", "/lib/python", "/usr/lib/self"} {
+		n, err := materialized.node(p, linkResolutionStrategy{})
+		require.NoError(t, err)
+		require.NotNil(t, n, "path=%s", p)
+		assert.Equal(t, file.TypeDir, n.FileType, "path=%s", p)
+	}
+
+	// materialized paths share the reference of the original file
+	n, err = materialized.node("/lib/libc.so", linkResolutionStrategy{})
+	require.NoError(t, err)
+	require.NotNil(t, n)
+	assert.Equal(t, libcRef.ID(), n.Reference.ID())
+
+	// the cycle is broken by keeping the nested link to the directory being materialized
+	for _, p := range []file.Path{"/usr/lib/self/self", "/lib/self", "/dead", "/usr/lib/libc.so.6", "/lib/libc.so.6"} {
+		n, err := materialized.node(p, linkResolutionStrategy{})
+		require.NoError(t, err)
+		require.NotNil(t, n, "path=%s", p)
+		assert.Equal(t, file.TypeSymlink, n.FileType, "path=%s", p)
+	}
+	n, err = materialized.node("/usr/lib/self/self/libc.so", linkResolutionStrategy{})
+	require.NoError(t, err)
+	assert.Nil(t, n)
+}
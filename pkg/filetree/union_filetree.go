@@ -1,6 +1,10 @@
 package filetree
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/anchore/stereoscope/pkg/file"
+)
 
 type UnionFileTree struct {
 	trees []*FileTree
@@ -17,6 +21,22 @@ func (u *UnionFileTree) PushTree(t *FileTree) {
 }
 
 func (u *UnionFileTree) Squash() (*FileTree, error) {
+	return u.squash(nil)
+}
+
+// SquashWithWhiteoutDiagnostics squashes all trees like Squash, except that opaque directory whiteouts are not applied.
+// Instead, the paths that the opaque whiteouts would have removed from lower trees are returned alongside the squashed
+// tree (which still contains them). This is only intended for diagnosing why files are missing from a squash.
+func (u *UnionFileTree) SquashWithWhiteoutDiagnostics() (*FileTree, []file.Path, error) {
+	var affected []file.Path
+	squashedTree, err := u.squash(&affected)
+	if err != nil {
+		return nil, nil, err
+	}
+	return squashedTree, affected, nil
+}
+
+func (u *UnionFileTree) squash(opaqueAffected *[]file.Path) (*FileTree, error) {
 	switch len(u.trees) {
 	case 0:
 		return NewFileTree(), nil
@@ -35,7 +55,7 @@ func (u *UnionFileTree) Squash() (*FileTree, error) {
 			continue
 		}
 
-		if err = squashedTree.merge(refTree); err != nil {
+		if err = squashedTree.mergeTree(refTree, opaqueAffected); err != nil {
 			return nil, fmt.Errorf("unable to squash layer=%d : %w", layerIdx, err)
 		}
 	}
@@ -142,3 +142,50 @@ func TestUnionFileTree_Squash_whiteout(t *testing.T) {
 	}
 
 }
+
+func TestUnionFileTree_SquashWithWhiteoutDiagnostics(t *testing.T) {
+	ut := NewUnionFileTree()
+	base := NewFileTree()
+
+	base.AddFile("/some/stuff-1.txt")
+	base.AddFile("/some/dir/stuff-2.txt")
+	base.AddFile("/some/kept.txt")
+	base.AddFile("/other/things-1.txt")
+
+	top := NewFileTree()
+	top.AddFile("/some/" + file.OpaqueWhiteout)
+	top.AddFile("/some/kept.txt")
+	top.AddFile("/other/" + file.WhiteoutPrefix + "things-1.txt")
+
+	ut.PushTree(base)
+	ut.PushTree(top)
+
+	squashed, affected, err := ut.SquashWithWhiteoutDiagnostics()
+	if err != nil {
+		t.Fatal("cloud not squash trees", err)
+	}
+
+	// paths under the opaque directory are kept (but reported) while regular whiteouts still apply
+	for _, p := range []file.Path{"/some/stuff-1.txt", "/some/dir/stuff-2.txt", "/some/kept.txt"} {
+		if !squashed.HasPath(p) {
+			t.Errorf("expected '%v' but not found", p)
+		}
+	}
+	if squashed.HasPath("/other/things-1.txt") {
+		t.Errorf("expected path to be deleted: /other/things-1.txt")
+	}
+
+	expectedAffected := map[file.Path]bool{
+		"/some/stuff-1.txt":     true,
+		"/some/dir":             true,
+		"/some/dir/stuff-2.txt": true,
+	}
+	if len(affected) != len(expectedAffected) {
+		t.Fatalf("unexpected affected paths: %+v", affected)
+	}
+	for _, p := range affected {
+		if !expectedAffected[p] {
+			t.Errorf("unexpected affected path: %s", p)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package image
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/anchore/stereoscope/pkg/file"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// FilesByBuildStep returns the files added by each build step (e.g. a Dockerfile RUN or COPY instruction) that
+// produced a layer, keyed by the "created_by" command of the step from the image history. Files within each step are
+// sorted by path and whiteout entries are not included.
+//
+// Since the same command may produce several layers (e.g. repeated "COPY . /app" instructions), commands that are
+// shared by more than one layer are disambiguated with the layer index (e.g. "COPY . /app (layer 3)"). Layers without
+// a matching history entry or without a recorded command (e.g. images built without history) are keyed by the layer
+// index alone (e.g. "layer 3").
+func (i *Image) FilesByBuildStep() (map[string][]file.Reference, error) {
+	commands := layerCommands(i.Metadata.Config.History, len(i.Layers))
+
+	occurrences := make(map[string]int)
+	for _, command := range commands {
+		occurrences[command]++
+	}
+
+	steps := make(map[string][]file.Reference)
+	for idx, layer := range i.Layers {
+		key := commands[idx]
+		switch {
+		case key == "":
+			key = fmt.Sprintf("layer %d", idx)
+		case occurrences[key] > 1:
+			key = fmt.Sprintf("%s (layer %d)", key, idx)
+		}
+
+		refs := make([]file.Reference, 0)
+		if layer.Tree != nil {
+			for _, ref := range layer.Tree.AllFiles(file.AllTypes...) {
+				if ref.RealPath.IsWhiteout() {
+					continue
+				}
+				refs = append(refs, ref)
+			}
+		}
+		sort.Slice(refs, func(a, b int) bool {
+			return refs[a].RealPath < refs[b].RealPath
+		})
+		steps[key] = refs
+	}
+	return steps, nil
+}
+
+// layerCommands returns the "created_by" command of the history entry for each layer index. If the number of
+// non-empty history entries does not match the number of layers, all commands are left empty since the history cannot
+// be reliably aligned with the layers.
+func layerCommands(history []v1.History, layers int) []string {
+	commands := make([]string, layers)
+
+	var nonEmpty []v1.History
+	for _, h := range history {
+		if !h.EmptyLayer {
+			nonEmpty = append(nonEmpty, h)
+		}
+	}
+	if len(nonEmpty) != layers {
+		return commands
+	}
+
+	for idx, h := range nonEmpty {
+		commands[idx] = h.CreatedBy
+	}
+	return commands
+}
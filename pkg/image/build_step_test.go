@@ -0,0 +1,103 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/anchore/stereoscope/pkg/file"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImage_FilesByBuildStep(t *testing.T) {
+	layers := []testLayer{
+		{digest: "sha256:a", paths: []string{"/etc/os-release", "/bin/sh"}},
+		{digest: "sha256:b", paths: []string{"/app/main.go"}},
+		{digest: "sha256:c", paths: []string{"/app/go.mod", "/tmp/" + file.WhiteoutPrefix + "cache"}},
+	}
+
+	tests := []struct {
+		name     string
+		history  []v1.History
+		expected map[string][]file.Path
+	}{
+		{
+			name: "aligned history",
+			history: []v1.History{
+				{CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+				{CreatedBy: "/bin/sh -c #(nop)  ENV APP=app", EmptyLayer: true},
+				{CreatedBy: "COPY . /app"},
+				{CreatedBy: "RUN go mod init"},
+			},
+			expected: map[string][]file.Path{
+				"/bin/sh -c #(nop) ADD file:abc in / ": {"/bin/sh", "/etc/os-release"},
+				"COPY . /app":                          {"/app/main.go"},
+				"RUN go mod init":                      {"/app/go.mod"},
+			},
+		},
+		{
+			name: "repeated commands",
+			history: []v1.History{
+				{CreatedBy: "ADD rootfs.tar /"},
+				{CreatedBy: "COPY . /app"},
+				{CreatedBy: "COPY . /app"},
+			},
+			expected: map[string][]file.Path{
+				"ADD rootfs.tar /":      {"/bin/sh", "/etc/os-release"},
+				"COPY . /app (layer 1)": {"/app/main.go"},
+				"COPY . /app (layer 2)": {"/app/go.mod"},
+			},
+		},
+		{
+			name: "missing commands",
+			history: []v1.History{
+				{CreatedBy: "ADD rootfs.tar /"},
+				{},
+				{Comment: "buildkit.dockerfile.v0"},
+			},
+			expected: map[string][]file.Path{
+				"ADD rootfs.tar /": {"/bin/sh", "/etc/os-release"},
+				"layer 1":          {"/app/main.go"},
+				"layer 2":          {"/app/go.mod"},
+			},
+		},
+		{
+			name: "no history",
+			expected: map[string][]file.Path{
+				"layer 0": {"/bin/sh", "/etc/os-release"},
+				"layer 1": {"/app/main.go"},
+				"layer 2": {"/app/go.mod"},
+			},
+		},
+		{
+			name: "history does not align with layers",
+			history: []v1.History{
+				{CreatedBy: "ADD rootfs.tar /"},
+				{CreatedBy: "COPY . /app"},
+			},
+			expected: map[string][]file.Path{
+				"layer 0": {"/bin/sh", "/etc/os-release"},
+				"layer 1": {"/app/main.go"},
+				"layer 2": {"/app/go.mod"},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img := newTestImage(t, layers...)
+			img.Metadata.Config.History = test.history
+
+			steps, err := img.FilesByBuildStep()
+			require.NoError(t, err)
+
+			actual := make(map[string][]file.Path)
+			for step, refs := range steps {
+				for _, ref := range refs {
+					actual[step] = append(actual[step], ref.RealPath)
+				}
+			}
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package image
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/anchore/stereoscope/pkg/file"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// ErrChunkedLayerDigestMismatch is returned when the reassembled chunks of a layer blob do not match the layer digest.
+var ErrChunkedLayerDigestMismatch = fmt.Errorf("reassembled layer chunks do not match the layer digest")
+
+// ChunkOpener opens a single chunk of a layer blob.
+type ChunkOpener func() (io.ReadCloser, error)
+
+// ChunkLocator returns openers for the chunks of the (compressed) layer blob with the given digest, in order, such that
+// the concatenation of all chunks is the complete blob. Returning no chunks indicates that the blob is not chunked, in
+// which case the layer is read from the image source as usual.
+type ChunkLocator func(digest v1.Hash) ([]ChunkOpener, error)
+
+// WithChunkedLayers reads layer blobs from the chunks given by the locator instead of the image source, for storage
+// systems that split layer blobs into several pieces. Chunks are opened one at a time as the blob is read (never held
+// in memory together) and the reassembled blob is verified against the layer digest. Gzip compressed and uncompressed
+// blobs are supported.
+func WithChunkedLayers(locator ChunkLocator) AdditionalMetadata {
+	return func(image *Image) error {
+		image.chunkLocator = locator
+		return nil
+	}
+}
+
+// chunkedLayer is a v1.Layer with the blob contents reassembled from chunks (all other layer information is provided
+// by the original layer).
+type chunkedLayer struct {
+	v1.Layer
+	digest v1.Hash
+	chunks []ChunkOpener
+}
+
+// newChunkedLayer returns a layer that reads the blob contents from the chunks given by the locator, or the given
+// layer as-is if the blob is not chunked.
+func newChunkedLayer(layer v1.Layer, locator ChunkLocator) (v1.Layer, error) {
+	digest, err := layer.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get layer digest: %w", err)
+	}
+
+	chunks, err := locator(digest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to locate chunks for layer=%q: %w", digest, err)
+	}
+	if len(chunks) == 0 {
+		return layer, nil
+	}
+
+	return &chunkedLayer{
+		Layer:  layer,
+		digest: digest,
+		chunks: chunks,
+	}, nil
+}
+
+// Compressed returns a reader for the reassembled layer blob.
+func (c *chunkedLayer) Compressed() (io.ReadCloser, error) {
+	return newChunkReader(c.digest, c.chunks), nil
+}
+
+// Uncompressed returns a reader for the layer tar within the reassembled layer blob.
+func (c *chunkedLayer) Uncompressed() (io.ReadCloser, error) {
+	reader, _, err := file.NewDecompressingReader(newChunkReader(c.digest, c.chunks))
+	if err != nil {
+		return nil, fmt.Errorf("unable to decompress layer=%q: %w", c.digest, err)
+	}
+	return reader, nil
+}
+
+// chunkReader is an io.ReadCloser that reads each chunk in turn (opening each chunk only once the previous chunk has
+// been read), verifying the digest of all content once the last chunk has been read.
+type chunkReader struct {
+	expected v1.Hash
+	chunks   []ChunkOpener
+	current  io.ReadCloser
+	hasher   hash.Hash
+}
+
+func newChunkReader(expected v1.Hash, chunks []ChunkOpener) *chunkReader {
+	return &chunkReader{
+		expected: expected,
+		chunks:   chunks,
+		hasher:   sha256.New(),
+	}
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.chunks) == 0 {
+				return 0, r.verify()
+			}
+			current, err := r.chunks[0]()
+			if err != nil {
+				return 0, fmt.Errorf("unable to open chunk of layer=%q: %w", r.expected, err)
+			}
+			r.current = current
+			r.chunks = r.chunks[1:]
+		}
+
+		n, err := r.current.Read(p)
+		r.hasher.Write(p[:n])
+		if err == io.EOF {
+			err = r.current.Close()
+			r.current = nil
+			if err != nil {
+				return n, err
+			}
+			if n == 0 {
+				continue
+			}
+		}
+		return n, err
+	}
+}
+
+// verify returns io.EOF if the content read matches the expected digest (or the digest algorithm is not supported).
+func (r *chunkReader) verify() error {
+	if r.expected.Algorithm != "sha256" {
+		return io.EOF
+	}
+	if actual := hex.EncodeToString(r.hasher.Sum(nil)); actual != r.expected.Hex {
+		return fmt.Errorf("%w: layer=%q actual=%q", ErrChunkedLayerDigestMismatch, r.expected, "sha256:"+actual)
+	}
+	return io.EOF
+}
+
+func (r *chunkReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+	return err
+}
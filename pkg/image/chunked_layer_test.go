@@ -0,0 +1,117 @@
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/anchore/stereoscope/pkg/file"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// unavailableLayer is a layer whose blob cannot be fetched from the image source.
+type unavailableLayer struct {
+	v1.Layer
+}
+
+func (unavailableLayer) Compressed() (io.ReadCloser, error) {
+	return nil, fmt.Errorf("blob not available")
+}
+
+func (unavailableLayer) Uncompressed() (io.ReadCloser, error) {
+	return nil, fmt.Errorf("blob not available")
+}
+
+func TestImage_WithChunkedLayers(t *testing.T) {
+	layer := newTarLayer(t, map[string]string{
+		"etc/hosts":    "localhost",
+		"etc/hostname": "stereoscope",
+	})
+	digest, err := layer.Digest()
+	require.NoError(t, err)
+
+	reader, err := layer.Compressed()
+	require.NoError(t, err)
+	blob, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	require.NoError(t, reader.Close())
+
+	chunksOf := func(blob []byte, size int) []ChunkOpener {
+		var chunks []ChunkOpener
+		for start := 0; start < len(blob); start += size {
+			end := start + size
+			if end > len(blob) {
+				end = len(blob)
+			}
+			chunk := blob[start:end]
+			chunks = append(chunks, func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(chunk)), nil
+			})
+		}
+		return chunks
+	}
+
+	tests := []struct {
+		name        string
+		chunks      []ChunkOpener
+		expectedErr error
+	}{
+		{
+			name:   "single chunk",
+			chunks: chunksOf(blob, len(blob)),
+		},
+		{
+			name:   "many chunks",
+			chunks: chunksOf(blob, 7),
+		},
+		{
+			name:        "chunks do not match the digest",
+			chunks:      chunksOf(append(append([]byte{}, blob...), 0), 7),
+			expectedErr: ErrChunkedLayerDigestMismatch,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v1Image, err := mutate.AppendLayers(empty.Image, unavailableLayer{Layer: layer})
+			require.NoError(t, err)
+
+			var located []v1.Hash
+			img := NewImage(v1Image, t.TempDir(), WithChunkedLayers(func(d v1.Hash) ([]ChunkOpener, error) {
+				located = append(located, d)
+				return test.chunks, nil
+			}))
+
+			err = img.Read()
+			assert.Equal(t, []v1.Hash{digest}, located)
+			if test.expectedErr != nil {
+				require.ErrorIs(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+
+			contents, err := img.FileContentsFromSquash(file.Path("/etc/hostname"))
+			require.NoError(t, err)
+			actual, err := ioutil.ReadAll(contents)
+			require.NoError(t, err)
+			assert.Equal(t, "stereoscope", string(actual))
+		})
+	}
+}
+
+func TestImage_WithChunkedLayers_NotChunked(t *testing.T) {
+	v1Image, err := mutate.AppendLayers(empty.Image, newTarLayer(t, map[string]string{"etc/hosts": "localhost"}))
+	require.NoError(t, err)
+
+	img := NewImage(v1Image, t.TempDir(), WithChunkedLayers(func(v1.Hash) ([]ChunkOpener, error) {
+		return nil, nil
+	}))
+	require.NoError(t, img.Read())
+	assert.Contains(t, img.SquashedTree().AllRealPaths(), file.Path("/etc/hosts"))
+}
@@ -1,6 +1,7 @@
 package image
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"io"
 
@@ -8,16 +9,16 @@ import (
 	"github.com/anchore/stereoscope/pkg/filetree"
 )
 
+// ErrPathNotFound is returned when a path cannot be found within a file tree.
+var ErrPathNotFound = fmt.Errorf("could not find file path in Tree")
+
 // fetchFileContentsByPath is a common helper function for resolving the file contents for a path from the file
-// catalog relative to the given tree.
+// catalog relative to the given tree. The path is normalized before lookup (see file.Path.NormalizeForLookup).
 func fetchFileContentsByPath(ft *filetree.FileTree, fileCatalog *FileCatalog, path file.Path) (io.ReadCloser, error) {
-	exists, fileReference, err := ft.File(path, filetree.FollowBasenameLinks)
+	fileReference, err := fetchFileReferenceByPath(ft, path)
 	if err != nil {
 		return nil, err
 	}
-	if !exists && fileReference == nil {
-		return nil, fmt.Errorf("could not find file path in Tree: %s", path)
-	}
 
 	reader, err := fileCatalog.FileContents(*fileReference)
 	if err != nil {
@@ -26,7 +27,25 @@ func fetchFileContentsByPath(ft *filetree.FileTree, fileCatalog *FileCatalog, pa
 	return reader, nil
 }
 
-// fetchFileContentsByPath is a common helper function for resolving file references for a MIME type from the file
+// fetchFileReferenceByPath is a common helper function for resolving the file reference for a path relative to the
+// given tree. The path is normalized before lookup, however, the returned reference retains the real path within the
+// tree.
+func fetchFileReferenceByPath(ft *filetree.FileTree, path file.Path) (*file.Reference, error) {
+	normalizedPath := path.NormalizeForLookup()
+	exists, fileReference, err := ft.File(normalizedPath, filetree.FollowBasenameLinks)
+	if err != nil {
+		return nil, err
+	}
+	if !exists || fileReference == nil {
+		if normalizedPath != path {
+			return nil, fmt.Errorf("%w: %s (normalized from %q)", ErrPathNotFound, normalizedPath, path)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrPathNotFound, path)
+	}
+	return fileReference, nil
+}
+
+// fetchFilesByMIMEType is a common helper function for resolving file references for a MIME type from the file
 // catalog relative to the given tree.
 func fetchFilesByMIMEType(ft *filetree.FileTree, fileCatalog *FileCatalog, mType string) ([]file.Reference, error) {
 	fileEntries, err := fileCatalog.GetByMIMEType(mType)
@@ -48,3 +67,26 @@ func fetchFilesByMIMEType(ft *filetree.FileTree, fileCatalog *FileCatalog, mType
 	}
 	return refs, nil
 }
+
+// fetchFileDigest is a common helper function for computing the sha256 digest of the file contents for the given
+// file reference from the file catalog. Digests that were precomputed while reading the image are used as-is, and
+// computed digests are recorded in the catalog for later use.
+func fetchFileDigest(fileCatalog *FileCatalog, ref file.Reference) (string, error) {
+	if entry, err := fileCatalog.Get(ref); err == nil && entry.Metadata.Digest != "" {
+		return entry.Metadata.Digest, nil
+	}
+
+	reader, err := fileCatalog.FileContents(ref)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, reader); err != nil {
+		return "", fmt.Errorf("unable to digest contents for path=%q: %w", ref.RealPath, err)
+	}
+	digest := fmt.Sprintf("sha256:%x", hasher.Sum(nil))
+	fileCatalog.setDigest(ref, digest)
+	return digest, nil
+}
@@ -0,0 +1,208 @@
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anchore/stereoscope/internal/log"
+	"github.com/anchore/stereoscope/pkg/file"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// legacyRepositoriesFile maps repositories and tags to the ID of the top layer of each image within a legacy docker
+// archive (the v1 "docker save" format written before Docker 1.10, which has no manifest.json).
+const legacyRepositoriesFile = "repositories"
+
+// legacyRepositories is the contents of the repositories file: repository -> tag -> top layer ID.
+type legacyRepositories map[string]map[string]string
+
+// legacyLayerConfig is the "<layer ID>/json" file within a legacy docker archive. Each layer records its parent layer
+// and the image config as of that layer (so the config of the top layer is the config of the image).
+type legacyLayerConfig struct {
+	ID              string    `json:"id"`
+	Parent          string    `json:"parent,omitempty"`
+	Created         time.Time `json:"created"`
+	Author          string    `json:"author,omitempty"`
+	Comment         string    `json:"comment,omitempty"`
+	DockerVersion   string    `json:"docker_version,omitempty"`
+	Architecture    string    `json:"architecture,omitempty"`
+	OS              string    `json:"os,omitempty"`
+	Config          v1.Config `json:"config"`
+	ContainerConfig v1.Config `json:"container_config"`
+}
+
+// isLegacyArchive indicates if the given tar is a legacy docker archive (a repositories file without a manifest.json).
+func isLegacyArchive(tarPath string) bool {
+	return tarContains(tarPath, legacyRepositoriesFile) && !tarContains(tarPath, "manifest.json")
+}
+
+// legacyImageFromPath creates an image from a legacy docker archive along with the tags recorded for the image. The
+// layers are ordered by following the parent of each layer from the top layer, and the image config is derived from
+// the top layer config (with history derived from the command that created each layer).
+func legacyImageFromPath(tarPath string) (v1.Image, []string, error) {
+	topLayerID, tags, err := readLegacyRepositories(tarPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	layerConfigs, err := readLegacyLayerConfigs(tarPath, topLayerID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var addendums []mutate.Addendum
+	for _, layerConfig := range layerConfigs {
+		layer, err := tarball.LayerFromOpener(legacyLayerOpener(tarPath, layerConfig.ID+"/layer.tar"))
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read layer=%q: %w", layerConfig.ID, err)
+		}
+		addendums = append(addendums, mutate.Addendum{
+			Layer: layer,
+			History: v1.History{
+				Created:   v1.Time{Time: layerConfig.Created},
+				CreatedBy: strings.Join(layerConfig.ContainerConfig.Cmd, " "),
+				Author:    layerConfig.Author,
+				Comment:   layerConfig.Comment,
+			},
+		})
+	}
+
+	img, err := mutate.Append(empty.Image, addendums...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to assemble image from legacy archive: %w", err)
+	}
+
+	baseConfig, err := img.ConfigFile()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get image config: %w", err)
+	}
+
+	top := layerConfigs[len(layerConfigs)-1]
+	config := baseConfig.DeepCopy()
+	config.Architecture = top.Architecture
+	config.OS = top.OS
+	config.Created = v1.Time{Time: top.Created}
+	config.Author = top.Author
+	config.DockerVersion = top.DockerVersion
+	config.Config = top.Config
+
+	img, err = mutate.ConfigFile(img, config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to set image config: %w", err)
+	}
+	return img, tags, nil
+}
+
+// readLegacyRepositories returns the top layer ID of the single image within the archive along with all of its tags.
+func readLegacyRepositories(tarPath string) (string, []string, error) {
+	contents, err := readFileFromTar(tarPath, legacyRepositoriesFile)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var repositories legacyRepositories
+	if err := json.Unmarshal(contents, &repositories); err != nil {
+		return "", nil, fmt.Errorf("unable to parse %s: %w", legacyRepositoriesFile, err)
+	}
+
+	var topLayerID string
+	var tags []string
+	for repo, tagToID := range repositories {
+		for tag, id := range tagToID {
+			if topLayerID != "" && topLayerID != id {
+				return "", nil, ErrMultipleManifests
+			}
+			topLayerID = id
+			tags = append(tags, repo+":"+tag)
+		}
+	}
+
+	if topLayerID == "" {
+		return "", nil, fmt.Errorf("no images found in %s", legacyRepositoriesFile)
+	}
+	sort.Strings(tags)
+	return topLayerID, tags, nil
+}
+
+// readLegacyLayerConfigs returns the layer configs for the image with the given top layer, ordered from the base layer.
+func readLegacyLayerConfigs(tarPath, topLayerID string) ([]legacyLayerConfig, error) {
+	var layerConfigs []legacyLayerConfig
+	seen := make(map[string]struct{})
+	for id := topLayerID; id != ""; {
+		if _, ok := seen[id]; ok {
+			return nil, fmt.Errorf("cycle detected in legacy layer parents at layer=%q", id)
+		}
+		seen[id] = struct{}{}
+
+		contents, err := readFileFromTar(tarPath, id+"/json")
+		if err != nil {
+			return nil, fmt.Errorf("unable to find config for layer=%q: %w", id, err)
+		}
+
+		var layerConfig legacyLayerConfig
+		if err := json.Unmarshal(contents, &layerConfig); err != nil {
+			return nil, fmt.Errorf("unable to parse config for layer=%q: %w", id, err)
+		}
+		layerConfig.ID = id
+
+		layerConfigs = append([]legacyLayerConfig{layerConfig}, layerConfigs...)
+		id = layerConfig.Parent
+	}
+	return layerConfigs, nil
+}
+
+// legacyLayerOpener returns an opener for the layer tar at the given path within the archive.
+func legacyLayerOpener(tarPath, layerPath string) tarball.Opener {
+	return func() (io.ReadCloser, error) {
+		f, err := os.Open(tarPath)
+		if err != nil {
+			return nil, err
+		}
+		reader, err := file.ReaderFromTar(f, layerPath)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return reader, nil
+	}
+}
+
+// readFileFromTar reads the entire contents of the file at the given path within the tar.
+func readFileFromTar(tarPath, filePath string) ([]byte, error) {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Errorf("unable to close tar file (%s): %w", f.Name(), err)
+		}
+	}()
+
+	reader, err := file.ReaderFromTar(f, filePath)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(reader)
+}
+
+// tarContains indicates if there is a file at the given path within the tar.
+func tarContains(tarPath, filePath string) bool {
+	f, err := os.Open(tarPath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	_, err = file.ReaderFromTar(f, filePath)
+	return err == nil
+}
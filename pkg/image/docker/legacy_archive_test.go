@@ -0,0 +1,54 @@
+package docker
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTarballImageProvider_Provide_LegacyArchive(t *testing.T) {
+	// this fixture has the layout written by "docker save" before Docker 1.10: a repositories file along with
+	// "<layer ID>/VERSION", "<layer ID>/json", and "<layer ID>/layer.tar" for each layer (and no manifest.json). See
+	// test-fixtures/generators/legacy-archive.sh for how it is built.
+	fixture := "test-fixtures/legacy-archive.tar"
+	require.True(t, isLegacyArchive(fixture))
+
+	tmpDirGen := file.NewTempDirGenerator()
+	t.Cleanup(func() {
+		assert.NoError(t, tmpDirGen.Cleanup())
+	})
+
+	img, err := NewProviderFromTarball(fixture, &tmpDirGen, nil, nil).Provide()
+	require.NoError(t, err)
+	require.NoError(t, img.Read())
+
+	require.Len(t, img.Metadata.Tags, 1)
+	assert.Equal(t, "index.docker.io/library/stereoscope-fixture-legacy:latest", img.Metadata.Tags[0].Name())
+
+	assert.Len(t, img.Layers, 2)
+	assert.Equal(t, "amd64", img.Metadata.Config.Architecture)
+	assert.Equal(t, []string{"/bin/sh"}, img.Metadata.Config.Config.Cmd)
+	require.Len(t, img.Metadata.Config.History, 2)
+	assert.Equal(t, "/bin/sh -c #(nop) ADD dir:legacy in /", img.Metadata.Config.History[0].CreatedBy)
+
+	for p, expected := range map[file.Path]string{
+		"/etc/os-release": "NAME=\"legacy\"\n",
+		"/file-1.txt":     "first file (updated)\n",
+		"/file-2.txt":     "second file\n",
+	} {
+		reader, err := img.FileContentsFromSquash(p)
+		require.NoError(t, err)
+		actual, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, expected, string(actual), "path=%s", p)
+	}
+}
+
+func TestIsLegacyArchive(t *testing.T) {
+	assert.True(t, isLegacyArchive("test-fixtures/legacy-archive.tar"))
+	assert.False(t, isLegacyArchive("test-fixtures/empty-file"))
+	assert.False(t, isLegacyArchive("test-fixtures/does-not-exist.tar"))
+}
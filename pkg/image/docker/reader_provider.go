@@ -0,0 +1,58 @@
+package docker
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/anchore/stereoscope/internal/log"
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/anchore/stereoscope/pkg/image"
+)
+
+// ReaderImageProvider is a image.Provider for a docker image (V2) archive that is read from a stream, such as a pipe
+// from a "docker image save ..." command. The stream is not required to be seekable.
+type ReaderImageProvider struct {
+	reader    io.Reader
+	tmpDirGen *file.TempDirGenerator
+}
+
+// NewProviderFromReader creates a new provider instance for the docker image archive provided by the given reader.
+func NewProviderFromReader(reader io.Reader, tmpDirGen *file.TempDirGenerator) *ReaderImageProvider {
+	return &ReaderImageProvider{
+		reader:    reader,
+		tmpDirGen: tmpDirGen,
+	}
+}
+
+// Provide an image object that represents the docker image archive read from the configured stream. The stream is
+// spooled to disk within a temp dir (never held in memory) so that all regular image queries may be supported.
+func (p *ReaderImageProvider) Provide() (*image.Image, error) {
+	imageTempDir, err := p.tmpDirGen.NewTempDir()
+	if err != nil {
+		return nil, err
+	}
+
+	tempTarFile, err := os.Create(path.Join(imageTempDir, "image.tar"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create temp file for image: %w", err)
+	}
+	defer func() {
+		err := tempTarFile.Close()
+		if err != nil {
+			log.Errorf("unable to close temp file (%s): %w", tempTarFile.Name(), err)
+		}
+	}()
+
+	nBytes, err := io.Copy(tempTarFile, p.reader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to spool image archive to disk: %w", err)
+	}
+	if nBytes == 0 {
+		return nil, fmt.Errorf("cannot provide an empty image")
+	}
+
+	// use the existing tarball provider to process what was spooled from the stream
+	return NewProviderFromTarball(tempTarFile.Name(), p.tmpDirGen, nil, nil).Provide()
+}
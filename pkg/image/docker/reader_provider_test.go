@@ -0,0 +1,53 @@
+package docker
+
+import (
+	"io"
+	"testing"
+
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReaderImageProvider_Provide(t *testing.T) {
+	img, err := random.Image(1024, 3)
+	require.NoError(t, err)
+
+	ref, err := name.NewTag("stereoscope-fixture-piped:latest")
+	require.NoError(t, err)
+
+	// use a pipe to ensure that the provider does not rely on seeking
+	pipeReader, pipeWriter := io.Pipe()
+	go func() {
+		pipeWriter.CloseWithError(tarball.Write(ref, img, pipeWriter))
+	}()
+
+	tmpDirGen := file.NewTempDirGenerator()
+	t.Cleanup(func() {
+		assert.NoError(t, tmpDirGen.Cleanup())
+	})
+
+	provided, err := NewProviderFromReader(pipeReader, &tmpDirGen).Provide()
+	require.NoError(t, err)
+	require.NoError(t, provided.Read())
+
+	assert.Len(t, provided.Layers, 3)
+	require.Len(t, provided.Metadata.Tags, 1)
+	assert.Equal(t, ref.String(), provided.Metadata.Tags[0].String())
+}
+
+func TestReaderImageProvider_Provide_Empty(t *testing.T) {
+	tmpDirGen := file.NewTempDirGenerator()
+	t.Cleanup(func() {
+		assert.NoError(t, tmpDirGen.Cleanup())
+	})
+
+	pipeReader, pipeWriter := io.Pipe()
+	assert.NoError(t, pipeWriter.Close())
+
+	_, err := NewProviderFromReader(pipeReader, &tmpDirGen).Provide()
+	assert.Error(t, err)
+}
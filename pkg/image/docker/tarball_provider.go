@@ -36,6 +36,10 @@ func NewProviderFromTarball(path string, tmpDirGen *file.TempDirGenerator, tags
 func (p *TarballImageProvider) Provide() (*image.Image, error) {
 	img, err := tarball.ImageFromPath(p.path, nil)
 	if err != nil {
+		// archives from "docker save" before Docker 1.10 have no manifest.json, but can still be read
+		if isLegacyArchive(p.path) {
+			return p.provideLegacy()
+		}
 		// raise a more controlled error for when there are multiple images within the given tar (from https://github.com/anchore/grype/issues/215)
 		if err.Error() == "tarball must contain only a single image to be used with tarball.Image" {
 			return nil, ErrMultipleManifests
@@ -99,3 +103,31 @@ func (p *TarballImageProvider) Provide() (*image.Image, error) {
 
 	return image.NewImage(img, contentTempDir, metadata...), nil
 }
+
+// provideLegacy provides an image object for a legacy docker archive (see legacyImageFromPath), with tags populated
+// from the repositories file.
+func (p *TarballImageProvider) provideLegacy() (*image.Image, error) {
+	log.Debugf("reading legacy docker archive=%q", p.path)
+	img, legacyTags, err := legacyImageFromPath(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to provide image from legacy tarball: %w", err)
+	}
+
+	var tags = internal.NewStringSet(legacyTags...)
+	for _, t := range p.extraTags {
+		tags.Add(t)
+	}
+
+	var metadata []image.AdditionalMetadata
+	if len(tags) > 0 {
+		metadata = append(metadata, image.WithTags(tags.ToSlice()...))
+	}
+	metadata = append(metadata, image.WithRepoDigests(p.repoDigests))
+
+	contentTempDir, err := p.tmpDirGen.NewTempDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return image.NewImage(img, contentTempDir, metadata...), nil
+}
@@ -0,0 +1,105 @@
+#!/usr/bin/env bash
+set -ue
+
+# use this script to regenerate test-fixtures/legacy-archive.tar, e.g.:
+#
+#   ./generators/legacy-archive.sh legacy-archive.tar
+#
+# The fixture mirrors the layout written by "docker save" before Docker 1.10 (the json metadata records
+# docker_version 1.9.1): a "repositories" file plus "<layer ID>/VERSION", "<layer ID>/json" and "<layer ID>/layer.tar"
+# for each layer, and no manifest.json. Current Docker releases can no longer write this layout, so the archive is
+# assembled directly (with fixed layer IDs and timestamps so the output is byte-for-byte reproducible) instead of being
+# saved from a daemon.
+
+FIXTURE_TAR_PATH=$1
+
+python3 - "${FIXTURE_TAR_PATH}" <<'EOF'
+import hashlib
+import io
+import json
+import sys
+import tarfile
+
+MTIME = 1446400000  # 2015-11-01
+
+
+def tarbytes(entries):
+    buf = io.BytesIO()
+    with tarfile.open(fileobj=buf, mode='w', format=tarfile.GNU_FORMAT) as t:
+        for name, data, typ in entries:
+            ti = tarfile.TarInfo(name)
+            ti.mtime = MTIME
+            ti.uname = ''
+            ti.gname = ''
+            if typ == 'dir':
+                ti.type = tarfile.DIRTYPE
+                ti.mode = 0o755
+                t.addfile(ti)
+            else:
+                ti.mode = 0o644
+                ti.size = len(data)
+                t.addfile(ti, io.BytesIO(data))
+    return buf.getvalue()
+
+
+def container_config(cmd):
+    return {
+        "Hostname": "", "Domainname": "", "User": "",
+        "AttachStdin": False, "AttachStdout": False, "AttachStderr": False,
+        "Tty": False, "OpenStdin": False, "StdinOnce": False,
+        "Env": ["PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"],
+        "Cmd": cmd, "Image": "", "Volumes": None, "WorkingDir": "", "Entrypoint": None, "OnBuild": None, "Labels": {},
+    }
+
+
+base_id = hashlib.sha256(b'stereoscope-legacy-base').hexdigest()
+top_id = hashlib.sha256(b'stereoscope-legacy-top').hexdigest()
+
+base_layer = tarbytes([
+    ('etc/', '', 'dir'),
+    ('etc/os-release', b'NAME="legacy"\n', 'f'),
+    ('file-1.txt', b'first file\n', 'f'),
+])
+top_layer = tarbytes([
+    ('file-1.txt', b'first file (updated)\n', 'f'),
+    ('file-2.txt', b'second file\n', 'f'),
+])
+
+base_json = {
+    "id": base_id,
+    "created": "2015-11-01T00:00:00.000000000Z",
+    "container": "3a1e9d4c2f6f",
+    "container_config": container_config(["/bin/sh", "-c", "#(nop) ADD dir:legacy in /"]),
+    "docker_version": "1.9.1",
+    "config": container_config(None),
+    "architecture": "amd64",
+    "os": "linux",
+    "Size": len(base_layer),
+}
+top_json = {
+    "id": top_id,
+    "parent": base_id,
+    "created": "2015-11-01T00:01:00.000000000Z",
+    "container": "7b2c8e5d1a9f",
+    "container_config": container_config(["/bin/sh", "-c", "#(nop) ADD multi:legacy in /"]),
+    "docker_version": "1.9.1",
+    "config": dict(container_config(["/bin/sh"]), WorkingDir="/"),
+    "architecture": "amd64",
+    "os": "linux",
+    "Size": len(top_layer),
+}
+
+entries = []
+for layer_id, metadata, layer in [(base_id, base_json, base_layer), (top_id, top_json, top_layer)]:
+    entries += [
+        (layer_id + '/', '', 'dir'),
+        (layer_id + '/VERSION', b'1.0', 'f'),
+        (layer_id + '/json', json.dumps(metadata, separators=(',', ':')).encode(), 'f'),
+        (layer_id + '/layer.tar', layer, 'f'),
+    ]
+repositories = {"stereoscope-fixture-legacy": {"latest": top_id}}
+entries.append(('repositories', (json.dumps(repositories, separators=(',', ':')) + "\n").encode(), 'f'))
+
+with open(sys.argv[1], 'wb') as f:
+    f.write(tarbytes(entries))
+EOF
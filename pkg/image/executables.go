@@ -0,0 +1,136 @@
+package image
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/anchore/stereoscope/pkg/file"
+)
+
+// ExecutableFormat is the format of an executable file, as determined by the leading (magic) bytes of the file.
+type ExecutableFormat string
+
+const (
+	// ELFExecutable is an ELF binary (Linux and most other unix-like systems).
+	ELFExecutable ExecutableFormat = "elf"
+	// PEExecutable is a PE (MZ) binary (Windows).
+	PEExecutable ExecutableFormat = "pe"
+	// MachOExecutable is a Mach-O binary (macOS), including universal (fat) binaries.
+	MachOExecutable ExecutableFormat = "macho"
+	// ScriptExecutable is a script with a shebang line (e.g. "#!/bin/sh").
+	ScriptExecutable ExecutableFormat = "script"
+)
+
+// executableHeaderSize is the number of leading bytes read from each file to determine the executable format.
+const executableHeaderSize = 8
+
+// peHeaderSize is the number of leading bytes read from files with the DOS (MZ) magic, which must contain the PE
+// signature (at the offset given by the DOS header) for the file to be considered a PE binary.
+const peHeaderSize = 4096
+
+// peHeaderOffsetField is the offset of the field of the DOS header (e_lfanew) holding the offset of the PE signature.
+const peHeaderOffsetField = 0x3c
+
+// javaClassMinMajorVersion is the lowest major version of a java class file, which shares a magic number with
+// universal Mach-O binaries (0xcafebabe). The field at the same position of a universal binary is the number of
+// architectures, which is much smaller in practice.
+const javaClassMinMajorVersion = 45
+
+// ExecutableFile is a file reference along with the detected executable format.
+type ExecutableFile struct {
+	Reference file.Reference
+	Format    ExecutableFormat
+}
+
+// Executables returns all regular files within the image squash (sorted by path) that are ELF, PE, or Mach-O binaries
+// or shebang scripts, along with the detected format. Only the first few bytes of each file are read (up to a few KB for
+// files with a DOS header, to find the PE signature), so the full contents of files are never read.
+func (i *Image) Executables() ([]ExecutableFile, error) {
+	var executables []ExecutableFile
+	for _, ref := range i.SquashedTree().AllFiles(file.TypeReg) {
+		entry, err := i.FileCatalog.Get(ref)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find catalog entry for ref=%+v: %w", ref, err)
+		}
+		if entry.Contents == nil || entry.Metadata.Size < 2 {
+			continue
+		}
+
+		header, err := readFileHeader(entry.Contents, executableHeaderSize)
+		if err == nil && bytes.HasPrefix(header, dosMagic) {
+			// the PE signature is further into the file, past the DOS header
+			header, err = readFileHeader(entry.Contents, peHeaderSize)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read header for path=%q: %w", ref.RealPath, err)
+		}
+
+		if format, ok := executableFormat(header); ok {
+			executables = append(executables, ExecutableFile{Reference: ref, Format: format})
+		}
+	}
+
+	sort.Slice(executables, func(a, b int) bool {
+		return executables[a].Reference.RealPath < executables[b].Reference.RealPath
+	})
+	return executables, nil
+}
+
+// readFileHeader reads up to the given number of leading bytes of the file contents.
+func readFileHeader(opener file.Opener, size int) ([]byte, error) {
+	reader := opener()
+	defer reader.Close()
+
+	header := make([]byte, size)
+	n, err := io.ReadFull(reader, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return header[:n], nil
+}
+
+// dosMagic is the magic of the DOS header that precedes the PE header of PE binaries.
+var dosMagic = []byte("MZ")
+
+// isPE indicates if the given header (starting with the DOS magic) has the PE signature at the offset given by the DOS
+// header. Files where the signature is beyond the given header are not considered to be PE binaries.
+func isPE(header []byte) bool {
+	if len(header) < peHeaderOffsetField+4 {
+		return false
+	}
+	offset := int64(binary.LittleEndian.Uint32(header[peHeaderOffsetField : peHeaderOffsetField+4]))
+	if offset+4 > int64(len(header)) {
+		return false
+	}
+	return bytes.Equal(header[offset:offset+4], []byte("PE\x00\x00"))
+}
+
+// executableFormat determines the executable format from the leading bytes of a file.
+func executableFormat(header []byte) (ExecutableFormat, bool) {
+	switch {
+	case bytes.HasPrefix(header, []byte("\x7fELF")):
+		return ELFExecutable, true
+	case bytes.HasPrefix(header, dosMagic):
+		if isPE(header) {
+			return PEExecutable, true
+		}
+		return "", false
+	case bytes.HasPrefix(header, []byte("#!")):
+		return ScriptExecutable, true
+	case len(header) < 4:
+		return "", false
+	}
+
+	switch binary.BigEndian.Uint32(header[:4]) {
+	case 0xfeedface, 0xfeedfacf, 0xcefaedfe, 0xcffaedfe:
+		return MachOExecutable, true
+	case 0xcafebabe:
+		if len(header) >= 8 && binary.BigEndian.Uint32(header[4:8]) < javaClassMinMajorVersion {
+			return MachOExecutable, true
+		}
+	}
+	return "", false
+}
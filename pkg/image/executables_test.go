@@ -0,0 +1,66 @@
+package image
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImage_Executables(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest: "sha256:a",
+			paths: []string{
+				"/bin/busybox", "/bin/entrypoint.sh", "/app/tool.exe", "/app/tool-darwin", "/app/tool-universal",
+				"/app/Main.class", "/etc/hosts", "/empty", "/a", "/app/MZ.txt", "/app/dos.com", "/app/truncated.exe",
+			},
+			contents: map[string]string{
+				"/bin/busybox":        "\x7fELF\x02\x01\x01\x00",
+				"/bin/entrypoint.sh":  "#!/bin/sh\nexec \"$@\"\n",
+				"/app/tool.exe":       peBinary(0x80, "PE\x00\x00"),
+				"/app/tool-darwin":    "\xcf\xfa\xed\xfe\x07\x00\x00\x01",
+				"/app/tool-universal": "\xca\xfe\xba\xbe\x00\x00\x00\x02",
+				"/app/Main.class":     "\xca\xfe\xba\xbe\x00\x00\x00\x34",
+				"/etc/hosts":          "127.0.0.1 localhost\n",
+				"/empty":              "",
+				"/a":                  "#",
+				// files with the DOS magic but without the PE signature are not PE binaries
+				"/app/MZ.txt":        "MZ is the DOS executable magic\n",
+				"/app/dos.com":       peBinary(0x80, "\x00\x00\x00\x00"),
+				"/app/truncated.exe": peBinary(0x80, "PE\x00\x00")[:0x82],
+			},
+		},
+	)
+
+	executables, err := img.Executables()
+	require.NoError(t, err)
+
+	actual := make(map[file.Path]ExecutableFormat)
+	for _, e := range executables {
+		actual[e.Reference.RealPath] = e.Format
+	}
+
+	assert.Equal(t, map[file.Path]ExecutableFormat{
+		"/bin/busybox":        ELFExecutable,
+		"/bin/entrypoint.sh":  ScriptExecutable,
+		"/app/tool.exe":       PEExecutable,
+		"/app/tool-darwin":    MachOExecutable,
+		"/app/tool-universal": MachOExecutable,
+	}, actual)
+
+	// results are sorted by path
+	require.Len(t, executables, 5)
+	assert.Equal(t, file.Path("/app/tool-darwin"), executables[0].Reference.RealPath)
+}
+
+// peBinary returns the leading bytes of a PE binary, with a DOS header pointing to the given signature at the given
+// offset.
+func peBinary(offset uint32, signature string) string {
+	header := make([]byte, offset)
+	copy(header, "MZ")
+	binary.LittleEndian.PutUint32(header[0x3c:], offset)
+	return string(header) + signature + "\x4c\x01"
+}
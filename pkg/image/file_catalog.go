@@ -1,19 +1,27 @@
 package image
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/anchore/stereoscope/pkg/file"
 )
 
 var ErrFileNotFound = fmt.Errorf("could not find file")
 
+// ErrContentNotCached is returned when a file is cataloged but there is no content available for it.
+var ErrContentNotCached = fmt.Errorf("no contents available for file")
+
 // FileCatalog represents all file metadata and source tracing for all files contained within the image layer
-// blobs (i.e. everything except for the image index/manifest/metadata files).
+// blobs (i.e. everything except for the image index/manifest/metadata files). The catalog is safe for concurrent use.
 type FileCatalog struct {
+	lock       *sync.RWMutex
 	catalog    map[file.ID]FileCatalogEntry
 	byMIMEType map[string][]file.ID
+	// annotations are user provided key-value pairs for file references (see Image.Annotate)
+	annotations map[file.ID]map[string]string
 }
 
 // FileCatalogEntry represents all stored metadata for a single file reference.
@@ -24,17 +32,26 @@ type FileCatalogEntry struct {
 	Contents file.Opener
 }
 
+// Type returns the type of the file, as determined from the tar header typeflag.
+func (e FileCatalogEntry) Type() file.Type {
+	return file.TypeFromTarType(e.Metadata.TypeFlag)
+}
+
 // NewFileCatalog returns an empty FileCatalog.
 func NewFileCatalog() FileCatalog {
 	return FileCatalog{
-		catalog:    make(map[file.ID]FileCatalogEntry),
-		byMIMEType: make(map[string][]file.ID),
+		lock:        &sync.RWMutex{},
+		catalog:     make(map[file.ID]FileCatalogEntry),
+		byMIMEType:  make(map[string][]file.ID),
+		annotations: make(map[file.ID]map[string]string),
 	}
 }
 
 // Add creates a new FileCatalogEntry for the given file reference and metadata, cataloged by the ID of the
 // file reference (overwriting any existing entries without warning).
 func (c *FileCatalog) Add(f file.Reference, m file.Metadata, l *Layer, opener file.Opener) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
 	if m.MIMEType != "" {
 		// an empty MIME type means that we didn't have the contents of the file to determine the MIME type. If we have
 		// the contents and the MIME type could not be determined then the default value is application/octet-stream.
@@ -50,6 +67,8 @@ func (c *FileCatalog) Add(f file.Reference, m file.Metadata, l *Layer, opener fi
 
 // Exists indicates if the given file reference exists in the catalog.
 func (c *FileCatalog) Exists(f file.Reference) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	_, ok := c.catalog[f.ID()]
 	return ok
 }
@@ -57,6 +76,8 @@ func (c *FileCatalog) Exists(f file.Reference) bool {
 // Get fetches a FileCatalogEntry for the given file reference, or returns an error if the file reference has not
 // been added to the catalog.
 func (c *FileCatalog) Get(f file.Reference) (FileCatalogEntry, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	value, ok := c.catalog[f.ID()]
 	if !ok {
 		return FileCatalogEntry{}, ErrFileNotFound
@@ -64,7 +85,34 @@ func (c *FileCatalog) Get(f file.Reference) (FileCatalogEntry, error) {
 	return value, nil
 }
 
+// LayerIndex returns the index of the layer that introduced the given file reference. Since squash trees retain the
+// original file references from each layer, this may be used to attribute any reference from a squash tree to the
+// layer it originated from without walking the layer trees.
+func (c *FileCatalog) LayerIndex(f file.Reference) (uint, error) {
+	entry, err := c.Get(f)
+	if err != nil {
+		return 0, err
+	}
+	if entry.Layer == nil {
+		return 0, fmt.Errorf("no layer found for file: %+v", f.RealPath)
+	}
+	return entry.Layer.Metadata.Index, nil
+}
+
+// TarHeaderName returns the entry name exactly as found within the layer tar header for the given file reference, along
+// with the position of that header within the layer tar (the nth header). This is useful for correlating references
+// with tools that operate on the raw layer tar.
+func (c *FileCatalog) TarHeaderName(f file.Reference) (string, int, error) {
+	entry, err := c.Get(f)
+	if err != nil {
+		return "", 0, err
+	}
+	return entry.Metadata.TarHeaderName, int(entry.Metadata.TarSequence), nil
+}
+
 func (c *FileCatalog) GetByMIMEType(mType string) ([]FileCatalogEntry, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	fileIDs, ok := c.byMIMEType[mType]
 	if !ok {
 		return nil, nil
@@ -73,7 +121,7 @@ func (c *FileCatalog) GetByMIMEType(mType string) ([]FileCatalogEntry, error) {
 	for _, id := range fileIDs {
 		entry, ok := c.catalog[id]
 		if !ok {
-			return nil, fmt.Errorf("could not find file: %+v", id)
+			return nil, fmt.Errorf("%w: %+v", ErrFileNotFound, id)
 		}
 		entries = append(entries, entry)
 	}
@@ -81,17 +129,165 @@ func (c *FileCatalog) GetByMIMEType(mType string) ([]FileCatalogEntry, error) {
 	return entries, nil
 }
 
+// Annotate sets the given user annotation for the given file reference, replacing any existing value for the key.
+func (c *FileCatalog) Annotate(f file.Reference, key, value string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	annotations, ok := c.annotations[f.ID()]
+	if !ok {
+		annotations = make(map[string]string)
+		c.annotations[f.ID()] = annotations
+	}
+	annotations[key] = value
+}
+
+// Annotations returns a copy of all user annotations for the given file reference (nil if there are none).
+func (c *FileCatalog) Annotations(f file.Reference) map[string]string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	annotations, ok := c.annotations[f.ID()]
+	if !ok {
+		return nil
+	}
+	result := make(map[string]string, len(annotations))
+	for k, v := range annotations {
+		result[k] = v
+	}
+	return result
+}
+
 // FetchContents reads the file contents for the given file reference from the underlying image/layer blob. An error
 // is returned if there is no file at the given path and layer or the read operation cannot continue.
 func (c *FileCatalog) FileContents(f file.Reference) (io.ReadCloser, error) {
+	c.lock.RLock()
 	catalogEntry, ok := c.catalog[f.ID()]
+	c.lock.RUnlock()
 	if !ok {
-		return nil, fmt.Errorf("could not find file: %+v", f.RealPath)
+		return nil, fmt.Errorf("%w: %+v", ErrFileNotFound, f.RealPath)
 	}
 
 	if catalogEntry.Contents == nil {
-		return nil, fmt.Errorf("no contents available for file: %+v", f.RealPath)
+		return nil, fmt.Errorf("%w: %+v", ErrContentNotCached, f.RealPath)
 	}
 
 	return catalogEntry.Contents(), nil
 }
+
+// fileCatalogRecord is a single line of the NDJSON export of the file catalog (see FileCatalog.WriteNDJSON).
+type fileCatalogRecord struct {
+	Path       string `json:"path"`
+	Layer      string `json:"layer,omitempty"`
+	LayerIndex *uint  `json:"layerIndex,omitempty"`
+	Digest     string `json:"digest,omitempty"`
+	Size       int64  `json:"size"`
+	Mode       string `json:"mode"`
+	Type       string `json:"type"`
+	LinkTarget string `json:"linkTarget,omitempty"`
+}
+
+// WriteNDJSON writes the metadata for every file in the catalog to the given writer as newline-delimited JSON (one
+// object per line). Each record includes the path, layer, sha256 content digest (regular files only), size, mode, type,
+// and link target. Records are written in catalog order (which is not sorted nor stable between calls); only the file
+// IDs are snapshot up front, each record is looked up, encoded and written one at a time so contents are only read for
+// a single file at a time.
+func (c *FileCatalog) WriteNDJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	for _, id := range c.ids() {
+		entry, ok := c.entry(id)
+		if !ok {
+			return fmt.Errorf("unable to find catalog entry for id=%d", id)
+		}
+		ref := entry.File
+
+		fileType := entry.Type()
+
+		record := fileCatalogRecord{
+			Path:       string(ref.RealPath),
+			Size:       entry.Metadata.Size,
+			Mode:       entry.Metadata.Mode.String(),
+			Type:       fileType.String(),
+			LinkTarget: entry.Metadata.Linkname,
+		}
+
+		if entry.Layer != nil {
+			index := entry.Layer.Metadata.Index
+			record.Layer = entry.Layer.Metadata.Digest
+			record.LayerIndex = &index
+		}
+
+		if fileType == file.TypeReg && entry.Contents != nil {
+			digest, err := fetchFileDigest(c, ref)
+			if err != nil {
+				return err
+			}
+			record.Digest = digest
+		}
+
+		if err := encoder.Encode(record); err != nil {
+			return fmt.Errorf("unable to write catalog record for path=%q: %w", ref.RealPath, err)
+		}
+	}
+	return nil
+}
+
+// ids returns the IDs of all file references in the catalog (in catalog order).
+func (c *FileCatalog) ids() []file.ID {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	ids := make([]file.ID, 0, len(c.catalog))
+	for id := range c.catalog {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// entry returns the catalog entry for the given file ID.
+func (c *FileCatalog) entry(id file.ID) (FileCatalogEntry, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	entry, ok := c.catalog[id]
+	return entry, ok
+}
+
+// size returns the number of entries in the catalog.
+func (c *FileCatalog) size() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return len(c.catalog)
+}
+
+// distinctContentCount returns the number of distinct regular file contents in the catalog by content digest (whiteout
+// markers are not counted). Digests that are not yet known are computed from the file contents (and cached).
+func (c *FileCatalog) distinctContentCount() (int, error) {
+	c.lock.RLock()
+	var refs []file.Reference
+	for _, entry := range c.catalog {
+		if entry.Type() != file.TypeReg || entry.File.RealPath.IsWhiteout() {
+			continue
+		}
+		refs = append(refs, entry.File)
+	}
+	c.lock.RUnlock()
+
+	digests := make(map[string]struct{})
+	for _, ref := range refs {
+		digest, err := fetchFileDigest(c, ref)
+		if err != nil {
+			return 0, err
+		}
+		digests[digest] = struct{}{}
+	}
+	return len(digests), nil
+}
+
+// setDigest records the given content digest for the given file reference (if cataloged).
+func (c *FileCatalog) setDigest(f file.Reference, digest string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	entry, ok := c.catalog[f.ID()]
+	if !ok {
+		return
+	}
+	entry.Metadata.Digest = digest
+	c.catalog[f.ID()] = entry
+}
@@ -5,6 +5,7 @@ package image
 
 import (
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -12,6 +13,8 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/go-test/deep"
@@ -151,6 +154,28 @@ func TestFileCatalog_Add(t *testing.T) {
 	}
 }
 
+func TestFileCatalog_TarHeaderName(t *testing.T) {
+	ref := file.NewFileReference("/etc/hosts")
+	catalog := NewFileCatalog()
+	catalog.Add(*ref, file.Metadata{Path: "/etc/hosts", TarHeaderName: "./etc/hosts", TarSequence: 3}, nil, nil)
+
+	name, idx, err := catalog.TarHeaderName(*ref)
+	if err != nil {
+		t.Fatalf("could not get tar header name: %+v", err)
+	}
+	if name != "./etc/hosts" {
+		t.Errorf("unexpected tar header name: %q", name)
+	}
+	if idx != 3 {
+		t.Errorf("unexpected tar index: %d", idx)
+	}
+
+	_, _, err = catalog.TarHeaderName(*file.NewFileReference("/etc/hosts"))
+	if !errors.Is(err, ErrFileNotFound) {
+		t.Errorf("expected file not found error, got: %+v", err)
+	}
+}
+
 type testLayerContent struct {
 }
 
@@ -231,3 +256,57 @@ func TestFileCatalog_FileContents(t *testing.T) {
 		t.Errorf("diff: %+v", d)
 	}
 }
+
+func TestFileCatalog_ConcurrentAccess(t *testing.T) {
+	catalog := NewFileCatalog()
+
+	var refs []file.Reference
+	for i := 0; i < 100; i++ {
+		refs = append(refs, *file.NewFileReference(file.Path(fmt.Sprintf("/file-%d.txt", i))))
+	}
+
+	var wg sync.WaitGroup
+	for _, ref := range refs {
+		wg.Add(2)
+		go func(ref file.Reference) {
+			defer wg.Done()
+			contents := string(ref.RealPath)
+			catalog.Add(ref, file.Metadata{Path: contents, MIMEType: "text/plain"}, nil, func() io.ReadCloser {
+				return ioutil.NopCloser(strings.NewReader(contents))
+			})
+		}(ref)
+		go func(ref file.Reference) {
+			defer wg.Done()
+			// the entry may or may not be added yet, these calls must not race with Add
+			catalog.Exists(ref)
+			_, _ = catalog.Get(ref)
+			_, _ = catalog.GetByMIMEType("text/plain")
+			if reader, err := catalog.FileContents(ref); err == nil {
+				_, _ = ioutil.ReadAll(reader)
+			}
+		}(ref)
+	}
+	wg.Wait()
+
+	for _, ref := range refs {
+		reader, err := catalog.FileContents(ref)
+		if err != nil {
+			t.Fatalf("could not get contents by ref: %+v", err)
+		}
+		actual, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("could not read content reader: %+v", err)
+		}
+		if string(actual) != string(ref.RealPath) {
+			t.Errorf("unexpected contents for %q: %q", ref.RealPath, actual)
+		}
+	}
+
+	entries, err := catalog.GetByMIMEType("text/plain")
+	if err != nil {
+		t.Fatalf("could not get entries by MIME type: %+v", err)
+	}
+	if len(entries) != len(refs) {
+		t.Errorf("unexpected number of entries: %d != %d", len(entries), len(refs))
+	}
+}
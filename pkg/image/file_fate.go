@@ -0,0 +1,171 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+
+	"github.com/anchore/stereoscope/pkg/file"
+)
+
+// Fate describes what became of a file introduced by a layer in the final image squash.
+type Fate int
+
+const (
+	// FateSurvived indicates the file introduced by the layer is present in the final squash.
+	FateSurvived Fate = iota
+	// FateOverridden indicates the path was replaced by a file from a higher layer.
+	FateOverridden
+	// FateDeleted indicates the path is not present in the final squash (e.g. removed by a whiteout in a higher layer).
+	FateDeleted
+)
+
+func (f Fate) String() string {
+	switch f {
+	case FateSurvived:
+		return "survived"
+	case FateOverridden:
+		return "overridden"
+	case FateDeleted:
+		return "deleted"
+	}
+	return fmt.Sprintf("Fate(%d)", int(f))
+}
+
+// ErrPathNotInLayer is returned when a path does not exist within the diff tree of a specific layer. It wraps
+// ErrPathNotFound.
+type ErrPathNotInLayer struct {
+	Path  file.Path
+	Layer int
+}
+
+func (e *ErrPathNotInLayer) Error() string {
+	return fmt.Sprintf("%s: path=%q layer=%d", ErrPathNotFound, e.Path, e.Layer)
+}
+
+func (e *ErrPathNotInLayer) Unwrap() error {
+	return ErrPathNotFound
+}
+
+// FileFate is a file introduced by a layer along with what became of it in the final image squash.
+type FileFate struct {
+	Reference file.Reference
+	Fate      Fate
+	// OverriddenBy is the index of the layer that provides the path in the final squash (only set when overridden)
+	OverriddenBy uint
+}
+
+// LayerFileFates returns every file introduced by the given layer (sorted by path) along with whether it survived to the
+// final image squash, was overridden by a higher layer, or was deleted. Whiteout entries are not included. This is
+// useful to find layers that add "dead weight" to an image (content that is not visible in the final filesystem).
+func (i *Image) LayerFileFates(layer int) ([]FileFate, error) {
+	if layer < 0 || layer >= len(i.Layers) {
+		return nil, fmt.Errorf("%w: layer=%d (image has %d layers)", ErrLayerOutOfRange, layer, len(i.Layers))
+	}
+
+	squashed := make(map[file.Path]file.Reference)
+	for _, ref := range i.SquashedTree().AllFiles(file.AllTypes...) {
+		squashed[ref.RealPath] = ref
+	}
+
+	var fates []FileFate
+	for _, ref := range i.Layers[layer].Tree.AllFiles(file.AllTypes...) {
+		if ref.RealPath.IsWhiteout() {
+			continue
+		}
+
+		winner, exists := squashed[ref.RealPath]
+		switch {
+		case !exists:
+			fates = append(fates, FileFate{Reference: ref, Fate: FateDeleted})
+		case winner.ID() == ref.ID():
+			fates = append(fates, FileFate{Reference: ref, Fate: FateSurvived})
+		default:
+			layerIdx, err := i.FileCatalog.LayerIndex(winner)
+			if err != nil {
+				return nil, fmt.Errorf("unable to find layer for path=%q: %w", winner.RealPath, err)
+			}
+			fates = append(fates, FileFate{Reference: ref, Fate: FateOverridden, OverriddenBy: layerIdx})
+		}
+	}
+
+	sort.Slice(fates, func(a, b int) bool {
+		return fates[a].Reference.RealPath < fates[b].Reference.RealPath
+	})
+
+	return fates, nil
+}
+
+// FirstLayerForPath returns the index of the lowest layer that contains the given path (the layer that introduced the
+// path). Only paths with an entry in the layer tar are considered (parent directories implied by other entries are
+// not) and symlinks are not followed. If no layer contains the path an ErrPathNotFound error is returned.
+func (i *Image) FirstLayerForPath(p file.Path) (uint, error) {
+	p = p.NormalizeForLookup()
+	for idx, layer := range i.Layers {
+		if layerHasPath(layer, p) {
+			return uint(idx), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrPathNotFound, p)
+}
+
+// LastLayerForPath returns the index of the highest layer that contains or deletes (with a whiteout entry) the given
+// path, which is the layer responsible for the final state of the path. Paths that are only removed by an opaque
+// whiteout of a parent directory are not attributed to the layer with the opaque whiteout. If no layer contains the
+// path an ErrPathNotFound error is returned.
+func (i *Image) LastLayerForPath(p file.Path) (uint, error) {
+	p = p.NormalizeForLookup()
+	whiteout := file.Path(path.Join(path.Dir(string(p)), file.WhiteoutPrefix+p.Basename()))
+	for idx := len(i.Layers) - 1; idx >= 0; idx-- {
+		if layerHasPath(i.Layers[idx], p) || layerHasPath(i.Layers[idx], whiteout) {
+			return uint(idx), nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %s", ErrPathNotFound, p)
+}
+
+// DiffFileAcrossLayers returns readers for the contents of the given path within the diff trees of the given lower and
+// upper layers (in that order), e.g. to compare how a file was changed between layers with a text diff. Basename
+// symlinks are followed within each layer tree. An ErrPathNotInLayer error is returned if either layer does not contain
+// the path. The caller is responsible for closing both readers.
+func (i *Image) DiffFileAcrossLayers(p file.Path, lowerLayer, upperLayer int) (io.ReadCloser, io.ReadCloser, error) {
+	for _, idx := range []int{lowerLayer, upperLayer} {
+		if idx < 0 || idx >= len(i.Layers) {
+			return nil, nil, fmt.Errorf("%w: layer=%d (image has %d layers)", ErrLayerOutOfRange, idx, len(i.Layers))
+		}
+	}
+
+	lower, err := i.layerFileContents(p, lowerLayer)
+	if err != nil {
+		return nil, nil, err
+	}
+	upper, err := i.layerFileContents(p, upperLayer)
+	if err != nil {
+		lower.Close()
+		return nil, nil, err
+	}
+	return lower, upper, nil
+}
+
+// layerFileContents returns a reader for the contents of the given path within the diff tree of the given layer.
+func (i *Image) layerFileContents(p file.Path, layer int) (io.ReadCloser, error) {
+	if i.Layers[layer].Tree == nil {
+		return nil, &ErrPathNotInLayer{Path: p, Layer: layer}
+	}
+	reader, err := i.Layers[layer].FileContents(p)
+	if errors.Is(err, ErrPathNotFound) {
+		return nil, &ErrPathNotInLayer{Path: p, Layer: layer}
+	}
+	return reader, err
+}
+
+// layerHasPath indicates if the layer tar has an entry for the given path (without following symlinks).
+func layerHasPath(layer *Layer, p file.Path) bool {
+	if layer.Tree == nil {
+		return false
+	}
+	exists, ref, err := layer.Tree.File(p)
+	return err == nil && exists && ref != nil
+}
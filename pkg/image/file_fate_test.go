@@ -0,0 +1,129 @@
+package image
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImage_LayerFileFates(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{digest: "sha256:a", paths: []string{"/etc/hosts", "/tmp/build.log", "/bin/sh"}},
+		testLayer{digest: "sha256:b", paths: []string{"/etc/hosts", "/tmp/" + file.WhiteoutPrefix + "build.log"}},
+	)
+
+	fates, err := img.LayerFileFates(0)
+	require.NoError(t, err)
+
+	actual := make(map[file.Path]FileFate)
+	for _, f := range fates {
+		actual[f.Reference.RealPath] = f
+	}
+
+	assert.Equal(t, FateSurvived, actual["/bin/sh"].Fate)
+	assert.Equal(t, FateOverridden, actual["/etc/hosts"].Fate)
+	assert.Equal(t, uint(1), actual["/etc/hosts"].OverriddenBy)
+	assert.Equal(t, FateDeleted, actual["/tmp/build.log"].Fate)
+
+	fates, err = img.LayerFileFates(1)
+	require.NoError(t, err)
+	for _, f := range fates {
+		assert.False(t, f.Reference.RealPath.IsWhiteout(), "unexpected whiteout: %s", f.Reference.RealPath)
+		assert.Equal(t, FateSurvived, f.Fate, "path=%s", f.Reference.RealPath)
+	}
+
+	_, err = img.LayerFileFates(2)
+	assert.True(t, errors.Is(err, ErrLayerOutOfRange), "expected ErrLayerOutOfRange, got: %+v", err)
+}
+
+func TestImage_FirstAndLastLayerForPath(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{digest: "sha256:a", paths: []string{"/etc/hosts", "/tmp/build.log", "/bin/sh"}},
+		testLayer{digest: "sha256:b", paths: []string{"/etc/hosts", "/usr/bin/app"}},
+		testLayer{digest: "sha256:c", paths: []string{"/etc/hosts", "/tmp/" + file.WhiteoutPrefix + "build.log"}},
+		testLayer{digest: "sha256:d", paths: []string{"/etc/motd"}},
+	)
+
+	tests := []struct {
+		path          file.Path
+		expectedFirst uint
+		expectedLast  uint
+		expectedErr   error
+	}{
+		{path: "/bin/sh", expectedFirst: 0, expectedLast: 0},
+		{path: "/etc/hosts", expectedFirst: 0, expectedLast: 2},
+		{path: "etc/hosts/", expectedFirst: 0, expectedLast: 2},
+		{path: "/usr/bin/app", expectedFirst: 1, expectedLast: 1},
+		// deleted paths are attributed to the layer with the whiteout
+		{path: "/tmp/build.log", expectedFirst: 0, expectedLast: 2},
+		{path: "/etc/motd", expectedFirst: 3, expectedLast: 3},
+		// implied parent directories are not part of any layer tar
+		{path: "/usr/bin", expectedErr: ErrPathNotFound},
+		{path: "/missing", expectedErr: ErrPathNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.path), func(t *testing.T) {
+			first, err := img.FirstLayerForPath(test.path)
+			if test.expectedErr != nil {
+				assert.True(t, errors.Is(err, test.expectedErr), "expected %+v, got: %+v", test.expectedErr, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, test.expectedFirst, first)
+			}
+
+			last, err := img.LastLayerForPath(test.path)
+			if test.expectedErr != nil {
+				assert.True(t, errors.Is(err, test.expectedErr), "expected %+v, got: %+v", test.expectedErr, err)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, test.expectedLast, last)
+			}
+		})
+	}
+}
+
+func TestImage_DiffFileAcrossLayers(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest:   "sha256:a",
+			paths:    []string{"/etc/app.conf", "/etc/hosts"},
+			contents: map[string]string{"/etc/app.conf": "debug=false", "/etc/hosts": "localhost"},
+		},
+		testLayer{digest: "sha256:b", paths: []string{"/usr/bin/app"}},
+		testLayer{
+			digest:   "sha256:c",
+			paths:    []string{"/etc/app.conf"},
+			contents: map[string]string{"/etc/app.conf": "debug=true"},
+		},
+	)
+
+	lower, upper, err := img.DiffFileAcrossLayers("/etc/app.conf", 0, 2)
+	require.NoError(t, err)
+	defer lower.Close()
+	defer upper.Close()
+
+	lowerContents, err := ioutil.ReadAll(lower)
+	require.NoError(t, err)
+	upperContents, err := ioutil.ReadAll(upper)
+	require.NoError(t, err)
+	assert.Equal(t, "debug=false", string(lowerContents))
+	assert.Equal(t, "debug=true", string(upperContents))
+
+	_, _, err = img.DiffFileAcrossLayers("/etc/hosts", 0, 2)
+	var notInLayer *ErrPathNotInLayer
+	require.True(t, errors.As(err, &notInLayer), "expected ErrPathNotInLayer, got: %+v", err)
+	assert.Equal(t, 2, notInLayer.Layer)
+	assert.True(t, errors.Is(err, ErrPathNotFound))
+
+	_, _, err = img.DiffFileAcrossLayers("/etc/app.conf", 1, 2)
+	require.True(t, errors.As(err, &notInLayer), "expected ErrPathNotInLayer, got: %+v", err)
+	assert.Equal(t, 1, notInLayer.Layer)
+
+	_, _, err = img.DiffFileAcrossLayers("/etc/app.conf", 0, 3)
+	assert.True(t, errors.Is(err, ErrLayerOutOfRange), "expected ErrLayerOutOfRange, got: %+v", err)
+}
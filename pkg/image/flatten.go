@@ -0,0 +1,490 @@
+package image
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anchore/stereoscope/internal/log"
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// deterministicTarTime is the timestamp given to all tar entries (and image config timestamps) of a deterministic export.
+var deterministicTarTime = time.Unix(0, 0).UTC()
+
+// TarOption configures how files from the image squash are written as a tar.
+type TarOption func(*tarConfig)
+
+type tarConfig struct {
+	deterministic bool
+}
+
+func newTarConfig(options ...TarOption) tarConfig {
+	var cfg tarConfig
+	for _, option := range options {
+		option(&cfg)
+	}
+	return cfg
+}
+
+// WithDeterministicTar makes the exported tar reproducible: exporting the same filesystem always results in the same
+// bytes. Entries are always ordered by path (with hardlinks following their targets) and written in the PAX format.
+// Without this option entries keep the modification times and ownership from the original layer tars; with this
+// option:
+//   - the modification time of every entry is set to the unix epoch (and access and change times are omitted).
+//   - the user and group IDs of every entry are set to 0 (root) and user and group names are omitted.
+//   - for flattened images, the created timestamps of the image config and history are set to the unix epoch and the
+//     history does not refer to the original image ID.
+//
+// Permission bits (including setuid, setgid, and sticky bits), file types, link destinations, and device numbers are
+// preserved as-is.
+func WithDeterministicTar() TarOption {
+	return func(cfg *tarConfig) {
+		cfg.deterministic = true
+	}
+}
+
+// WriteFlattenedImage writes a docker-archive (as loadable by "docker load") to the given writer containing a single
+// layer image built from the image squash. The original image config is preserved (env, entrypoint, cmd, working dir,
+// labels, etc.) while the layer history and rootfs are replaced to describe the single flattened layer. The given
+// tags are recorded in the archive manifest; if no tags are given the image is written untagged.
+func (i *Image) WriteFlattenedImage(w io.Writer, tags []string, options ...TarOption) error {
+	cfg := newTarConfig(options...)
+	refs, err := parseFlattenedImageTags(tags)
+	if err != nil {
+		return err
+	}
+
+	layerFile, err := ioutil.TempFile(i.contentCacheDir, "flattened-*.tar")
+	if err != nil {
+		return fmt.Errorf("unable to create flattened layer file: %w", err)
+	}
+	defer os.Remove(layerFile.Name())
+
+	err = i.writeSquashedTar(layerFile, cfg)
+	if closeErr := layerFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write flattened layer: %w", err)
+	}
+
+	layer, err := tarball.LayerFromFile(layerFile.Name())
+	if err != nil {
+		return fmt.Errorf("unable to create flattened layer: %w", err)
+	}
+
+	img, err := i.flattenedImage(layer, cfg)
+	if err != nil {
+		return err
+	}
+
+	if len(refs) == 0 {
+		digest, err := img.Digest()
+		if err != nil {
+			return fmt.Errorf("unable to get flattened image digest: %w", err)
+		}
+		// a digest reference records the image in the archive without any repo tags
+		ref, err := name.NewDigest("flattened@" + digest.String())
+		if err != nil {
+			return err
+		}
+		refs = append(refs, ref)
+	}
+
+	refToImage := make(map[name.Reference]v1.Image)
+	for _, ref := range refs {
+		refToImage[ref] = img
+	}
+
+	return tarball.MultiRefWrite(refToImage, w)
+}
+
+func parseFlattenedImageTags(tags []string) ([]name.Reference, error) {
+	var refs []name.Reference
+	for _, t := range tags {
+		tag, err := name.NewTag(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag=%q: %w", t, err)
+		}
+		refs = append(refs, tag)
+	}
+	return refs, nil
+}
+
+// flattenedImage creates a single layer image from the given layer with a config derived from the original image.
+func (i *Image) flattenedImage(layer v1.Layer, cfg tarConfig) (v1.Image, error) {
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create flattened image: %w", err)
+	}
+
+	baseConfig, err := img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("unable to get flattened image config: %w", err)
+	}
+
+	original := i.Metadata.Config
+	config := original.DeepCopy()
+	config.RootFS = baseConfig.RootFS
+	config.History = []v1.History{
+		{
+			Created: original.Created,
+			Comment: fmt.Sprintf("flattened from %d layer(s) of image=%q", len(i.Layers), i.Metadata.ID),
+		},
+	}
+	if cfg.deterministic {
+		config.Created = v1.Time{Time: deterministicTarTime}
+		config.History[0].Created = config.Created
+		config.History[0].Comment = fmt.Sprintf("flattened from %d layer(s)", len(i.Layers))
+	}
+
+	img, err = mutate.ConfigFile(img, config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to set flattened image config: %w", err)
+	}
+	return img, nil
+}
+
+// OpenFilteredTar returns a reader of a tar containing only the files from the image squash that pass the given
+// filter, along with the parent directories of each of those files and the targets of any hardlinks (regardless of the
+// filter). Hardlinks whose target was removed or replaced in a higher layer are written as regular files. The tar is
+// written as it is read, ordered by path so that parent directories precede their children (in the same way as the
+// flattened image layer). The caller is responsible for closing the returned reader.
+func (i *Image) OpenFilteredTar(filter func(file.Reference) bool, options ...TarOption) (io.ReadCloser, error) {
+	cfg := newTarConfig(options...)
+	refs, err := i.filteredSquashRefs(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(i.writeTar(writer, refs, cfg))
+	}()
+	return reader, nil
+}
+
+// filteredSquashRefs returns all references from the squashed tree that pass the given filter, along with the
+// references of all parent directories of those references and the (transitive) targets of any hardlinks, so that the
+// selected files can be extracted on their own.
+func (i *Image) filteredSquashRefs(filter func(file.Reference) bool) ([]file.Reference, error) {
+	tree := i.SquashedTree()
+
+	var pending []file.Reference
+	for _, ref := range tree.AllFiles(file.AllTypes...) {
+		if filter(ref) {
+			pending = append(pending, ref)
+		}
+	}
+
+	selected := make(map[file.ID]file.Reference)
+	for len(pending) > 0 {
+		ref := pending[0]
+		pending = pending[1:]
+		if _, ok := selected[ref.ID()]; ok {
+			continue
+		}
+		selected[ref.ID()] = ref
+
+		for _, parent := range ref.RealPath.ConstituentPaths() {
+			exists, parentRef, err := tree.File(parent)
+			if err != nil {
+				return nil, fmt.Errorf("unable to find parent=%q of path=%q: %w", parent, ref.RealPath, err)
+			}
+			// parents that were never explicitly added to the image (implied by a child path) have no reference, these
+			// are written as plain directories (see tarOrderedEntries)
+			if exists && parentRef != nil {
+				selected[parentRef.ID()] = *parentRef
+			}
+		}
+
+		entry, err := i.FileCatalog.Get(ref)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find catalog entry for ref=%+v: %w", ref, err)
+		}
+		if entry.Type() != file.TypeHardLink {
+			continue
+		}
+		// a hardlink whose target was removed or replaced in a higher layer is written as a regular file instead (see
+		// tarOrderedEntries), so only intact targets need to be selected
+		targetRef, err := i.intactHardlinkTarget(entry)
+		if err != nil {
+			return nil, err
+		}
+		if targetRef != nil {
+			pending = append(pending, *targetRef)
+		}
+	}
+
+	refs := make([]file.Reference, 0, len(selected))
+	for _, ref := range selected {
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+// writeSquashedTar writes all files from the squashed tree (with the metadata and contents from the file catalog) to
+// the given writer as a single tar, ordered by path so that parent directories precede their children.
+func (i *Image) writeSquashedTar(w io.Writer, cfg tarConfig) error {
+	return i.writeTar(w, i.SquashedTree().AllFiles(file.AllTypes...), cfg)
+}
+
+// writeTar writes the given files (with the metadata and contents from the file catalog) to the given writer as a
+// single tar, ordered by path so that parent directories precede their children. Hardlinks are written after all other
+// files (and after any hardlinks they refer to) since hardlink targets must precede the hardlink when extracting.
+func (i *Image) writeTar(w io.Writer, refs []file.Reference, cfg tarConfig) error {
+	entries, err := i.tarOrderedEntries(refs)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	for _, entry := range entries {
+		ref := entry.File
+		header := squashedTarHeader(ref, entry.Metadata)
+		if cfg.deterministic {
+			normalizeTarHeader(header)
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("unable to write tar header for path=%q: %w", ref.RealPath, err)
+		}
+
+		if header.Typeflag != tar.TypeReg || header.Size == 0 {
+			continue
+		}
+
+		if entry.Contents == nil {
+			return fmt.Errorf("%w: %+v", ErrContentNotCached, ref.RealPath)
+		}
+		reader := entry.Contents()
+		_, err = io.Copy(tw, reader)
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("unable to write contents for path=%q: %w", ref.RealPath, err)
+		}
+	}
+	return tw.Close()
+}
+
+// tarOrderedEntries returns the catalog entries for the given references in the order they should be written to a tar:
+// all entries that are not hardlinks sorted by path, followed by all hardlinks sorted by path, where hardlinks to other
+// hardlinks are moved after their target. Hardlinks whose target was removed or replaced in a higher layer are
+// returned as regular files with the contents of the original target.
+func (i *Image) tarOrderedEntries(refs []file.Reference) ([]FileCatalogEntry, error) {
+	var entries, links []FileCatalogEntry
+	for _, ref := range refs {
+		entry, err := i.FileCatalog.Get(ref)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find catalog entry for ref=%+v: %w", ref, err)
+		}
+		if entry.Type() != file.TypeHardLink {
+			entries = append(entries, entry)
+			continue
+		}
+
+		targetRef, err := i.intactHardlinkTarget(entry)
+		if err != nil {
+			return nil, err
+		}
+		if targetRef != nil {
+			links = append(links, entry)
+			continue
+		}
+
+		detached, ok, err := i.detachedHardlinkEntry(entry)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			log.Warnf("skipping hardlink=%q with no resolvable target=%q", ref.RealPath, entry.Metadata.Linkname)
+			continue
+		}
+		entries = append(entries, detached)
+	}
+
+	// parent directories that were never explicitly added to the image (implied by a child path) have no catalog entry,
+	// however, they must still be written so that their children can be extracted
+	present := make(map[file.Path]struct{})
+	for _, entry := range append(append([]FileCatalogEntry{}, entries...), links...) {
+		present[entry.File.RealPath] = struct{}{}
+	}
+	for _, entry := range append(append([]FileCatalogEntry{}, entries...), links...) {
+		for _, parent := range entry.File.RealPath.ConstituentPaths() {
+			if _, ok := present[parent]; ok || parent == file.DirSeparator {
+				continue
+			}
+			present[parent] = struct{}{}
+			entries = append(entries, impliedDirEntry(parent))
+		}
+	}
+
+	byPath := func(list []FileCatalogEntry) {
+		sort.Slice(list, func(a, b int) bool {
+			return list[a].File.RealPath < list[b].File.RealPath
+		})
+	}
+	byPath(entries)
+	byPath(links)
+
+	pending := make(map[file.Path]struct{})
+	for _, link := range links {
+		pending[link.File.RealPath] = struct{}{}
+	}
+
+	// emit hardlinks whose target is not itself a pending hardlink, until all have been emitted (any remaining hardlinks
+	// form a cycle and are emitted as-is)
+	for len(links) > 0 {
+		var remaining []FileCatalogEntry
+		for _, link := range links {
+			if _, ok := pending[hardlinkTarget(link.Metadata.Linkname)]; ok {
+				remaining = append(remaining, link)
+				continue
+			}
+			entries = append(entries, link)
+			delete(pending, link.File.RealPath)
+		}
+		if len(remaining) == len(links) {
+			entries = append(entries, remaining...)
+			break
+		}
+		links = remaining
+	}
+	return entries, nil
+}
+
+// intactHardlinkTarget returns the squash tree reference of the target of the given hardlink, if the target is still the
+// file that the hardlink referred to when it was added (within the layer of the hardlink). Nil is returned if the target
+// has since been removed or replaced by a higher layer.
+func (i *Image) intactHardlinkTarget(link FileCatalogEntry) (*file.Reference, error) {
+	target := hardlinkTarget(link.Metadata.Linkname)
+	exists, squashRef, err := i.SquashedTree().File(target)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find target=%q of hardlink=%q: %w", target, link.File.RealPath, err)
+	}
+	if !exists || squashRef == nil || link.Layer == nil || link.Layer.SquashedTree == nil {
+		return nil, nil
+	}
+
+	_, originalRef, err := link.Layer.SquashedTree.File(target)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find target=%q of hardlink=%q: %w", target, link.File.RealPath, err)
+	}
+	if originalRef == nil || originalRef.ID() != squashRef.ID() {
+		return nil, nil
+	}
+	return squashRef, nil
+}
+
+// detachedHardlinkEntry returns an entry for the given hardlink as a regular file, with the metadata and contents of the
+// file that the hardlink (transitively) referred to within its own layer. False is returned if that file cannot be
+// found.
+func (i *Image) detachedHardlinkEntry(link FileCatalogEntry) (FileCatalogEntry, bool, error) {
+	origin := link
+	visited := make(map[file.ID]struct{})
+	for origin.Type() == file.TypeHardLink {
+		if _, ok := visited[origin.File.ID()]; ok || origin.Layer == nil || origin.Layer.SquashedTree == nil {
+			// a hardlink cycle or a hardlink without layer information
+			return FileCatalogEntry{}, false, nil
+		}
+		visited[origin.File.ID()] = struct{}{}
+
+		_, ref, err := origin.Layer.SquashedTree.File(hardlinkTarget(origin.Metadata.Linkname))
+		if err != nil || ref == nil {
+			return FileCatalogEntry{}, false, nil
+		}
+		origin, err = i.FileCatalog.Get(*ref)
+		if err != nil {
+			return FileCatalogEntry{}, false, fmt.Errorf("unable to find catalog entry for ref=%+v: %w", *ref, err)
+		}
+	}
+	if origin.Type() != file.TypeReg {
+		return FileCatalogEntry{}, false, nil
+	}
+
+	metadata := origin.Metadata
+	metadata.Path = link.Metadata.Path
+	metadata.TarHeaderName = link.Metadata.TarHeaderName
+	metadata.TarSequence = link.Metadata.TarSequence
+	metadata.Linkname = ""
+	return FileCatalogEntry{
+		File:     link.File,
+		Metadata: metadata,
+		Layer:    origin.Layer,
+		Contents: origin.Contents,
+	}, true, nil
+}
+
+// impliedDirEntry returns a catalog entry for a directory that has no tar entry of its own (with default permissions).
+func impliedDirEntry(p file.Path) FileCatalogEntry {
+	return FileCatalogEntry{
+		File: file.Reference{RealPath: p},
+		Metadata: file.Metadata{
+			Path:     string(p),
+			TypeFlag: tar.TypeDir,
+			IsDir:    true,
+			Mode:     os.ModeDir | 0755,
+		},
+	}
+}
+
+// squashedTarHeader creates a tar header for the given file reference from the cataloged file metadata.
+func squashedTarHeader(ref file.Reference, m file.Metadata) *tar.Header {
+	header := &tar.Header{
+		Name:     strings.TrimPrefix(string(ref.RealPath), file.DirSeparator),
+		Typeflag: byte(file.TypeFromTarType(m.TypeFlag)),
+		Linkname: m.Linkname,
+		Mode:     int64(m.Mode.Perm()),
+		Uid:      m.UserID,
+		Gid:      m.GroupID,
+		Devmajor: m.DevMajor,
+		Devminor: m.DevMinor,
+		ModTime:  m.ModTime,
+		Format:   tar.FormatPAX,
+	}
+
+	if m.Mode&os.ModeSetuid != 0 {
+		header.Mode |= 04000
+	}
+	if m.Mode&os.ModeSetgid != 0 {
+		header.Mode |= 02000
+	}
+	if m.Mode&os.ModeSticky != 0 {
+		header.Mode |= 01000
+	}
+
+	switch header.Typeflag {
+	case tar.TypeDir:
+		header.Name += file.DirSeparator
+	case tar.TypeReg:
+		header.Size = m.Size
+	case tar.TypeLink:
+		// hardlink destinations are relative to the archive root
+		header.Linkname = strings.TrimPrefix(m.Linkname, file.DirSeparator)
+	}
+
+	return header
+}
+
+// normalizeTarHeader sets all fields of the given header that are not part of the filesystem contents (timestamps and
+// ownership) to fixed values.
+func normalizeTarHeader(header *tar.Header) {
+	header.ModTime = deterministicTarTime
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+	header.Uid = 0
+	header.Gid = 0
+	header.Uname = ""
+	header.Gname = ""
+	header.PAXRecords = nil
+}
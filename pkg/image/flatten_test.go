@@ -0,0 +1,427 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImage_WriteFlattenedImage(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest:   "sha256:a",
+			paths:    []string{"/etc/hosts", "/bin/busybox"},
+			contents: map[string]string{"/etc/hosts": "localhost", "/bin/busybox": "elf"},
+			modes:    map[string]os.FileMode{"/bin/busybox": 0755 | os.ModeSetuid},
+			links:    map[string]string{"/bin/sh": "busybox"},
+		},
+		testLayer{
+			digest:   "sha256:b",
+			paths:    []string{"/etc/hosts"},
+			contents: map[string]string{"/etc/hosts": "other"},
+		},
+	)
+	img.Metadata.Config = v1.ConfigFile{
+		Architecture: "amd64",
+		OS:           "linux",
+		Config: v1.Config{
+			Env:        []string{"PATH=/bin"},
+			Entrypoint: []string{"/bin/sh"},
+			Cmd:        []string{"-c", "echo hello"},
+		},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, img.WriteFlattenedImage(&buf, []string{"example/flattened:latest"}))
+
+	tag, err := name.NewTag("example/flattened:latest")
+	require.NoError(t, err)
+
+	flattened, err := tarball.Image(func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	}, &tag)
+	require.NoError(t, err)
+
+	config, err := flattened.ConfigFile()
+	require.NoError(t, err)
+	assert.Equal(t, "amd64", config.Architecture)
+	assert.Equal(t, "linux", config.OS)
+	assert.Equal(t, img.Metadata.Config.Config.Env, config.Config.Env)
+	assert.Equal(t, img.Metadata.Config.Config.Entrypoint, config.Config.Entrypoint)
+	assert.Equal(t, img.Metadata.Config.Config.Cmd, config.Config.Cmd)
+	assert.Len(t, config.RootFS.DiffIDs, 1)
+
+	layers, err := flattened.Layers()
+	require.NoError(t, err)
+	require.Len(t, layers, 1)
+
+	reader, err := layers[0].Uncompressed()
+	require.NoError(t, err)
+	defer reader.Close()
+
+	headers := make(map[string]*tar.Header)
+	contents := make(map[string]string)
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		headers[header.Name] = header
+		b, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		contents[header.Name] = string(b)
+	}
+
+	assert.Equal(t, map[string]string{
+		"bin/":        "",
+		"bin/busybox": "elf",
+		"bin/sh":      "",
+		"etc/":        "",
+		"etc/hosts":   "other",
+	}, contents)
+
+	// parent directories without a tar entry of their own are written as plain directories
+	assert.Equal(t, byte(tar.TypeDir), headers["etc/"].Typeflag)
+	assert.Equal(t, int64(0755), headers["etc/"].Mode)
+
+	assert.Equal(t, byte(tar.TypeSymlink), headers["bin/sh"].Typeflag)
+	assert.Equal(t, "busybox", headers["bin/sh"].Linkname)
+	assert.Equal(t, int64(04755), headers["bin/busybox"].Mode)
+}
+
+func TestImage_OpenFilteredTar(t *testing.T) {
+	layer := newLayerFromHeaders(t, []*tar.Header{
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/hosts", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "etc/ssl/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "etc/ssl/ca.pem", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "bin/busybox", Typeflag: tar.TypeReg, Mode: 0755},
+		{Name: "bin/ca.pem", Typeflag: tar.TypeLink, Linkname: "etc/ssl/ca.pem"},
+		{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "usr/lib/libc.so", Typeflag: tar.TypeReg, Mode: 0755},
+	}, map[string]string{
+		"etc/hosts":       "localhost",
+		"etc/ssl/ca.pem":  "cert",
+		"bin/busybox":     "elf",
+		"usr/lib/libc.so": "elf",
+	})
+	v1Image, err := mutate.AppendLayers(empty.Image, layer)
+	require.NoError(t, err)
+
+	img := NewImage(v1Image, t.TempDir())
+	require.NoError(t, img.Read())
+
+	tests := []struct {
+		name     string
+		filter   func(file.Reference) bool
+		expected []string
+		contents map[string]string
+	}{
+		{
+			name: "files under a directory",
+			filter: func(ref file.Reference) bool {
+				return strings.HasPrefix(string(ref.RealPath), "/etc/ssl/")
+			},
+			// the parent directories are included even though they do not pass the filter
+			expected: []string{"etc/", "etc/ssl/", "etc/ssl/ca.pem"},
+			contents: map[string]string{"etc/ssl/ca.pem": "cert"},
+		},
+		{
+			name: "files with implied parents",
+			filter: func(ref file.Reference) bool {
+				return ref.RealPath == "/usr/lib/libc.so" || ref.RealPath == "/bin/busybox"
+			},
+			// "/usr/lib" is never explicitly added to the image, however, it is still written as a directory
+			expected: []string{"bin/", "bin/busybox", "usr/", "usr/lib/", "usr/lib/libc.so"},
+			contents: map[string]string{"bin/busybox": "elf", "usr/lib/libc.so": "elf"},
+		},
+		{
+			name: "hardlink targets",
+			filter: func(ref file.Reference) bool {
+				return ref.RealPath == "/bin/ca.pem"
+			},
+			// the hardlink target (and its parents) are included even though they do not pass the filter
+			expected: []string{"bin/", "etc/", "etc/ssl/", "etc/ssl/ca.pem", "bin/ca.pem"},
+			contents: map[string]string{"etc/ssl/ca.pem": "cert"},
+		},
+		{
+			name: "nothing",
+			filter: func(file.Reference) bool {
+				return false
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader, err := img.OpenFilteredTar(test.filter)
+			require.NoError(t, err)
+			defer reader.Close()
+
+			var names []string
+			contents := make(map[string]string)
+			tr := tar.NewReader(reader)
+			for {
+				header, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				names = append(names, header.Name)
+				if header.Typeflag == tar.TypeReg {
+					b, err := ioutil.ReadAll(tr)
+					require.NoError(t, err)
+					contents[header.Name] = string(b)
+				}
+			}
+
+			assert.Equal(t, test.expected, names)
+			if test.contents == nil {
+				test.contents = map[string]string{}
+			}
+			assert.Equal(t, test.contents, contents)
+		})
+	}
+}
+
+func TestImage_WithDeterministicTar(t *testing.T) {
+	// newImage creates an image with the same filesystem contents but with the given ownership of all files
+	newImage := func(t *testing.T, uid int) *Image {
+		buf := &bytes.Buffer{}
+		writer := tar.NewWriter(buf)
+		for _, header := range []*tar.Header{
+			{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "etc/hosts", Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len("localhost"))},
+			{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0755},
+			{Name: "bin/sh", Typeflag: tar.TypeSymlink, Linkname: "busybox"},
+		} {
+			header.Uid = uid
+			header.Gid = uid
+			header.ModTime = time.Unix(int64(uid), 0)
+			require.NoError(t, writer.WriteHeader(header))
+			if header.Size > 0 {
+				_, err := writer.Write([]byte("localhost"))
+				require.NoError(t, err)
+			}
+		}
+		require.NoError(t, writer.Close())
+
+		layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+		})
+		require.NoError(t, err)
+		v1Image, err := mutate.AppendLayers(empty.Image, layer)
+		require.NoError(t, err)
+
+		img := NewImage(v1Image, t.TempDir())
+		require.NoError(t, img.Read())
+		return img
+	}
+
+	all := func(file.Reference) bool {
+		return true
+	}
+
+	exportTar := func(t *testing.T, img *Image, options ...TarOption) []byte {
+		reader, err := img.OpenFilteredTar(all, options...)
+		require.NoError(t, err)
+		defer reader.Close()
+		b, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		return b
+	}
+
+	exportImage := func(t *testing.T, img *Image, options ...TarOption) []byte {
+		var buf bytes.Buffer
+		require.NoError(t, img.WriteFlattenedImage(&buf, []string{"example/flattened:latest"}, options...))
+		return buf.Bytes()
+	}
+
+	first, second := newImage(t, 1000), newImage(t, 2000)
+
+	assert.NotEqual(t, exportTar(t, first), exportTar(t, second))
+	assert.Equal(t, exportTar(t, first, WithDeterministicTar()), exportTar(t, second, WithDeterministicTar()))
+	assert.NotEqual(t, exportImage(t, first), exportImage(t, second))
+	assert.Equal(t, exportImage(t, first, WithDeterministicTar()), exportImage(t, second, WithDeterministicTar()))
+
+	tr := tar.NewReader(bytes.NewReader(exportTar(t, first, WithDeterministicTar())))
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+		assert.Equal(t, 0, header.Uid)
+		assert.Equal(t, 0, header.Gid)
+		assert.True(t, header.ModTime.Equal(time.Unix(0, 0)))
+	}
+	assert.Equal(t, []string{"bin/", "bin/sh", "etc/", "etc/hosts"}, names)
+}
+
+func TestImage_OpenFilteredTar_HardlinkOrderAndModTime(t *testing.T) {
+	modTime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	headers := []*tar.Header{
+		{Name: "z/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "z/target", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "b/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "b/link", Typeflag: tar.TypeLink, Linkname: "z/target"},
+		// a hardlink to a hardlink must follow the hardlink it refers to
+		{Name: "a/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "a/link", Typeflag: tar.TypeLink, Linkname: "b/link"},
+	}
+	for _, header := range headers {
+		header.ModTime = modTime
+	}
+	layer := newLayerFromHeaders(t, headers, map[string]string{"z/target": "contents"})
+	v1Image, err := mutate.AppendLayers(empty.Image, layer)
+	require.NoError(t, err)
+
+	img := NewImage(v1Image, t.TempDir())
+	require.NoError(t, img.Read())
+
+	reader, err := img.OpenFilteredTar(func(file.Reference) bool { return true })
+	require.NoError(t, err)
+	defer reader.Close()
+
+	var names []string
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		names = append(names, header.Name)
+		assert.True(t, header.ModTime.Equal(modTime), "unexpected mtime for %q: %s", header.Name, header.ModTime)
+	}
+	assert.Equal(t, []string{"a/", "b/", "z/", "z/target", "b/link", "a/link"}, names)
+}
+
+func TestImage_SquashedTar_HardlinkTargetChanged(t *testing.T) {
+	lower := newLayerFromHeaders(t, []*tar.Header{
+		{Name: "a", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "b", Typeflag: tar.TypeLink, Linkname: "a"},
+	}, map[string]string{"a": "original"})
+
+	tests := []struct {
+		name             string
+		upper            []*tar.Header
+		upperContents    map[string]string
+		expectedSquash   map[string]string
+		expectedFiltered map[string]string
+	}{
+		{
+			name:             "target unchanged",
+			upper:            []*tar.Header{{Name: "c", Typeflag: tar.TypeReg, Mode: 0644}},
+			upperContents:    map[string]string{"c": "other"},
+			expectedSquash:   map[string]string{"a": "file:original", "b": "link:a", "c": "file:other"},
+			expectedFiltered: map[string]string{"a": "file:original", "b": "link:a"},
+		},
+		{
+			name:             "target removed",
+			upper:            []*tar.Header{{Name: ".wh.a", Typeflag: tar.TypeReg, Mode: 0644}},
+			expectedSquash:   map[string]string{"b": "file:original"},
+			expectedFiltered: map[string]string{"b": "file:original"},
+		},
+		{
+			name:             "target replaced",
+			upper:            []*tar.Header{{Name: "a", Typeflag: tar.TypeReg, Mode: 0644}},
+			upperContents:    map[string]string{"a": "replaced"},
+			expectedSquash:   map[string]string{"a": "file:replaced", "b": "file:original"},
+			expectedFiltered: map[string]string{"b": "file:original"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v1Image, err := mutate.AppendLayers(empty.Image, lower, newLayerFromHeaders(t, test.upper, test.upperContents))
+			require.NoError(t, err)
+
+			img := NewImage(v1Image, t.TempDir())
+			require.NoError(t, img.Read())
+
+			buf := &bytes.Buffer{}
+			require.NoError(t, img.writeSquashedTar(buf, newTarConfig()))
+			assert.Equal(t, test.expectedSquash, readTarEntries(t, buf))
+
+			reader, err := img.OpenFilteredTar(func(ref file.Reference) bool {
+				return ref.RealPath == "/b"
+			})
+			require.NoError(t, err)
+			defer reader.Close()
+			assert.Equal(t, test.expectedFiltered, readTarEntries(t, reader))
+		})
+	}
+}
+
+// readTarEntries returns a description of each entry in the given tar by name ("file:<contents>", "link:<target>", or
+// the type flag of any other entry), failing if a hardlink precedes its target.
+func readTarEntries(t *testing.T, reader io.Reader) map[string]string {
+	t.Helper()
+	entries := make(map[string]string)
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		switch header.Typeflag {
+		case tar.TypeReg:
+			contents, err := ioutil.ReadAll(tr)
+			require.NoError(t, err)
+			entries[header.Name] = "file:" + string(contents)
+		case tar.TypeLink:
+			require.Contains(t, entries, header.Linkname, "hardlink=%q precedes its target", header.Name)
+			entries[header.Name] = "link:" + header.Linkname
+		default:
+			entries[header.Name] = string(header.Typeflag)
+		}
+	}
+	return entries
+}
+
+// newLayerFromHeaders creates a layer with the given tar entries (in the given order), where the contents of regular
+// files are given by entry name.
+func newLayerFromHeaders(t *testing.T, headers []*tar.Header, contents map[string]string) v1.Layer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	writer := tar.NewWriter(buf)
+	for _, header := range headers {
+		if header.Typeflag == tar.TypeReg {
+			header.Size = int64(len(contents[header.Name]))
+		}
+		require.NoError(t, writer.WriteHeader(header))
+		if header.Size > 0 {
+			_, err := writer.Write([]byte(contents[header.Name]))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, writer.Close())
+
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	require.NoError(t, err)
+	return layer
+}
@@ -2,20 +2,83 @@ package image
 
 import (
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
 
+	"github.com/anchore/stereoscope/internal"
 	"github.com/anchore/stereoscope/internal/bus"
 	"github.com/anchore/stereoscope/internal/log"
 	"github.com/anchore/stereoscope/pkg/event"
 	"github.com/anchore/stereoscope/pkg/file"
 	"github.com/anchore/stereoscope/pkg/filetree"
+	"github.com/anchore/stereoscope/pkg/filetree/filenode"
+	"github.com/bmatcuk/doublestar/v4"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/hashicorp/go-multierror"
 	"github.com/wagoodman/go-partybus"
 	"github.com/wagoodman/go-progress"
 )
 
+// privilegedModeBits are the file mode bits that grant elevated privileges or restrict deletion when set.
+const privilegedModeBits = os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// PrivilegedFile is a file reference with setuid, setgid, and/or sticky mode bits set.
+type PrivilegedFile struct {
+	Reference file.Reference
+	// Mode contains only the privileged mode bits that are set (os.ModeSetuid, os.ModeSetgid, os.ModeSticky)
+	Mode os.FileMode
+}
+
+// IsSetuid indicates if the setuid bit is set.
+func (p PrivilegedFile) IsSetuid() bool {
+	return p.Mode&os.ModeSetuid != 0
+}
+
+// IsSetgid indicates if the setgid bit is set.
+func (p PrivilegedFile) IsSetgid() bool {
+	return p.Mode&os.ModeSetgid != 0
+}
+
+// IsSticky indicates if the sticky bit is set.
+func (p PrivilegedFile) IsSticky() bool {
+	return p.Mode&os.ModeSticky != 0
+}
+
+// SymlinkEntry is a symlink found in the image along with its raw (unresolved) link destination.
+type SymlinkEntry struct {
+	Reference file.Reference
+	// Linkname is the literal link destination as stored in the tar header (may be absolute or relative)
+	Linkname string
+}
+
+// DefaultMaxReadFileSize is the largest file (in bytes) that Image.ReadFile will read unless configured otherwise with
+// WithMaxReadFileSize.
+const DefaultMaxReadFileSize = 100 * file.MB
+
+// DefaultDecompressionProgressThreshold is the smallest (compressed) layer size in bytes for which decompression
+// progress is reported unless configured otherwise with WithDecompressionProgressThreshold.
+const DefaultDecompressionProgressThreshold = 100 * file.MB
+
+// ErrFileTooLarge is returned when reading a file that exceeds the maximum read size.
+var ErrFileTooLarge = fmt.Errorf("file exceeds the maximum read size")
+
+// ErrContentUnavailable is returned when the contents of a file cannot be fetched.
+var ErrContentUnavailable = fmt.Errorf("file contents are not available")
+
+// ErrLayerOutOfRange is returned when a layer index does not refer to a layer within the image.
+var ErrLayerOutOfRange = fmt.Errorf("layer index out of range")
+
+// ErrLayerNotFound is returned when a layer digest does not refer to a layer within the image.
+var ErrLayerNotFound = fmt.Errorf("layer not found in image")
+
 // Image represents a container image.
 type Image struct {
 	// image is the raw image metadata and content provider from the GCR lib
@@ -30,6 +93,43 @@ type Image struct {
 	FileCatalog FileCatalog
 
 	overrideMetadata []AdditionalMetadata
+	// maxLayers is the maximum number of layers the image may declare before reading is aborted (0 means unlimited)
+	maxLayers int
+	// memoryThreshold is the max size in bytes of file contents to keep in memory instead of reading from the layer tar cache
+	memoryThreshold int64
+	// headerTransform optionally rewrites or filters tar headers as each layer is read
+	headerTransform HeaderTransform
+	// maxReadFileSize is the largest file (in bytes) that ReadFile will read (0 means DefaultMaxReadFileSize)
+	maxReadFileSize int64
+	// ignorePaths are glob patterns for paths that are excluded from the file catalog and all trees
+	ignorePaths []string
+	// decompressionProgressThreshold is the smallest compressed layer size to report decompression progress for (0 means
+	// DefaultDecompressionProgressThreshold, less than 0 means never)
+	decompressionProgressThreshold int64
+	// pathInterner optionally deduplicates path strings across all trees and the file catalog
+	pathInterner *file.PathInterner
+	// foreignLayerPolicy describes how to handle foreign (non-distributable) layers
+	foreignLayerPolicy ForeignLayerPolicy
+	// retainCompressedLayers indicates if the original layer blobs should be kept in the cache alongside the layer tars
+	retainCompressedLayers bool
+	// strictHardlinks indicates that hardlinks to missing targets fail the read instead of being skipped
+	strictHardlinks bool
+	// precomputeDigests indicates that regular file content digests are computed while the layers are read
+	precomputeDigests bool
+	// memoryMappedCache indicates that the layer tar caches are memory mapped for reading file contents
+	memoryMappedCache bool
+	// whiteoutDiagnostics indicates that opaque directory whiteouts are recorded instead of applied when squashing
+	whiteoutDiagnostics bool
+	// materializeSymlinks indicates that symlinked directories are replaced with copies of their targets in squash trees
+	materializeSymlinks bool
+	// chunkLocator optionally locates the chunks of layer blobs that are split across several pieces in storage
+	chunkLocator ChunkLocator
+	// warnings are the non-fatal anomalies found while reading the image
+	warnings []Warning
+	// timings records the time spent in each phase of reading the image (only set when timings are enabled)
+	timings *Timings
+	// referrersFetcher resolves artifacts that refer to this image (only available for registry-backed images)
+	referrersFetcher ReferrersFetcher
 }
 
 type AdditionalMetadata func(*Image) error
@@ -66,12 +166,171 @@ func WithManifestDigest(digest string) AdditionalMetadata {
 
 func WithConfig(config []byte) AdditionalMetadata {
 	return func(image *Image) error {
+		config, _, err := canonicalConfig(config)
+		if err != nil {
+			return err
+		}
 		image.Metadata.RawConfig = config
 		image.Metadata.ID = fmt.Sprintf("sha256:%x", sha256.Sum256(config))
 		return nil
 	}
 }
 
+// WithMaxLayers limits the number of layers that an image may declare. If the image config declares more layers than
+// the given maximum then Read() will fail before any layer content is processed. A value of 0 or less means unlimited.
+func WithMaxLayers(n int) AdditionalMetadata {
+	return func(image *Image) error {
+		image.maxLayers = n
+		return nil
+	}
+}
+
+// WithMemoryThreshold keeps the contents of regular files at or below the given size (in bytes) in memory, while larger
+// files are read from the layer tar cache on disk on demand. This reduces file handle and seek overhead for images with
+// many small files at the cost of memory. A value of 0 or less disables in-memory content (the default).
+func WithMemoryThreshold(bytes int64) AdditionalMetadata {
+	return func(image *Image) error {
+		image.memoryThreshold = bytes
+		return nil
+	}
+}
+
+// WithMaxReadFileSize sets the largest file (in bytes) that Image.ReadFile will read into memory. A value of 0 or less
+// uses DefaultMaxReadFileSize.
+func WithMaxReadFileSize(bytes int64) AdditionalMetadata {
+	return func(image *Image) error {
+		image.maxReadFileSize = bytes
+		return nil
+	}
+}
+
+// WithHeaderTransform sets a function that is invoked with each tar header as the layers are read. The returned header
+// is used when cataloging the entry and adding it to the layer tree (e.g. to strip a path prefix or remap ownership),
+// and returning false skips the entry entirely. The contents of each entry are still read from the original location
+// in the layer tar.
+func WithHeaderTransform(transform HeaderTransform) AdditionalMetadata {
+	return func(image *Image) error {
+		image.headerTransform = transform
+		return nil
+	}
+}
+
+// WithDecompressionProgressThreshold sets the smallest (compressed) layer size in bytes for which byte-level
+// decompression progress is published (as an event.DecompressLayer event) while the layer tar cache is populated. A
+// value of 0 uses DefaultDecompressionProgressThreshold, while a negative value disables decompression progress.
+func WithDecompressionProgressThreshold(bytes int64) AdditionalMetadata {
+	return func(image *Image) error {
+		image.decompressionProgressThreshold = bytes
+		return nil
+	}
+}
+
+// WithIgnorePaths excludes all paths matching any of the given glob patterns (see doublestar.Match) from the file
+// catalog and all layer and squash trees. A pattern matching a directory excludes everything beneath it as well (e.g.
+// "/proc" excludes "/proc/1/status"). Whiteout entries are matched by the path that they remove, so whiteouts for
+// excluded paths are excluded as well while files that survive the filter are still correctly removed by upper layers.
+func WithIgnorePaths(patterns ...string) AdditionalMetadata {
+	return func(image *Image) error {
+		for _, pattern := range patterns {
+			if !strings.HasPrefix(pattern, file.DirSeparator) {
+				pattern = file.DirSeparator + pattern
+			}
+			if !doublestar.ValidatePattern(pattern) {
+				return fmt.Errorf("invalid ignore path pattern=%q", pattern)
+			}
+			image.ignorePaths = append(image.ignorePaths, pattern)
+		}
+		return nil
+	}
+}
+
+// WithInternedPaths deduplicates the path strings stored across all layer trees, squash trees, and the file catalog, so
+// that a path found in many layers (or in every squash tree) is only stored once in memory. This reduces memory usage
+// for images with many files (e.g. large node_modules trees) at the cost of a lookup for each path while reading.
+// Paths returned to callers are still plain file.Path values.
+func WithInternedPaths() AdditionalMetadata {
+	return func(image *Image) error {
+		image.pathInterner = file.NewPathInterner()
+		return nil
+	}
+}
+
+// WithForeignLayerPolicy sets how foreign (non-distributable) layers are handled. By default foreign layers are read
+// like any other layer, which may require fetching them from external URLs (outside of the image source) when the
+// image source does not have the layer content locally. Use RejectForeignLayers to prevent this.
+func WithForeignLayerPolicy(policy ForeignLayerPolicy) AdditionalMetadata {
+	return func(image *Image) error {
+		image.foreignLayerPolicy = policy
+		return nil
+	}
+}
+
+// WithRetainCompressedLayers keeps a copy of each original (potentially compressed) layer blob in the cache directory
+// alongside the uncompressed layer tar, so that it is available via Layer.CompressedContent for re-export without
+// going back to the image source. This roughly doubles the disk space used by the cache.
+func WithRetainCompressedLayers() AdditionalMetadata {
+	return func(image *Image) error {
+		image.retainCompressedLayers = true
+		return nil
+	}
+}
+
+// WithStrictHardlinks fails reading the image with an ErrBrokenHardlink when any hardlink refers to a target that does
+// not precede it within the same layer tar. By default such hardlinks are skipped with a BrokenHardlinkWarning (see
+// Image.Warnings), since they only occur in malformed layer tars and have no content to offer.
+func WithStrictHardlinks() AdditionalMetadata {
+	return func(image *Image) error {
+		image.strictHardlinks = true
+		return nil
+	}
+}
+
+// WithMemoryMappedCache memory maps (read-only) each uncompressed layer tar cache once the layer has been read, so that
+// file contents are copied directly out of the mapping instead of opening and seeking a file handle for every read. This
+// suits long-lived processes that read the contents of the same image many times. The mappings are safe for concurrent
+// reads and are unmapped by Cleanup or ReleaseContent. On platforms without memory mapped file support the regular
+// file-based cache is used.
+func WithMemoryMappedCache() AdditionalMetadata {
+	return func(image *Image) error {
+		image.memoryMappedCache = true
+		return nil
+	}
+}
+
+// WithPrecomputeDigests computes the sha256 digest of every regular file while the layer tars are indexed (see
+// file.Metadata.Digest), instead of lazily re-reading the contents of each file when a digest is needed. This is
+// cheaper when digests for most files will be needed, but costs CPU time for every file on large images, so it is
+// opt-in.
+func WithPrecomputeDigests() AdditionalMetadata {
+	return func(image *Image) error {
+		image.precomputeDigests = true
+		return nil
+	}
+}
+
+// WithMaterializeSymlinks replaces every symlink to a directory within the squash trees with a copy of the target
+// directory (see filetree.FileTree.MaterializeSymlinks), which helps consumers that cannot follow links themselves.
+// Symlink cycles are left as symlinks. Layer trees are not affected. Materialized paths share the file references (and
+// contents) of their targets, however the squash trees may become much larger (e.g. on images where "/lib" links to
+// "/usr/lib"), so this should only be used when needed.
+func WithMaterializeSymlinks() AdditionalMetadata {
+	return func(image *Image) error {
+		image.materializeSymlinks = true
+		return nil
+	}
+}
+
+// WithWhiteoutDiagnostics is a diagnostic mode for answering "why did my file disappear": opaque directory whiteouts
+// are not applied when squashing layers, so the squash trees retain the directory contents from lower layers. The paths
+// that would have been removed are available via Image.WhiteoutAffectedPaths. Since the resulting squash trees do not
+// reflect the real container filesystem, this should not be used for anything other than debugging.
+func WithWhiteoutDiagnostics() AdditionalMetadata {
+	return func(image *Image) error {
+		image.whiteoutDiagnostics = true
+		return nil
+	}
+}
+
 func WithRepoDigests(digests []string) AdditionalMetadata {
 	return func(image *Image) error {
 		if digests != nil {
@@ -118,6 +377,12 @@ func (i *Image) trackReadProgress(metadata Metadata) *progress.Manual {
 	return prog
 }
 
+// AddMetadata adds the given options to those the image was created with (e.g. by a Provider). All options are applied
+// in order when the image is read, so the options added here take precedence over the existing ones.
+func (i *Image) AddMetadata(additionalMetadata ...AdditionalMetadata) {
+	i.overrideMetadata = append(i.overrideMetadata, additionalMetadata...)
+}
+
 func (i *Image) applyOverrideMetadata() error {
 	for _, optionFn := range i.overrideMetadata {
 		if err := optionFn(i); err != nil {
@@ -127,9 +392,213 @@ func (i *Image) applyOverrideMetadata() error {
 	return nil
 }
 
+// RawConfig returns the exact bytes of the image config (as read from the image source or given with WithConfig), which
+// are decompressed if the config blob is compressed. The config is always available after the image has been read.
+func (i *Image) RawConfig() []byte {
+	return i.Metadata.RawConfig
+}
+
+// RawManifest returns the exact bytes of the image manifest (as read from the image source or given with WithManifest).
+// Note that for image sources without a registry manifest (e.g. docker archives) this is a manifest generated for the
+// image, which describes the same config and layers. The manifest is always available after the image has been read.
+func (i *Image) RawManifest() []byte {
+	return i.Metadata.RawManifest
+}
+
+// Label returns the value of the image config label with the given key, and whether the label exists.
+func (i *Image) Label(key string) (string, bool) {
+	value, ok := i.Metadata.Labels[key]
+	return value, ok
+}
+
+// Volumes returns the volume mount points declared in the image config (sorted). These are locations where data
+// may be written outside of the image layers at runtime. An empty slice is returned when no volumes are declared.
+func (i *Image) Volumes() []string {
+	if i.Metadata.Volumes == nil {
+		return []string{}
+	}
+	return i.Metadata.Volumes
+}
+
+// RuntimeConfig returns the exposed ports, healthcheck, stop signal, shell, and ONBUILD triggers declared in the image
+// config. Zero values are returned for anything that is not declared (the shell and ONBUILD triggers are empty, non-nil
+// slices).
+func (i *Image) RuntimeConfig() RuntimeConfig {
+	config := i.Metadata.Config.Config
+
+	var ports []string
+	for port := range config.ExposedPorts {
+		ports = append(ports, port)
+	}
+	sort.Strings(ports)
+
+	var healthcheck *v1.HealthConfig
+	if config.Healthcheck != nil {
+		hc := *config.Healthcheck
+		hc.Test = append([]string(nil), config.Healthcheck.Test...)
+		healthcheck = &hc
+	}
+
+	return RuntimeConfig{
+		ExposedPorts: ports,
+		Healthcheck:  healthcheck,
+		StopSignal:   config.StopSignal,
+		Shell:        append([]string{}, config.Shell...),
+		OnBuild:      append([]string{}, config.OnBuild...),
+	}
+}
+
+// CompressedSize returns the sum in bytes of all compressed layer blob sizes (the "on-the-wire" size of the image,
+// not including config / manifest / index metadata sizes). For the uncompressed size see Metadata.Size.
+func (i *Image) CompressedSize() int64 {
+	var size int64
+	for _, layer := range i.Layers {
+		size += layer.Metadata.CompressedSize
+	}
+	return size
+}
+
+// LayerCount returns the number of layers in the image.
+func (i *Image) LayerCount() int {
+	return len(i.Layers)
+}
+
+// IsScratch indicates if the image is built directly on "scratch" rather than on top of a base image (e.g. a static
+// binary or a single layer distroless image). This is determined from the image history: the image is considered to be
+// a scratch image when at most one history entry created a (non-empty) layer, since any base image contributes a
+// layer of its own. When there is no history (or the history does not describe the layers of the image) then images
+// with at most one layer are considered to be scratch images. Note that an image built on scratch with several layers
+// (e.g. from several COPY instructions) cannot be told apart from an image with a base image, so it is not considered
+// to be a scratch image.
+func (i *Image) IsScratch() bool {
+	history := i.Metadata.Config.History
+	nonEmpty := nonEmptyHistoryCount(history)
+	if len(history) == 0 || nonEmpty != i.LayerCount() {
+		return i.LayerCount() <= 1
+	}
+	return nonEmpty <= 1
+}
+
+// nonEmptyHistoryCount returns the number of history entries that created a layer.
+func nonEmptyHistoryCount(history []v1.History) int {
+	var count int
+	for _, h := range history {
+		if !h.EmptyLayer {
+			count++
+		}
+	}
+	return count
+}
+
+// checkLayerLimit ensures that the number of layers declared by the image config does not exceed the configured
+// maximum (if any). This is checked before any layer content is read or squashed.
+func (i *Image) checkLayerLimit() error {
+	declared := len(i.Metadata.Config.RootFS.DiffIDs)
+	if i.maxLayers > 0 && declared > i.maxLayers {
+		return fmt.Errorf("image declares %d layers which exceeds the maximum allowed layer count of %d", declared, i.maxLayers)
+	}
+	return nil
+}
+
+// checkHistory records a warning if the image history (when present) does not describe the same number of layers as
+// the image declares.
+func (i *Image) checkHistory() {
+	history := i.Metadata.Config.History
+	if len(history) == 0 {
+		return
+	}
+
+	nonEmpty := nonEmptyHistoryCount(history)
+	if declared := len(i.Metadata.Config.RootFS.DiffIDs); nonEmpty != declared {
+		i.warn(HistoryMismatchWarning, "history describes %d layer(s), however, the image declares %d layer(s)", nonEmpty, declared)
+	}
+}
+
+// FileCount returns the number of files cataloged across all layers (every layer tar entry of any type, including
+// directories, links, and whiteouts, and including files that are overwritten or deleted in upper layers).
+func (i *Image) FileCount() int {
+	return i.FileCatalog.size()
+}
+
+// DistinctContentCount returns the number of distinct regular file contents cataloged across all layers (including
+// files that are overwritten or deleted in upper layers), by content digest. Whiteout markers are not counted.
+// Comparing this to the number of regular files gives a quick estimate of duplicated content. Digests that were not
+// computed while reading the image (see WithPrecomputeDigests) are computed from the file contents on demand.
+func (i *Image) DistinctContentCount() (int, error) {
+	return i.FileCatalog.distinctContentCount()
+}
+
+// UniqueContentSize returns the sum in bytes of all distinct regular file contents within the image squash tree. Files
+// with identical contents (by digest) are only counted once and hardlinks are not counted at all, so this is the
+// size of the data actually present in the squashed filesystem. This is in contrast to Metadata.Size which is the
+// naive sum of all layer contents (including files that are overwritten or deleted in upper layers).
+func (i *Image) UniqueContentSize() (int64, error) {
+	var size int64
+	seen := internal.NewStringSet()
+	for _, ref := range i.SquashedTree().AllFiles(file.TypeReg) {
+		entry, err := i.FileCatalog.Get(ref)
+		if err != nil {
+			return 0, fmt.Errorf("unable to find catalog entry for ref=%+v: %w", ref, err)
+		}
+
+		digest, err := fetchFileDigest(&i.FileCatalog, ref)
+		if err != nil {
+			return 0, err
+		}
+
+		if seen.Contains(digest) {
+			continue
+		}
+		seen.Add(digest)
+		size += entry.Metadata.Size
+	}
+	return size, nil
+}
+
+// SquashDigest returns a reproducible sha256 digest representing the entire squashed filesystem: all paths in the
+// squash tree along with their type, mode, ownership, size, link destination, device numbers, and content digest. The
+// layer structure is not considered, so two images with identical filesystems but different layer splits have the
+// same squash digest.
+func (i *Image) SquashDigest() (string, error) {
+	refs := i.SquashedTree().AllFiles(file.AllTypes...)
+	sort.Slice(refs, func(a, b int) bool {
+		return refs[a].RealPath < refs[b].RealPath
+	})
+
+	hasher := sha256.New()
+	for _, ref := range refs {
+		entry, err := i.FileCatalog.Get(ref)
+		if err != nil {
+			return "", fmt.Errorf("unable to find catalog entry for ref=%+v: %w", ref, err)
+		}
+		m := entry.Metadata
+
+		fileType := entry.Type()
+
+		var contentDigest string
+		if fileType == file.TypeReg {
+			contentDigest, err = fetchFileDigest(&i.FileCatalog, ref)
+			if err != nil {
+				return "", err
+			}
+		}
+
+		// note: %q is used for all free-form values so that no value can be confused with a field separator
+		if _, err := fmt.Fprintf(hasher, "%q %s %o %d:%d %d %q %d:%d %s\n",
+			ref.RealPath, fileType, uint32(m.Mode), m.UserID, m.GroupID, m.Size, m.Linkname, m.DevMajor, m.DevMinor, contentDigest); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("sha256:%x", hasher.Sum(nil)), nil
+}
+
 // Read parses information from the underlying image tar into this struct. This includes image metadata, layer
 // metadata, layer file trees, and layer squash trees (which implies the image squash tree).
 func (i *Image) Read() error {
+	start := time.Now()
+	i.timings.reset()
+	i.warnings = nil
+
 	var layers = make([]*Layer, 0)
 	var err error
 	i.Metadata, err = readImageMetadata(i.image)
@@ -142,10 +611,16 @@ func (i *Image) Read() error {
 		return err
 	}
 
+	if err = i.checkLayerLimit(); err != nil {
+		return err
+	}
+
 	log.Debugf("image metadata: digest=%+v mediaType=%+v tags=%+v",
 		i.Metadata.ID,
 		i.Metadata.MediaType,
 		i.Metadata.Tags)
+	i.timings.recordMetadata(start)
+	i.checkHistory()
 
 	v1Layers, err := i.image.Layers()
 	if err != nil {
@@ -156,13 +631,19 @@ func (i *Image) Read() error {
 	readProg := i.trackReadProgress(i.Metadata)
 
 	for idx, v1Layer := range v1Layers {
-		layer := NewLayer(v1Layer)
-		err := layer.Read(&i.FileCatalog, i.Metadata, idx, i.contentCacheDir)
+		layerStart := time.Now()
+		layer, err := i.newLayer(v1Layer)
+		if err != nil {
+			return err
+		}
+		err = layer.Read(&i.FileCatalog, i.Metadata, idx, i.contentCacheDir)
 		if err != nil {
 			return err
 		}
 		i.Metadata.Size += layer.Metadata.Size
+		i.warnings = append(i.warnings, layer.warnings...)
 		layers = append(layers, layer)
+		i.timings.recordLayer(layerStart)
 
 		readProg.N++
 	}
@@ -170,7 +651,78 @@ func (i *Image) Read() error {
 	i.Layers = layers
 
 	// in order to resolve symlinks all squashed trees must be available
-	return i.squash(readProg)
+	squashStart := time.Now()
+	if err := i.squash(readProg); err != nil {
+		return err
+	}
+	i.timings.recordSquash(squashStart)
+	i.timings.recordTotal(start)
+	return nil
+}
+
+// newLayer creates an unread layer for the given layer from the image source, with all layer options of the image.
+func (i *Image) newLayer(v1Layer v1.Layer) (*Layer, error) {
+	if i.chunkLocator != nil {
+		var err error
+		v1Layer, err = newChunkedLayer(v1Layer, i.chunkLocator)
+		if err != nil {
+			return nil, err
+		}
+	}
+	layer := NewLayer(v1Layer)
+	layer.memoryThreshold = i.memoryThreshold
+	layer.headerTransform = i.headerTransform
+	layer.ignorePaths = i.ignorePaths
+	layer.pathInterner = i.pathInterner
+	layer.retainCompressed = i.retainCompressedLayers
+	layer.foreignLayerPolicy = i.foreignLayerPolicy
+	layer.decompressionProgressThreshold = i.decompressionProgressThreshold
+	layer.strictHardlinks = i.strictHardlinks
+	layer.precomputeDigests = i.precomputeDigests
+	layer.memoryMapped = i.memoryMappedCache
+	return layer, nil
+}
+
+// ReadLayerByDigest reads and catalogs only the layer with the given digest, which may be either the layer diff ID (see
+// LayerMetadata.Digest) or the digest of the (compressed) layer blob. No other layers are fetched or read and nothing
+// is squashed, so the returned layer has a diff tree but no squashed tree. The layer files are cataloged in a catalog
+// of their own (the image FileCatalog is not changed), which the layer uses for content access. An ErrLayerNotFound
+// error is returned if the image manifest has no layer with the given digest.
+func (i *Image) ReadLayerByDigest(digest string) (*Layer, error) {
+	metadata, err := readImageMetadata(i.image)
+	if err != nil {
+		return nil, err
+	}
+
+	v1Layers, err := i.image.Layers()
+	if err != nil {
+		return nil, err
+	}
+
+	for idx, v1Layer := range v1Layers {
+		diffID, err := v1Layer.DiffID()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get layer diff ID: %w", err)
+		}
+		blobDigest, err := v1Layer.Digest()
+		if err != nil {
+			return nil, fmt.Errorf("unable to get layer digest: %w", err)
+		}
+		if diffID.String() != digest && blobDigest.String() != digest {
+			continue
+		}
+
+		layer, err := i.newLayer(v1Layer)
+		if err != nil {
+			return nil, err
+		}
+		catalog := NewFileCatalog()
+		if err := layer.Read(&catalog, metadata, idx, i.contentCacheDir); err != nil {
+			return nil, err
+		}
+		return layer, nil
+	}
+	return nil, fmt.Errorf("%w: digest=%q", ErrLayerNotFound, digest)
 }
 
 // squash generates a squash tree for each layer in the image. For instance, layer 2 squash =
@@ -180,8 +732,13 @@ func (i *Image) squash(prog *progress.Manual) error {
 
 	for idx, layer := range i.Layers {
 		if idx == 0 {
-			lastSquashTree = layer.Tree
-			layer.SquashedTree = layer.Tree
+			// AUFS metadata entries are dropped when merging upper layers, so they are removed from the first layer too
+			squashedTree, err := withoutWhiteoutMetadata(layer.Tree)
+			if err != nil {
+				return fmt.Errorf("failed to squash tree %d: %w", idx, err)
+			}
+			lastSquashTree = squashedTree
+			layer.SquashedTree = squashedTree
 			continue
 		}
 
@@ -189,7 +746,13 @@ func (i *Image) squash(prog *progress.Manual) error {
 		unionTree.PushTree(lastSquashTree)
 		unionTree.PushTree(layer.Tree)
 
-		squashedTree, err := unionTree.Squash()
+		var squashedTree *filetree.FileTree
+		var err error
+		if i.whiteoutDiagnostics {
+			squashedTree, layer.whiteoutAffectedPaths, err = unionTree.SquashWithWhiteoutDiagnostics()
+		} else {
+			squashedTree, err = unionTree.Squash()
+		}
 		if err != nil {
 			return fmt.Errorf("failed to squash tree %d: %w", idx, err)
 		}
@@ -200,11 +763,47 @@ func (i *Image) squash(prog *progress.Manual) error {
 		prog.N++
 	}
 
+	if i.materializeSymlinks {
+		// note: this is done after all layers are squashed since each squash tree is the basis for the next
+		for idx, layer := range i.Layers {
+			materialized, err := layer.SquashedTree.MaterializeSymlinks()
+			if err != nil {
+				return fmt.Errorf("failed to materialize symlinks in squash tree %d: %w", idx, err)
+			}
+			layer.SquashedTree = materialized
+		}
+	}
+
 	prog.SetCompleted()
 
 	return nil
 }
 
+// withoutWhiteoutMetadata returns the given tree without any AUFS metadata entries (e.g. /.wh..wh.aufs or the
+// /.wh..wh.plnk hardlink directory). The tree is only copied when there are entries to remove.
+func withoutWhiteoutMetadata(tree *filetree.FileTree) (*filetree.FileTree, error) {
+	var metadataPaths []file.Path
+	for _, p := range tree.AllRealPaths() {
+		if p.IsWhiteoutMetadata() {
+			metadataPaths = append(metadataPaths, p)
+		}
+	}
+	if len(metadataPaths) == 0 {
+		return tree, nil
+	}
+
+	filtered, err := tree.Copy()
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range metadataPaths {
+		if err := filtered.RemovePath(p); err != nil {
+			return nil, fmt.Errorf("unable to remove whiteout metadata path=%q: %w", p, err)
+		}
+	}
+	return filtered, nil
+}
+
 // SquashedTree returns the pre-computed image squash file tree.
 func (i *Image) SquashedTree() *filetree.FileTree {
 	layerCount := len(i.Layers)
@@ -217,12 +816,260 @@ func (i *Image) SquashedTree() *filetree.FileTree {
 	return topLayer.SquashedTree
 }
 
-// FileContentsFromSquash fetches file contents for a single path, relative to the image squash tree.
-// If the path does not exist an error is returned.
+// WhiteoutAffectedPaths returns all paths that opaque directory whiteouts would have removed from the image squash,
+// mapped to the index of the (highest) layer containing the opaque whiteout. This is only populated when the image was
+// read with WithWhiteoutDiagnostics.
+func (i *Image) WhiteoutAffectedPaths() map[file.Path]uint {
+	affected := make(map[file.Path]uint)
+	for _, layer := range i.Layers {
+		for _, p := range layer.whiteoutAffectedPaths {
+			affected[p] = layer.Metadata.Index
+		}
+	}
+	return affected
+}
+
+// AsOfLayer returns a lightweight view of the image as it existed after the given layer index, where the squash tree is
+// the squash tree of that layer and all queries operate against it. No content is re-read; the layers, file trees, and
+// file catalog are shared with this image. The view metadata describes the subset of layers (layer diffIDs, history,
+// and size), while the identifying metadata (ID, tags, digests, and raw config/manifest) is that of the full image.
+func (i *Image) AsOfLayer(n int) (*Image, error) {
+	if n < 0 || n >= len(i.Layers) {
+		return nil, fmt.Errorf("%w: layer=%d (image has %d layers)", ErrLayerOutOfRange, n, len(i.Layers))
+	}
+
+	view := *i
+	view.Layers = i.Layers[: n+1 : n+1]
+
+	config := i.Metadata.Config.DeepCopy()
+	if len(config.RootFS.DiffIDs) > n+1 {
+		config.RootFS.DiffIDs = config.RootFS.DiffIDs[:n+1]
+	}
+	config.History = historyAsOfLayer(config.History, n)
+	view.Metadata.Config = *config
+
+	view.Metadata.Size = 0
+	for _, layer := range view.Layers {
+		view.Metadata.Size += layer.Metadata.Size
+	}
+
+	return &view, nil
+}
+
+// historyAsOfLayer returns the history entries up to and including the entry for the given (non-empty) layer index.
+// Empty layer entries (e.g. ENV instructions) after the layer are not included.
+func historyAsOfLayer(history []v1.History, n int) []v1.History {
+	var layerIdx int
+	for idx, h := range history {
+		if h.EmptyLayer {
+			continue
+		}
+		if layerIdx == n {
+			return history[:idx+1]
+		}
+		layerIdx++
+	}
+	return history
+}
+
+// ReleaseContent frees the file content held for this image after it has been read, while keeping all file trees,
+// catalog metadata, and image metadata available. This drops any in-memory file contents (see WithMemoryThreshold) and
+// removes the uncompressed layer tar cache from disk, which is useful for long-lived processes that only need metadata.
+//
+// Note: content is not lost, however, it is expensive to get back. Any later content request for a layer will
+// re-fetch that layer from the original image source (e.g. re-downloading it from a registry) and fails if the source
+// is no longer available.
+func (i *Image) ReleaseContent() error {
+	for _, layer := range i.Layers {
+		if err := layer.releaseContent(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Cleanup releases any resources held for reading file contents that are not freed by the garbage collector (e.g. memory
+// mapped layer tar caches, see WithMemoryMappedCache). Readers opened from the image before Cleanup fail on later reads,
+// while any later content request falls back to reading from the layer tar cache. The cache directory itself is not
+// removed (see stereoscope.Cleanup).
+func (i *Image) Cleanup() error {
+	var errs error
+	for _, layer := range i.Layers {
+		if err := layer.unmapContent(); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return errs
+}
+
+// FileContentsFromSquash fetches file contents for a single path, relative to the image squash tree. The path is
+// normalized before lookup (dot segments and trailing slashes are removed, relative paths are treated as relative to
+// root). If the path does not exist an error is returned.
 func (i *Image) FileContentsFromSquash(path file.Path) (io.ReadCloser, error) {
 	return fetchFileContentsByPath(i.SquashedTree(), &i.FileCatalog, path)
 }
 
+// FileContentsFromSquashBounded fetches file contents for a single path relative to the image squash tree (in the same
+// way as FileContentsFromSquash), guarding against reading files larger than the given number of bytes. An
+// ErrFileTooLarge is returned without reading any contents if the cataloged size of the file exceeds maxBytes, and the
+// returned reader never yields more than maxBytes regardless of the cataloged size.
+func (i *Image) FileContentsFromSquashBounded(path file.Path, maxBytes int64) (io.ReadCloser, error) {
+	ref, err := fetchFileReferenceByPath(i.SquashedTree(), path)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := i.FileCatalog.Get(*ref)
+	if err != nil {
+		return nil, err
+	}
+	if entry.Metadata.Size > maxBytes {
+		return nil, fmt.Errorf("%w: path=%q size=%d (max=%d bytes)", ErrFileTooLarge, path, entry.Metadata.Size, maxBytes)
+	}
+
+	reader, err := i.FileCatalog.FileContents(*ref)
+	if err != nil {
+		return nil, err
+	}
+	return &boundedReadCloser{Reader: io.LimitReader(reader, maxBytes), Closer: reader}, nil
+}
+
+// boundedReadCloser reads from a limited view of a reader while closing the original reader.
+type boundedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// ReadFile reads the entire contents of the file at the given path relative to the image squash tree (e.g. for parsing
+// package manager databases). An ErrFileTooLarge is returned if the file exceeds the maximum read size (see
+// WithMaxReadFileSize) to guard against accidentally reading huge files into memory.
+func (i *Image) ReadFile(path file.Path) ([]byte, error) {
+	maxSize := i.maxReadFileSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxReadFileSize
+	}
+
+	reader, err := i.FileContentsFromSquash(path)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	// read one more byte than allowed to detect files over the limit without trusting the cataloged size
+	contents, err := ioutil.ReadAll(io.LimitReader(reader, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read path=%q: %w", path, err)
+	}
+	if int64(len(contents)) > maxSize {
+		return nil, fmt.Errorf("%w: path=%q (max=%d bytes)", ErrFileTooLarge, path, maxSize)
+	}
+	return contents, nil
+}
+
+// TryMultipleFileContentsFromSquash fetches file contents for each of the given paths relative to the image squash tree
+// where some paths may legitimately be absent (e.g. when probing several candidate config locations). Contents are
+// returned for all paths that exist, along with the paths that could not be found. An error is only returned when
+// reading an existing file fails, in which case all readers that were already opened are closed. The caller is
+// responsible for closing all returned readers.
+func (i *Image) TryMultipleFileContentsFromSquash(paths ...file.Path) (map[file.Reference]io.ReadCloser, []file.Path, error) {
+	contents := make(map[file.Reference]io.ReadCloser)
+	fail := func(p file.Path, err error) (map[file.Reference]io.ReadCloser, []file.Path, error) {
+		for _, reader := range contents {
+			reader.Close()
+		}
+		return nil, nil, fmt.Errorf("unable to fetch contents for path=%q: %w", p, err)
+	}
+
+	var missing []file.Path
+	for _, p := range paths {
+		ref, err := fetchFileReferenceByPath(i.SquashedTree(), p)
+		if errors.Is(err, ErrPathNotFound) {
+			missing = append(missing, p)
+			continue
+		}
+		if err != nil {
+			return fail(p, err)
+		}
+		if _, exists := contents[*ref]; exists {
+			// several paths (e.g. through symlinks) may resolve to the same file
+			continue
+		}
+
+		reader, err := i.FileCatalog.FileContents(*ref)
+		if err != nil {
+			return fail(p, err)
+		}
+		contents[*ref] = reader
+	}
+	return contents, missing, nil
+}
+
+// FileReferenceFromSquash resolves the file reference for a single path relative to the image squash tree, along with
+// the index of the layer that the file was introduced in. The path is normalized before lookup, while the returned
+// reference retains the real path within the tree. If the path does not exist an error is returned.
+func (i *Image) FileReferenceFromSquash(path file.Path) (*file.Reference, uint, error) {
+	ref, err := fetchFileReferenceByPath(i.SquashedTree(), path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	layerIndex, err := i.FileCatalog.LayerIndex(*ref)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ref, layerIndex, nil
+}
+
+// ResolveFromSquash resolves the given path relative to the image squash tree, returning both the reference found at
+// the path itself (which is a link when the path is a symlink or hardlink) and the reference of the final target of the
+// link (which is the same reference when the path is not a link), along with the contents of the target. The path is
+// normalized before lookup and any link resolution options are applied when resolving the target. For dead links the
+// link reference is returned with no target or contents. Contents are only returned for regular files (e.g. not for
+// directories), in which case the caller is responsible for closing the returned reader. If the path does not exist an
+// ErrPathNotFound error is returned.
+func (i *Image) ResolveFromSquash(path file.Path, options ...filetree.LinkResolutionOption) (*file.Reference, *file.Reference, io.ReadCloser, error) {
+	tree := i.SquashedTree()
+	normalizedPath := path.NormalizeForLookup()
+
+	exists, linkRef, err := tree.File(normalizedPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if !exists || linkRef == nil {
+		return nil, nil, nil, fmt.Errorf("%w: %s", ErrPathNotFound, path)
+	}
+
+	allOptions := append([]filetree.LinkResolutionOption{filetree.FollowBasenameLinks}, options...)
+	_, targetRef, err := tree.File(normalizedPath, allOptions...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if targetRef == nil {
+		// dead link
+		return linkRef, nil, nil, nil
+	}
+
+	entry, err := i.FileCatalog.Get(*targetRef)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("unable to find catalog entry for ref=%+v: %w", targetRef, err)
+	}
+	switch entry.Type() {
+	case file.TypeReg:
+		// continue to fetch contents
+	case file.TypeSymlink, file.TypeHardLink:
+		// the link could not be followed to a target (e.g. a dead link with DoNotFollowDeadBasenameLinks)
+		return linkRef, nil, nil, nil
+	default:
+		return linkRef, targetRef, nil, nil
+	}
+
+	contents, err := i.FileCatalog.FileContents(*targetRef)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return linkRef, targetRef, contents, nil
+}
+
 // FilesByMIMETypeFromSquash returns file references for files that match at least one of the given MIME types.
 func (i *Image) FilesByMIMETypeFromSquash(mimeTypes ...string) ([]file.Reference, error) {
 	var refs []file.Reference
@@ -236,16 +1083,233 @@ func (i *Image) FilesByMIMETypeFromSquash(mimeTypes ...string) ([]file.Reference
 	return refs, nil
 }
 
+// FilesByTypeFromSquash returns file references (sorted by path) for files in the image squash tree with any of the
+// given types. The type of each file is taken from the cataloged tar header (see FileCatalogEntry.Type), which is more
+// precise than the file tree types (e.g. fifos are represented as regular files within the file tree).
+func (i *Image) FilesByTypeFromSquash(types ...file.Type) ([]file.Reference, error) {
+	var refs []file.Reference
+	for _, ref := range i.SquashedTree().AllFiles(file.AllTypes...) {
+		entry, err := i.FileCatalog.Get(ref)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find catalog entry for ref=%+v: %w", ref, err)
+		}
+		if containsType(types, entry.Type()) {
+			refs = append(refs, ref)
+		}
+	}
+
+	sort.Slice(refs, func(a, b int) bool {
+		return refs[a].RealPath < refs[b].RealPath
+	})
+
+	return refs, nil
+}
+
+// Annotate attaches a user annotation (e.g. "secret": "true") to the given file reference, which may be retrieved later
+// with Annotations. Annotations are stored in a side-table on the image, so references are not modified. This is
+// useful for multi-pass analysis that enriches findings about files.
+func (i *Image) Annotate(ref file.Reference, key, value string) {
+	i.FileCatalog.Annotate(ref, key, value)
+}
+
+// Annotations returns a copy of all user annotations for the given file reference (nil if there are none).
+func (i *Image) Annotations(ref file.Reference) map[string]string {
+	return i.FileCatalog.Annotations(ref)
+}
+
+// FileFilter describes a selection of files by path and type.
+type FileFilter struct {
+	// Glob is a glob pattern (see doublestar.Match) that the real path of each file must match (empty matches all paths)
+	Glob string
+	// Types are the file types to match (empty matches all types)
+	Types []file.Type
+}
+
+// CountSquash returns the number of files in the image squash tree that match the given filter. Only the tree and the
+// catalog metadata are considered (no content is read), so this is a cheap way to estimate the work of a full pass
+// over the matching files.
+func (i *Image) CountSquash(filter FileFilter) (int, error) {
+	glob := filter.Glob
+	if glob != "" {
+		if !strings.HasPrefix(glob, file.DirSeparator) {
+			glob = file.DirSeparator + glob
+		}
+		if !doublestar.ValidatePattern(glob) {
+			return 0, fmt.Errorf("invalid glob pattern=%q", filter.Glob)
+		}
+	}
+
+	var count int
+	for _, ref := range i.SquashedTree().AllFiles(file.AllTypes...) {
+		if glob != "" {
+			if matched, _ := doublestar.Match(glob, string(ref.RealPath)); !matched {
+				continue
+			}
+		}
+
+		if len(filter.Types) > 0 {
+			entry, err := i.FileCatalog.Get(ref)
+			if err != nil {
+				return 0, fmt.Errorf("unable to find catalog entry for ref=%+v: %w", ref, err)
+			}
+			if !containsType(filter.Types, entry.Type()) {
+				continue
+			}
+		}
+
+		count++
+	}
+	return count, nil
+}
+
+func containsType(types []file.Type, ty file.Type) bool {
+	for _, t := range types {
+		if t == ty {
+			return true
+		}
+	}
+	return false
+}
+
 // FileContentsByRef fetches file contents for a single file reference, irregardless of the source layer.
 // If the path does not exist an error is returned.
 func (i *Image) FileContentsByRef(ref file.Reference) (io.ReadCloser, error) {
 	return i.FileCatalog.FileContents(ref)
 }
 
+// WalkSquash invokes the given visitor for every file reference in the image squash tree in breadth-first order (siblings
+// in path order), along with the depth of the path in the tree ("/etc" is at depth 1, "/etc/hosts" is at depth 2). This
+// is useful for rendering the image filesystem as an indented directory tree. Parent directories that have no tar entry
+// of their own are not visited, though their children are. The walk stops when the visitor returns an error, which is
+// then returned.
+func (i *Image) WalkSquash(fn func(ref file.Reference, depth int) error) error {
+	return i.SquashedTree().WalkBreadthFirst(func(f filenode.FileNode, depth int) error {
+		if f.Reference == nil {
+			return nil
+		}
+		return fn(*f.Reference, depth)
+	})
+}
+
+// WarmCache reads the contents of the given file references into memory ahead of time, so that subsequent calls to
+// FileContentsByRef (and friends) for these references are served without reading from the layer tar cache. References
+// are grouped by layer and each relevant layer tar is scanned once, which is cheaper than fetching each file separately.
+// References that have no content (e.g. directories and links) are ignored. Files larger than the memory threshold (see
+// WithMemoryThreshold), or the maximum read size (see WithMaxReadFileSize) when no threshold is set, are not held in
+// memory and continue to be read from the layer tar cache.
+func (i *Image) WarmCache(refs ...file.Reference) error {
+	layers, refsByLayer, err := i.regularFilesByLayer(refs)
+	if err != nil {
+		return err
+	}
+
+	maxSize := i.memoryThreshold
+	if maxSize <= 0 {
+		maxSize = i.maxReadFileSize
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxReadFileSize
+	}
+
+	for _, layer := range layers {
+		if err := layer.warmContent(refsByLayer[layer], maxSize); err != nil {
+			return fmt.Errorf("unable to warm content cache for layer=%q: %w", layer.Metadata.Digest, err)
+		}
+	}
+	return nil
+}
+
+// VerifyContentAvailable verifies that the contents of each of the given file references can be fetched, without
+// reading the contents, which catches a corrupted or removed layer tar cache (or an index that does not match the cache)
+// before any expensive processing. If no references are given then all regular files in the image squash tree are
+// verified. References without content (e.g. directories and links) are always considered available. All references
+// that cannot be fetched are reported together, each wrapping ErrContentUnavailable.
+func (i *Image) VerifyContentAvailable(refs ...file.Reference) error {
+	if len(refs) == 0 {
+		refs = i.SquashedTree().AllFiles(file.TypeReg)
+	}
+
+	var result error
+	for _, ref := range refs {
+		if err := i.checkContent(ref); err != nil {
+			result = multierror.Append(result, fmt.Errorf("%w: path=%q: %v", ErrContentUnavailable, ref.RealPath, err))
+		}
+	}
+	return result
+}
+
+// checkContent verifies that the contents of the given file reference can be fetched (see VerifyContentAvailable).
+func (i *Image) checkContent(ref file.Reference) error {
+	entry, err := i.FileCatalog.Get(ref)
+	if err != nil {
+		return err
+	}
+	if entry.Type() != file.TypeReg {
+		return nil
+	}
+	if entry.Contents == nil {
+		return ErrContentNotCached
+	}
+	if entry.Layer == nil {
+		return fmt.Errorf("no layer found for file")
+	}
+	return entry.Layer.checkContent(entry.Metadata)
+}
+
+// StreamContents invokes the given function with the contents of each of the given file references, making a single
+// pass over the tar of each layer involved (in layer order) instead of opening the contents of each file separately.
+// The function is invoked once per distinct reference in tar order, and the reader is only valid until the function
+// returns. References that have no content (e.g. directories and links) are ignored. Any error returned by the function
+// stops streaming and is returned (wrapped).
+func (i *Image) StreamContents(refs []file.Reference, fn func(file.Reference, io.Reader) error) error {
+	layers, refsByLayer, err := i.regularFilesByLayer(refs)
+	if err != nil {
+		return err
+	}
+
+	for _, layer := range layers {
+		if err := layer.streamContent(refsByLayer[layer], fn); err != nil {
+			return fmt.Errorf("unable to stream content for layer=%q: %w", layer.Metadata.Digest, err)
+		}
+	}
+	return nil
+}
+
+// regularFilesByLayer groups the given references to regular files by the layer (sorted by index) and tar sequence of
+// each file. All other references are ignored.
+func (i *Image) regularFilesByLayer(refs []file.Reference) ([]*Layer, map[*Layer]map[int64]file.Reference, error) {
+	var layers []*Layer
+	refsByLayer := make(map[*Layer]map[int64]file.Reference)
+	for _, ref := range refs {
+		entry, err := i.FileCatalog.Get(ref)
+		if err != nil {
+			return nil, nil, err
+		}
+		if entry.Type() != file.TypeReg || entry.Layer == nil {
+			continue
+		}
+		sequences, ok := refsByLayer[entry.Layer]
+		if !ok {
+			sequences = make(map[int64]file.Reference)
+			refsByLayer[entry.Layer] = sequences
+			layers = append(layers, entry.Layer)
+		}
+		sequences[entry.Metadata.TarSequence] = ref
+	}
+
+	sort.Slice(layers, func(a, b int) bool {
+		return layers[a].Metadata.Index < layers[b].Metadata.Index
+	})
+	return layers, refsByLayer, nil
+}
+
 // ResolveLinkByLayerSquash resolves a symlink or hardlink for the given file reference relative to the result from
 // the layer squash of the given layer index argument.
 // If the given file reference is not a link type, or is a unresolvable (dead) link, then the given file reference is returned.
 func (i *Image) ResolveLinkByLayerSquash(ref file.Reference, layer int, options ...filetree.LinkResolutionOption) (*file.Reference, error) {
+	if layer < 0 || layer >= len(i.Layers) {
+		return nil, fmt.Errorf("%w: layer=%d (image has %d layers)", ErrLayerOutOfRange, layer, len(i.Layers))
+	}
 	allOptions := append([]filetree.LinkResolutionOption{filetree.FollowBasenameLinks}, options...)
 	_, resolvedRef, err := i.Layers[layer].SquashedTree.File(ref.RealPath, allOptions...)
 	return resolvedRef, err
@@ -254,7 +1318,278 @@ func (i *Image) ResolveLinkByLayerSquash(ref file.Reference, layer int, options
 // ResolveLinkByLayerSquash resolves a symlink or hardlink for the given file reference relative to the result from the image squash.
 // If the given file reference is not a link type, or is a unresolvable (dead) link, then the given file reference is returned.
 func (i *Image) ResolveLinkByImageSquash(ref file.Reference, options ...filetree.LinkResolutionOption) (*file.Reference, error) {
+	if len(i.Layers) == 0 {
+		return nil, fmt.Errorf("%w: image has no layers", ErrLayerOutOfRange)
+	}
 	allOptions := append([]filetree.LinkResolutionOption{filetree.FollowBasenameLinks}, options...)
 	_, resolvedRef, err := i.Layers[len(i.Layers)-1].SquashedTree.File(ref.RealPath, allOptions...)
 	return resolvedRef, err
 }
+
+// ChangedReferencesSince returns the file references in the image squash tree (sorted by path) that were introduced by
+// layers that are not shared with the given base image and that differ from the same path in the squash tree of the
+// base image. Layers are considered shared when the diffIDs of both images match from the bottom layer upwards; only
+// references from the divergent (upper) layers of this image are compared to the base image (in the same way as
+// FilesNotIn), so files from shared layers are never read.
+func (i *Image) ChangedReferencesSince(baseImage *Image) ([]file.Reference, error) {
+	if baseImage == nil {
+		return nil, fmt.Errorf("no base image given")
+	}
+
+	var commonLayers int
+	for idx, layer := range i.Layers {
+		if idx >= len(baseImage.Layers) || baseImage.Layers[idx].Metadata.Digest != layer.Metadata.Digest {
+			break
+		}
+		commonLayers++
+	}
+
+	baseTree := baseImage.SquashedTree()
+	var refs []file.Reference
+	for _, ref := range i.SquashedTree().AllFiles(file.AllTypes...) {
+		entry, err := i.FileCatalog.Get(ref)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find catalog entry for ref=%+v: %w", ref, err)
+		}
+		if entry.Layer != nil && entry.Layer.Metadata.Index < uint(commonLayers) {
+			continue
+		}
+
+		_, baseRef, err := baseTree.File(ref.RealPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find path=%q in base image: %w", ref.RealPath, err)
+		}
+		if baseRef != nil {
+			same, err := i.sameFile(ref, baseImage, *baseRef)
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				continue
+			}
+		}
+		refs = append(refs, ref)
+	}
+
+	sort.Slice(refs, func(a, b int) bool {
+		return refs[a].RealPath < refs[b].RealPath
+	})
+
+	return refs, nil
+}
+
+// FilesNotIn returns the file references in the image squash tree (sorted by path) that are not present in the squash
+// tree of the given base image, which is what was added (or changed) on top of the base image. A path is considered
+// present in the base image when it has the same file type and:
+//   - for regular files, the same contents (compared by sha256 digest).
+//   - for symlinks and hardlinks, the same link destination.
+//   - for all other types (e.g. directories), the path alone.
+//
+// Unlike ChangedReferencesSince, the layers of both images do not need to be shared (e.g. the base image may have been
+// rebuilt), however, contents of files that differ in origin but not in size need to be read to be compared.
+func (i *Image) FilesNotIn(base *Image) ([]file.Reference, error) {
+	if base == nil {
+		return nil, fmt.Errorf("no base image given")
+	}
+
+	baseTree := base.SquashedTree()
+	var refs []file.Reference
+	for _, ref := range i.SquashedTree().AllFiles(file.AllTypes...) {
+		_, baseRef, err := baseTree.File(ref.RealPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find path=%q in base image: %w", ref.RealPath, err)
+		}
+		if baseRef != nil {
+			same, err := i.sameFile(ref, base, *baseRef)
+			if err != nil {
+				return nil, err
+			}
+			if same {
+				continue
+			}
+		}
+		refs = append(refs, ref)
+	}
+
+	sort.Slice(refs, func(a, b int) bool {
+		return refs[a].RealPath < refs[b].RealPath
+	})
+
+	return refs, nil
+}
+
+// sameFile indicates if the given file reference has the same type and contents as the reference from the other image.
+func (i *Image) sameFile(ref file.Reference, other *Image, otherRef file.Reference) (bool, error) {
+	entry, err := i.FileCatalog.Get(ref)
+	if err != nil {
+		return false, fmt.Errorf("unable to find catalog entry for ref=%+v: %w", ref, err)
+	}
+	otherEntry, err := other.FileCatalog.Get(otherRef)
+	if err != nil {
+		return false, fmt.Errorf("unable to find catalog entry for ref=%+v: %w", otherRef, err)
+	}
+
+	if entry.Type() != otherEntry.Type() {
+		return false, nil
+	}
+
+	switch entry.Type() {
+	case file.TypeReg:
+		if entry.Metadata.Size != otherEntry.Metadata.Size {
+			return false, nil
+		}
+		// the same entry from the same layer (e.g. a shared base layer) is identical without reading any contents
+		if entry.Layer != nil && otherEntry.Layer != nil &&
+			entry.Layer.Metadata.Digest == otherEntry.Layer.Metadata.Digest &&
+			entry.Metadata.TarSequence == otherEntry.Metadata.TarSequence {
+			return true, nil
+		}
+
+		digest, err := fetchFileDigest(&i.FileCatalog, ref)
+		if err != nil {
+			return false, err
+		}
+		otherDigest, err := fetchFileDigest(&other.FileCatalog, otherRef)
+		if err != nil {
+			return false, err
+		}
+		return digest == otherDigest, nil
+	case file.TypeSymlink, file.TypeHardLink:
+		return entry.Metadata.Linkname == otherEntry.Metadata.Linkname, nil
+	}
+	return true, nil
+}
+
+// PrivilegedFiles returns all files (of any type) in the squash tree that have the setuid, setgid, or sticky mode bits
+// set, sorted by path. The mode is taken from the file metadata stored in the catalog.
+func (i *Image) PrivilegedFiles() ([]PrivilegedFile, error) {
+	var results []PrivilegedFile
+	for _, ref := range i.SquashedTree().AllFiles(file.AllTypes...) {
+		entry, err := i.FileCatalog.Get(ref)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find catalog entry for ref=%+v: %w", ref, err)
+		}
+		if bits := entry.Metadata.Mode & privilegedModeBits; bits != 0 {
+			results = append(results, PrivilegedFile{
+				Reference: ref,
+				Mode:      bits,
+			})
+		}
+	}
+
+	sort.Slice(results, func(a, b int) bool {
+		return results[a].Reference.RealPath < results[b].Reference.RealPath
+	})
+
+	return results, nil
+}
+
+// Symlinks returns all symlinks in the squash tree along with their raw link destinations as captured from the tar
+// headers (no link resolution is performed), sorted by path.
+func (i *Image) Symlinks() ([]SymlinkEntry, error) {
+	var results []SymlinkEntry
+	for _, ref := range i.SquashedTree().AllFiles(file.TypeSymlink) {
+		entry, err := i.FileCatalog.Get(ref)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find catalog entry for ref=%+v: %w", ref, err)
+		}
+		results = append(results, SymlinkEntry{
+			Reference: ref,
+			Linkname:  entry.Metadata.Linkname,
+		})
+	}
+
+	sort.Slice(results, func(a, b int) bool {
+		return results[a].Reference.RealPath < results[b].Reference.RealPath
+	})
+
+	return results, nil
+}
+
+// HardlinkGroups returns groups of file references in the squash tree that are the same underlying file (inode) by way
+// of hardlinks, as captured by the tar link targets. Each group contains the hardlink target (when it is present in
+// the squash tree) and all hardlinks to it, sorted by path; groups are sorted by their first path. Only groups with
+// more than one reference are returned. Unlike grouping by content digest, this never groups distinct files that
+// happen to have the same contents.
+func (i *Image) HardlinkGroups() [][]file.Reference {
+	tree := i.SquashedTree()
+	hardlinks := tree.AllFiles(file.TypeHardLink)
+	if len(hardlinks) == 0 {
+		return nil
+	}
+
+	refsByPath := make(map[file.Path]file.Reference)
+	for _, ref := range tree.AllFiles(file.AllTypes...) {
+		refsByPath[ref.RealPath] = ref
+	}
+
+	targets := make(map[file.Path]file.Path)
+	for _, ref := range hardlinks {
+		entry, err := i.FileCatalog.Get(ref)
+		if err != nil {
+			log.Debugf("unable to find catalog entry for hardlink=%q: %+v", ref.RealPath, err)
+			continue
+		}
+		targets[ref.RealPath] = hardlinkTarget(entry.Metadata.Linkname)
+	}
+
+	groups := make(map[file.Path][]file.Reference)
+	for _, ref := range hardlinks {
+		target, ok := targets[ref.RealPath]
+		if !ok {
+			continue
+		}
+		// follow hardlinks to hardlinks to find the original file (bounded in case of malformed archives)
+		for depth := 0; depth < len(targets); depth++ {
+			next, isLink := targets[target]
+			if !isLink || next == target {
+				break
+			}
+			target = next
+		}
+		groups[target] = append(groups[target], ref)
+	}
+
+	var results [][]file.Reference
+	for target, refs := range groups {
+		if _, isLink := targets[target]; !isLink {
+			if targetRef, ok := refsByPath[target]; ok {
+				refs = append(refs, targetRef)
+			}
+		}
+		if len(refs) < 2 {
+			continue
+		}
+		sort.Slice(refs, func(a, b int) bool {
+			return refs[a].RealPath < refs[b].RealPath
+		})
+		results = append(results, refs)
+	}
+
+	sort.Slice(results, func(a, b int) bool {
+		return results[a][0].RealPath < results[b][0].RealPath
+	})
+
+	return results
+}
+
+// hardlinkTarget returns the absolute path of a raw tar hardlink destination (which is relative to the archive root).
+func hardlinkTarget(linkname string) file.Path {
+	return file.Path(path.Clean(file.DirSeparator + linkname))
+}
+
+// CanonicalPath returns the real path within the squash tree for the given path after resolving all symlinks found
+// in the directory components of the path (e.g. when /lib links to /usr/lib, the canonical path of /lib/x is
+// /usr/lib/x). A symlink at the basename of the path is not followed. ErrPathNotFound is returned if the path does
+// not exist.
+func (i *Image) CanonicalPath(path file.Path) (file.Path, error) {
+	normalizedPath := path.NormalizeForLookup()
+	exists, ref, err := i.SquashedTree().File(normalizedPath)
+	if err != nil {
+		return "", err
+	}
+	if !exists || ref == nil {
+		return "", fmt.Errorf("%w: %s", ErrPathNotFound, path)
+	}
+	return ref.RealPath, nil
+}
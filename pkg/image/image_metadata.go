@@ -1,6 +1,14 @@
 package image
 
 import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/anchore/stereoscope/internal/log"
+	"github.com/anchore/stereoscope/pkg/file"
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	v1Types "github.com/google/go-containerregistry/pkg/v1/types"
@@ -10,10 +18,23 @@ import (
 type Metadata struct {
 	// ID is the sha256 of this image config json (not manifest)
 	ID string
-	// Size in bytes of all the image layer content sizes (does not include config / manifest / index metadata sizes)
+	// Size in bytes of all the uncompressed image layer content sizes (does not include config / manifest / index metadata sizes)
 	Size      int64
 	Config    v1.ConfigFile
 	MediaType v1Types.MediaType
+	// ManifestSchemaVersion is the schemaVersion of the image manifest (2 for both docker v2 and OCI manifests, 0 if unknown)
+	ManifestSchemaVersion int64
+	// IsOCI indicates if the image manifest is an OCI manifest (as opposed to a docker v2 manifest)
+	IsOCI bool
+	// Labels are all key-value pairs from the image config (e.g. from LABEL instructions)
+	Labels map[string]string
+	// Annotations are all key-value pairs from the image manifest (e.g. OCI "org.opencontainers.image.*" annotations).
+	// These are distinct from the config Labels and are not part of the image config.
+	Annotations map[string]string
+	// Volumes are the declared volume mount points from the image config (e.g. from VOLUME instructions), sorted
+	Volumes []string
+	// Created is the image creation timestamp from the image config (zero-valued if not specified)
+	Created time.Time
 	// --- below fields are optional metadata
 	Tags           []name.Tag
 	RawManifest    []byte
@@ -22,32 +43,194 @@ type Metadata struct {
 	RepoDigests    []string
 }
 
+// RuntimeConfig describes how containers are run from the image, as declared in the image config.
+type RuntimeConfig struct {
+	// ExposedPorts are the declared ports (e.g. "80/tcp") from EXPOSE instructions, sorted
+	ExposedPorts []string
+	// Healthcheck is the declared container healthcheck (nil if not declared)
+	Healthcheck *v1.HealthConfig
+	// StopSignal is the signal used to stop containers (e.g. "SIGTERM"), empty if not declared
+	StopSignal string
+	// Shell is the shell used for the shell form of commands (e.g. ["/bin/sh", "-c"]), empty if not declared
+	Shell []string
+	// OnBuild are the triggers (e.g. "RUN make") that run, in order, when the image is used as the base of another
+	// build, empty if not declared
+	OnBuild []string
+}
+
+// ErrNotARunnableImage is returned when the manifest describes an OCI artifact (e.g. a helm chart, signature, or SBOM
+// attachment) instead of a runnable container image. The artifact config media type and layer descriptors are
+// provided for lightweight inspection.
+type ErrNotARunnableImage struct {
+	ConfigMediaType v1Types.MediaType
+	Layers          []v1.Descriptor
+}
+
+func (e *ErrNotARunnableImage) Error() string {
+	return fmt.Sprintf("manifest describes an artifact and not a runnable image (config mediaType=%q)", e.ConfigMediaType)
+}
+
+// alternativeConfigMediaTypes are non-standard config media types used by some image builders for what is otherwise
+// a regular image config (which may be gzip compressed).
+var alternativeConfigMediaTypes = []v1Types.MediaType{
+	"application/json",
+	"application/vnd.docker.container.image.v1+json+gzip",
+	"application/vnd.oci.image.config.v1+json+gzip",
+}
+
+// isRunnableConfigMediaType indicates if the given manifest config media type describes a container image config. An
+// empty media type is assumed to be an image config since not all sources populate this value.
+func isRunnableConfigMediaType(mediaType v1Types.MediaType) bool {
+	switch mediaType {
+	case "", v1Types.DockerConfigJSON, v1Types.OCIConfigJSON:
+		return true
+	}
+	for _, alternative := range alternativeConfigMediaTypes {
+		if mediaType == alternative {
+			return true
+		}
+	}
+	return false
+}
+
+// canonicalConfig returns the plain JSON image config from the given raw config blob, decompressing the blob if it is
+// compressed. The returned bool indicates if the raw config was compressed.
+func canonicalConfig(rawConfig []byte) ([]byte, bool, error) {
+	reader, compression, err := file.NewDecompressingReader(ioutil.NopCloser(bytes.NewReader(rawConfig)))
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to decompress image config: %w", err)
+	}
+	defer reader.Close()
+
+	if compression == file.NoCompression {
+		return rawConfig, false, nil
+	}
+
+	config, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to decompress image config (compression=%s): %w", compression, err)
+	}
+	return config, true, nil
+}
+
+// checkRunnableImage returns an ErrNotARunnableImage if the image manifest describes an artifact instead of an image.
+func checkRunnableImage(img v1.Image) error {
+	manifest, err := img.Manifest()
+	if err != nil || manifest == nil {
+		// we should not block reading the image if there is no manifest available
+		log.Debugf("unable to check manifest config media type: %+v", err)
+		return nil
+	}
+
+	if !isRunnableConfigMediaType(manifest.Config.MediaType) {
+		return &ErrNotARunnableImage{
+			ConfigMediaType: manifest.Config.MediaType,
+			Layers:          manifest.Layers,
+		}
+	}
+	return nil
+}
+
+// isOCIManifest indicates if the given manifest (with the given media type) is an OCI manifest. Since the media type
+// is optional for OCI manifests, the config media type is considered when the manifest media type is absent.
+func isOCIManifest(mediaType v1Types.MediaType, manifest *v1.Manifest) bool {
+	switch mediaType {
+	case v1Types.OCIManifestSchema1:
+		return true
+	case "":
+		return manifest.Config.MediaType == v1Types.OCIConfigJSON
+	}
+	return false
+}
+
 // readImageMetadata extracts the most pertinent information from the underlying image tar.
 func readImageMetadata(img v1.Image) (Metadata, error) {
-	id, err := img.ConfigName()
+	if err := checkRunnableImage(img); err != nil {
+		return Metadata{}, err
+	}
+
+	rawConfig, err := img.RawConfigFile()
 	if err != nil {
 		return Metadata{}, err
 	}
 
-	config, err := img.ConfigFile()
+	rawConfig, compressed, err := canonicalConfig(rawConfig)
 	if err != nil {
 		return Metadata{}, err
 	}
 
+	var id v1.Hash
+	var config *v1.ConfigFile
+	if compressed {
+		// the image ID is always the digest of the plain JSON config, not of the compressed blob
+		id, _, err = v1.SHA256(bytes.NewReader(rawConfig))
+		if err != nil {
+			return Metadata{}, err
+		}
+		config, err = v1.ParseConfigFile(bytes.NewReader(rawConfig))
+		if err != nil {
+			return Metadata{}, fmt.Errorf("unable to parse decompressed image config: %w", err)
+		}
+	} else {
+		id, err = img.ConfigName()
+		if err != nil {
+			return Metadata{}, err
+		}
+		config, err = img.ConfigFile()
+		if err != nil {
+			return Metadata{}, err
+		}
+	}
+
 	mediaType, err := img.MediaType()
 	if err != nil {
 		return Metadata{}, err
 	}
 
-	rawConfig, err := img.RawConfigFile()
+	labels := make(map[string]string, len(config.Config.Labels))
+	for k, v := range config.Config.Labels {
+		labels[k] = v
+	}
+
+	// make a best-effort attempt at getting the raw manifest (which may be overridden by the provider, e.g. with the
+	// exact bytes fetched from a registry)
+	rawManifest, err := img.RawManifest()
 	if err != nil {
-		return Metadata{}, err
+		log.Debugf("unable to read raw manifest: %+v", err)
+		rawManifest = nil
+	}
+
+	var schemaVersion int64
+	var isOCI bool
+	annotations := make(map[string]string)
+	if manifest, err := img.Manifest(); err == nil && manifest != nil {
+		schemaVersion = manifest.SchemaVersion
+		isOCI = isOCIManifest(mediaType, manifest)
+		for k, v := range manifest.Annotations {
+			annotations[k] = v
+		}
+	} else {
+		// we should not block reading the image if there is no manifest available
+		log.Debugf("unable to determine manifest schema version: %+v", err)
+	}
+
+	volumes := make([]string, 0, len(config.Config.Volumes))
+	for v := range config.Config.Volumes {
+		volumes = append(volumes, v)
 	}
+	sort.Strings(volumes)
 
 	return Metadata{
-		ID:        id.String(),
-		Config:    *config,
-		MediaType: mediaType,
-		RawConfig: rawConfig,
+		ID:                    id.String(),
+		Config:                *config,
+		MediaType:             mediaType,
+		ManifestSchemaVersion: schemaVersion,
+		IsOCI:                 isOCI,
+		Labels:                labels,
+		Annotations:           annotations,
+		Volumes:               volumes,
+		Created:               config.Created.Time,
+		RawConfig:             rawConfig,
+		RawManifest:           rawManifest,
 	}, nil
 }
@@ -0,0 +1,452 @@
+package image
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// manifestOnlyImage is a v1.Image that only provides a manifest (all other calls panic).
+type manifestOnlyImage struct {
+	v1.Image
+	manifest *v1.Manifest
+}
+
+func (m *manifestOnlyImage) Manifest() (*v1.Manifest, error) {
+	return m.manifest, nil
+}
+
+// gzipConfigImage is a v1.Image with a gzip compressed config blob (which the underlying image cannot parse).
+type gzipConfigImage struct {
+	v1.Image
+	rawConfig []byte
+}
+
+func newGzipConfigImage(t *testing.T, img v1.Image) *gzipConfigImage {
+	t.Helper()
+	rawConfig, err := img.RawConfigFile()
+	if err != nil {
+		t.Fatalf("could not get config: %+v", err)
+	}
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(rawConfig); err != nil {
+		t.Fatalf("could not compress config: %+v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("could not compress config: %+v", err)
+	}
+
+	return &gzipConfigImage{Image: img, rawConfig: buf.Bytes()}
+}
+
+func (g *gzipConfigImage) RawConfigFile() ([]byte, error) {
+	return g.rawConfig, nil
+}
+
+func (g *gzipConfigImage) ConfigName() (v1.Hash, error) {
+	h, _, err := v1.SHA256(bytes.NewReader(g.rawConfig))
+	return h, err
+}
+
+func (g *gzipConfigImage) ConfigFile() (*v1.ConfigFile, error) {
+	return nil, fmt.Errorf("unable to parse compressed config")
+}
+
+func (g *gzipConfigImage) Manifest() (*v1.Manifest, error) {
+	manifest, err := g.Image.Manifest()
+	if err != nil {
+		return nil, err
+	}
+	manifest = manifest.DeepCopy()
+	manifest.Config.MediaType = "application/vnd.docker.container.image.v1+json+gzip"
+	return manifest, nil
+}
+
+func TestReadImageMetadata_GzipConfig(t *testing.T) {
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("could not create random image: %+v", err)
+	}
+
+	expected, err := readImageMetadata(img)
+	if err != nil {
+		t.Fatalf("could not read metadata: %+v", err)
+	}
+
+	actual, err := readImageMetadata(newGzipConfigImage(t, img))
+	assert.NoError(t, err)
+
+	// the ID and raw config are based on the plain JSON config
+	assert.Equal(t, expected.ID, actual.ID)
+	assert.Equal(t, expected.RawConfig, actual.RawConfig)
+	assert.Equal(t, normalizeConfigTimes(expected.Config), normalizeConfigTimes(actual.Config))
+}
+
+// normalizeConfigTimes returns a copy of the config with all times in UTC, since decoding the config may yield times
+// in the local timezone (which are equal in time but not in location).
+func normalizeConfigTimes(cfg v1.ConfigFile) v1.ConfigFile {
+	normalized := *cfg.DeepCopy()
+	normalized.Created = v1.Time{Time: normalized.Created.UTC()}
+	for idx := range normalized.History {
+		normalized.History[idx].Created = v1.Time{Time: normalized.History[idx].Created.UTC()}
+	}
+	return normalized
+}
+
+func TestCheckRunnableImage(t *testing.T) {
+	layers := []v1.Descriptor{
+		{
+			MediaType: "application/vnd.cncf.helm.chart.content.v1.tar+gzip",
+			Size:      42,
+		},
+	}
+
+	tests := []struct {
+		name            string
+		configMediaType types.MediaType
+		wantErr         bool
+	}{
+		{
+			name:            "docker image config",
+			configMediaType: types.DockerConfigJSON,
+		},
+		{
+			name:            "oci image config",
+			configMediaType: types.OCIConfigJSON,
+		},
+		{
+			name:            "missing config media type",
+			configMediaType: "",
+		},
+		{
+			name:            "gzip compressed oci image config",
+			configMediaType: "application/vnd.oci.image.config.v1+json+gzip",
+		},
+		{
+			name:            "helm chart artifact",
+			configMediaType: "application/vnd.cncf.helm.config.v1+json",
+			wantErr:         true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img := &manifestOnlyImage{
+				manifest: &v1.Manifest{
+					Config: v1.Descriptor{MediaType: test.configMediaType},
+					Layers: layers,
+				},
+			}
+
+			err := checkRunnableImage(img)
+			if !test.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+
+			var artifactErr *ErrNotARunnableImage
+			if !errors.As(err, &artifactErr) {
+				t.Fatalf("expected ErrNotARunnableImage, got: %+v", err)
+			}
+			assert.Equal(t, test.configMediaType, artifactErr.ConfigMediaType)
+			assert.Equal(t, layers, artifactErr.Layers)
+		})
+	}
+}
+
+func TestImage_Label(t *testing.T) {
+	img := Image{
+		Metadata: Metadata{
+			Labels: map[string]string{
+				"maintainer":                          "someone@example.com",
+				"org.opencontainers.image.source":     "https://github.com/anchore/stereoscope",
+				"org.opencontainers.image.empty-kind": "",
+			},
+		},
+	}
+
+	value, ok := img.Label("org.opencontainers.image.source")
+	assert.True(t, ok)
+	assert.Equal(t, "https://github.com/anchore/stereoscope", value)
+
+	value, ok = img.Label("org.opencontainers.image.empty-kind")
+	assert.True(t, ok)
+	assert.Equal(t, "", value)
+
+	_, ok = img.Label("missing")
+	assert.False(t, ok)
+}
+
+func TestReadImageMetadata_Annotations(t *testing.T) {
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("could not create random image: %+v", err)
+	}
+
+	annotations := map[string]string{
+		"org.opencontainers.image.source":   "https://github.com/anchore/stereoscope",
+		"org.opencontainers.image.revision": "abc123",
+	}
+	withAnnotations := mutate.Annotations(base, annotations).(v1.Image)
+
+	tests := []struct {
+		name     string
+		image    v1.Image
+		expected map[string]string
+	}{
+		{
+			name:     "manifest annotations set",
+			image:    withAnnotations,
+			expected: annotations,
+		},
+		{
+			name:     "manifest annotations absent",
+			image:    base,
+			expected: map[string]string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metadata, err := readImageMetadata(test.image)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, metadata.Annotations)
+			// annotations are not config labels
+			assert.Empty(t, metadata.Labels)
+		})
+	}
+}
+
+func TestReadImageMetadata_Created(t *testing.T) {
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("could not create random image: %+v", err)
+	}
+
+	created := time.Date(2020, time.March, 4, 10, 11, 12, 0, time.UTC)
+	withCreated, err := mutate.CreatedAt(base, v1.Time{Time: created})
+	if err != nil {
+		t.Fatalf("could not set created time: %+v", err)
+	}
+
+	tests := []struct {
+		name     string
+		image    v1.Image
+		expected time.Time
+	}{
+		{
+			name:     "created timestamp set",
+			image:    withCreated,
+			expected: created,
+		},
+		{
+			name:     "created timestamp absent",
+			image:    base,
+			expected: time.Time{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metadata, err := readImageMetadata(test.image)
+			assert.NoError(t, err)
+			assert.True(t, test.expected.Equal(metadata.Created), "expected %s, got %s", test.expected, metadata.Created)
+			assert.Equal(t, test.expected.IsZero(), metadata.Created.IsZero())
+		})
+	}
+}
+
+func TestImage_Volumes(t *testing.T) {
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("could not create random image: %+v", err)
+	}
+
+	cfg, err := base.ConfigFile()
+	if err != nil {
+		t.Fatalf("could not get config: %+v", err)
+	}
+	cfg = cfg.DeepCopy()
+	cfg.Config.Volumes = map[string]struct{}{
+		"/var/lib/mysql": {},
+		"/data":          {},
+	}
+	withVolumes, err := mutate.ConfigFile(base, cfg)
+	if err != nil {
+		t.Fatalf("could not set config: %+v", err)
+	}
+
+	tests := []struct {
+		name     string
+		image    v1.Image
+		expected []string
+	}{
+		{
+			name:     "volumes declared",
+			image:    withVolumes,
+			expected: []string{"/data", "/var/lib/mysql"},
+		},
+		{
+			name:     "no volumes declared",
+			image:    base,
+			expected: []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metadata, err := readImageMetadata(test.image)
+			assert.NoError(t, err)
+
+			img := Image{Metadata: metadata}
+			assert.Equal(t, test.expected, img.Volumes())
+		})
+	}
+
+	assert.Equal(t, []string{}, (&Image{}).Volumes())
+}
+
+func TestImage_RuntimeConfig(t *testing.T) {
+	base, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("could not create random image: %+v", err)
+	}
+
+	cfg, err := base.ConfigFile()
+	if err != nil {
+		t.Fatalf("could not get config: %+v", err)
+	}
+	cfg = cfg.DeepCopy()
+	cfg.Config.ExposedPorts = map[string]struct{}{
+		"8080/tcp": {},
+		"53/udp":   {},
+	}
+	cfg.Config.Healthcheck = &v1.HealthConfig{
+		Test:     []string{"CMD-SHELL", "curl -f http://localhost:8080/ || exit 1"},
+		Interval: 30 * time.Second,
+		Retries:  3,
+	}
+	cfg.Config.StopSignal = "SIGQUIT"
+	cfg.Config.Shell = []string{"/bin/bash", "-c"}
+	cfg.Config.OnBuild = []string{"COPY . /app", "RUN make -C /app"}
+	withRuntimeConfig, err := mutate.ConfigFile(base, cfg)
+	if err != nil {
+		t.Fatalf("could not set config: %+v", err)
+	}
+
+	tests := []struct {
+		name     string
+		image    v1.Image
+		expected RuntimeConfig
+	}{
+		{
+			name:  "runtime config declared",
+			image: withRuntimeConfig,
+			expected: RuntimeConfig{
+				ExposedPorts: []string{"53/udp", "8080/tcp"},
+				Healthcheck: &v1.HealthConfig{
+					Test:     []string{"CMD-SHELL", "curl -f http://localhost:8080/ || exit 1"},
+					Interval: 30 * time.Second,
+					Retries:  3,
+				},
+				StopSignal: "SIGQUIT",
+				Shell:      []string{"/bin/bash", "-c"},
+				OnBuild:    []string{"COPY . /app", "RUN make -C /app"},
+			},
+		},
+		{
+			name:  "no runtime config declared",
+			image: base,
+			expected: RuntimeConfig{
+				Shell:   []string{},
+				OnBuild: []string{},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metadata, err := readImageMetadata(test.image)
+			assert.NoError(t, err)
+
+			img := Image{Metadata: metadata}
+			assert.Equal(t, test.expected, img.RuntimeConfig())
+		})
+	}
+}
+
+func TestReadImageMetadata_ManifestSchema(t *testing.T) {
+	dockerImage, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("could not create random image: %+v", err)
+	}
+
+	ociImage := mutate.ConfigMediaType(mutate.MediaType(dockerImage, types.OCIManifestSchema1), types.OCIConfigJSON)
+
+	tests := []struct {
+		name          string
+		image         v1.Image
+		expectedIsOCI bool
+	}{
+		{
+			name:          "docker v2 manifest",
+			image:         dockerImage,
+			expectedIsOCI: false,
+		},
+		{
+			name:          "oci manifest",
+			image:         ociImage,
+			expectedIsOCI: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metadata, err := readImageMetadata(test.image)
+			assert.NoError(t, err)
+			assert.Equal(t, int64(2), metadata.ManifestSchemaVersion)
+			assert.Equal(t, test.expectedIsOCI, metadata.IsOCI)
+		})
+	}
+}
+
+func TestImage_RawConfigAndManifest(t *testing.T) {
+	v1Image, err := random.Image(1024, 1)
+	require.NoError(t, err)
+
+	expectedConfig, err := v1Image.RawConfigFile()
+	require.NoError(t, err)
+	expectedManifest, err := v1Image.RawManifest()
+	require.NoError(t, err)
+
+	img := NewImage(v1Image, t.TempDir())
+	require.NoError(t, img.Read())
+	assert.Equal(t, expectedConfig, img.RawConfig())
+	assert.Equal(t, expectedManifest, img.RawManifest())
+
+	// the exact bytes given by the provider take precedence
+	providedManifest := []byte(`{"schemaVersion":2}`)
+	img = NewImage(v1Image, t.TempDir(), WithManifest(providedManifest))
+	require.NoError(t, img.Read())
+	assert.Equal(t, expectedConfig, img.RawConfig())
+	assert.Equal(t, providedManifest, img.RawManifest())
+}
+
+func TestIsOCIManifest(t *testing.T) {
+	assert.True(t, isOCIManifest(types.OCIManifestSchema1, &v1.Manifest{}))
+	assert.False(t, isOCIManifest(types.DockerManifestSchema2, &v1.Manifest{}))
+	assert.True(t, isOCIManifest("", &v1.Manifest{Config: v1.Descriptor{MediaType: types.OCIConfigJSON}}))
+	assert.False(t, isOCIManifest("", &v1.Manifest{Config: v1.Descriptor{MediaType: types.DockerConfigJSON}}))
+}
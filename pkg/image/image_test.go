@@ -1,14 +1,35 @@
 package image
 
 import (
+	"archive/tar"
+	"bytes"
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/anchore/stereoscope/internal"
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/anchore/stereoscope/pkg/filetree"
 	"github.com/go-test/deep"
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wagoodman/go-progress"
 )
 
 func TestImageAdditionalMetadata(t *testing.T) {
@@ -118,3 +139,2500 @@ func TestImage_SquashedTree(t *testing.T) {
 		}
 	})
 }
+
+func TestImage_SquashedTree_IgnoresWhiteoutMetadata(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest: "sha256:a",
+			paths:  []string{"/.wh..wh.aufs", "/.wh..wh.plnk/123.456", "/.wh..wh.orph/file", "/etc/hosts"},
+		},
+		testLayer{
+			digest: "sha256:b",
+			paths:  []string{"/.wh..wh.aufs", "/.wh..wh.plnk/789.012", "/app"},
+		},
+	)
+
+	sortedPaths := func(tree *filetree.FileTree) []file.Path {
+		paths := tree.AllRealPaths()
+		sort.Slice(paths, func(i, j int) bool { return paths[i] < paths[j] })
+		return paths
+	}
+
+	assert.Equal(t, []file.Path{"/", "/etc", "/etc/hosts"}, sortedPaths(img.Layers[0].SquashedTree))
+	assert.Equal(t, []file.Path{"/", "/app", "/etc", "/etc/hosts"}, sortedPaths(img.SquashedTree()))
+
+	// the layer diff tree is left as-is
+	assert.True(t, img.Layers[0].Tree.HasPath("/.wh..wh.aufs"))
+}
+
+// newTestImage creates an already-read image from the given layers, where each layer is described by its diffID,
+// the set of regular file paths it contains (with optional contents), and any symlinks.
+func newTestImage(t *testing.T, layers ...testLayer) *Image {
+	t.Helper()
+	img := &Image{
+		FileCatalog: NewFileCatalog(),
+	}
+	for idx, l := range layers {
+		layer := &Layer{
+			Metadata: LayerMetadata{
+				Index:  uint(idx),
+				Digest: l.digest,
+			},
+			Tree:        filetree.NewFileTree(),
+			fileCatalog: &img.FileCatalog,
+		}
+		for _, p := range l.paths {
+			ref, err := layer.Tree.AddFile(file.Path(p))
+			if err != nil {
+				t.Fatalf("could not add path=%q: %+v", p, err)
+			}
+			var opener file.Opener
+			contents, hasContents := l.contents[p]
+			if hasContents {
+				opener = func() io.ReadCloser {
+					return ioutil.NopCloser(strings.NewReader(contents))
+				}
+			}
+			img.FileCatalog.Add(*ref, file.Metadata{Path: p, Size: int64(len(contents)), Mode: l.modes[p]}, layer, opener)
+		}
+		for p, linkname := range l.links {
+			ref, err := layer.Tree.AddSymLink(file.Path(p), file.Path(linkname))
+			if err != nil {
+				t.Fatalf("could not add link=%q: %+v", p, err)
+			}
+			img.FileCatalog.Add(*ref, file.Metadata{Path: p, Linkname: linkname, TypeFlag: tar.TypeSymlink}, layer, nil)
+		}
+		img.Layers = append(img.Layers, layer)
+	}
+	if err := img.squash(&progress.Manual{}); err != nil {
+		t.Fatalf("could not squash image: %+v", err)
+	}
+	return img
+}
+
+type testLayer struct {
+	digest   string
+	paths    []string
+	contents map[string]string
+	modes    map[string]os.FileMode
+	// links are symlink paths to their (raw) link destinations
+	links map[string]string
+}
+
+func TestImage_ChangedReferencesSince(t *testing.T) {
+	baseLower := testLayer{
+		digest:   "sha256:a",
+		paths:    []string{"/etc/os-release", "/bin/sh"},
+		contents: map[string]string{"/etc/os-release": "3.15", "/bin/sh": "elf"},
+	}
+	baseUpper := testLayer{
+		digest:   "sha256:b",
+		paths:    []string{"/usr/lib/libc.so"},
+		contents: map[string]string{"/usr/lib/libc.so": "libc"},
+	}
+	base := newTestImage(t, baseLower, baseUpper)
+
+	tests := []struct {
+		name     string
+		image    *Image
+		expected []string
+	}{
+		{
+			name: "derived image only reports upper layers",
+			image: newTestImage(t, baseLower, baseUpper,
+				testLayer{
+					digest:   "sha256:c",
+					paths:    []string{"/app/main", "/etc/os-release"},
+					contents: map[string]string{"/app/main": "app", "/etc/os-release": "3.16"},
+				},
+			),
+			expected: []string{"/app/main", "/etc/os-release"},
+		},
+		{
+			name: "files rewritten with unchanged contents are not reported",
+			image: newTestImage(t, baseLower, baseUpper,
+				testLayer{
+					digest:   "sha256:c",
+					paths:    []string{"/app/main", "/etc/os-release", "/bin/sh"},
+					contents: map[string]string{"/app/main": "app", "/etc/os-release": "3.15", "/bin/sh": "elf"},
+				},
+			),
+			expected: []string{"/app/main"},
+		},
+		{
+			name: "divergent lower layer reports changes above the divergence",
+			image: newTestImage(t, baseLower,
+				testLayer{
+					digest:   "sha256:x",
+					paths:    []string{"/usr/lib/libc.so", "/usr/lib/libm.so"},
+					contents: map[string]string{"/usr/lib/libc.so": "libc", "/usr/lib/libm.so": "libm"},
+				},
+			),
+			expected: []string{"/usr/lib/libm.so"},
+		},
+		{
+			name:     "identical image reports nothing",
+			image:    newTestImage(t, baseLower, baseUpper),
+			expected: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			refs, err := test.image.ChangedReferencesSince(base)
+			assert.NoError(t, err)
+
+			var actual []string
+			for _, ref := range refs {
+				actual = append(actual, string(ref.RealPath))
+			}
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+
+	_, err := base.ChangedReferencesSince(nil)
+	assert.Error(t, err)
+}
+
+func TestImage_FilesNotIn(t *testing.T) {
+	baseLayer := testLayer{
+		digest:   "sha256:base",
+		paths:    []string{"/etc/os-release", "/bin/busybox", "/etc/hosts"},
+		contents: map[string]string{"/etc/os-release": "3.15", "/bin/busybox": "elf", "/etc/hosts": "localhost"},
+		links:    map[string]string{"/bin/sh": "busybox", "/usr/bin/env": "../../bin/busybox"},
+	}
+	base := newTestImage(t, baseLayer)
+
+	tests := []struct {
+		name     string
+		image    *Image
+		expected []file.Path
+	}{
+		{
+			name: "built on the base image",
+			image: newTestImage(t, baseLayer, testLayer{
+				digest:   "sha256:app",
+				paths:    []string{"/app/main", "/etc/hosts"},
+				contents: map[string]string{"/app/main": "elf", "/etc/hosts": "localhost"},
+			}),
+			// the hosts file was overwritten with the same contents
+			expected: []file.Path{"/app/main"},
+		},
+		{
+			name: "built on a rebuilt base image",
+			image: newTestImage(t, testLayer{
+				digest:   "sha256:rebuilt",
+				paths:    []string{"/etc/os-release", "/bin/busybox", "/etc/hosts", "/app/main"},
+				contents: map[string]string{"/etc/os-release": "3.16", "/bin/busybox": "elf", "/etc/hosts": "localhost", "/app/main": "elf"},
+				links:    map[string]string{"/bin/sh": "busybox", "/usr/bin/env": "/bin/busybox"},
+			}),
+			expected: []file.Path{"/app/main", "/etc/os-release", "/usr/bin/env"},
+		},
+		{
+			name:  "same image",
+			image: base,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			refs, err := test.image.FilesNotIn(base)
+			require.NoError(t, err)
+
+			var actual []file.Path
+			for _, ref := range refs {
+				actual = append(actual, ref.RealPath)
+			}
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+
+	_, err := base.FilesNotIn(nil)
+	assert.Error(t, err)
+}
+
+func TestImage_CompressedSize(t *testing.T) {
+	img := Image{
+		Metadata: Metadata{
+			Size: 1000,
+		},
+		Layers: []*Layer{
+			{Metadata: LayerMetadata{Size: 600, CompressedSize: 200}},
+			{Metadata: LayerMetadata{Size: 400, CompressedSize: 150}},
+		},
+	}
+
+	assert.Equal(t, int64(350), img.CompressedSize())
+	assert.Equal(t, int64(1000), img.Metadata.Size)
+}
+
+func TestImage_WithMaxLayers(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxLayers int
+		declared  int
+		wantErr   bool
+	}{
+		{
+			name:      "unlimited by default",
+			maxLayers: 0,
+			declared:  1000,
+		},
+		{
+			name:      "under the limit",
+			maxLayers: 5,
+			declared:  5,
+		},
+		{
+			name:      "over the limit",
+			maxLayers: 5,
+			declared:  6,
+			wantErr:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img := NewImage(nil, "", WithMaxLayers(test.maxLayers))
+			img.Metadata.Config.RootFS.DiffIDs = make([]v1.Hash, test.declared)
+
+			assert.NoError(t, img.applyOverrideMetadata())
+
+			err := img.checkLayerLimit()
+			if test.wantErr {
+				assert.EqualError(t, err, fmt.Sprintf("image declares %d layers which exceeds the maximum allowed layer count of %d", test.declared, test.maxLayers))
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestImage_AddMetadata(t *testing.T) {
+	v1Image, err := random.Image(1024, 2)
+	require.NoError(t, err)
+
+	var applied []string
+	record := func(name string) AdditionalMetadata {
+		return func(*Image) error {
+			applied = append(applied, name)
+			return nil
+		}
+	}
+
+	img := NewImage(v1Image, t.TempDir(), record("provider"))
+	img.AddMetadata(record("user"), WithMaxLayers(1))
+
+	// added options are applied after the existing options when the image is read
+	assert.Error(t, img.Read())
+	assert.Equal(t, []string{"provider", "user"}, applied)
+}
+
+func TestImage_FileReferenceFromSquash(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{digest: "sha256:a", paths: []string{"/etc/os-release", "/bin/sh"}},
+		testLayer{digest: "sha256:b", paths: []string{"/etc/os-release"}},
+	)
+
+	tests := []struct {
+		path          file.Path
+		expectedLayer uint
+		wantErr       bool
+	}{
+		{path: "/bin/sh", expectedLayer: 0},
+		{path: "/etc/os-release", expectedLayer: 1},
+		{path: "/missing", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.path), func(t *testing.T) {
+			ref, layerIndex, err := img.FileReferenceFromSquash(test.path)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.path, ref.RealPath)
+			assert.Equal(t, test.expectedLayer, layerIndex)
+		})
+	}
+}
+
+func TestImage_WithMemoryThreshold(t *testing.T) {
+	v1Image, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatalf("could not create random image: %+v", err)
+	}
+
+	tests := []struct {
+		name           string
+		threshold      int64
+		expectInMemory bool
+	}{
+		{
+			name:           "disabled by default",
+			threshold:      0,
+			expectInMemory: false,
+		},
+		{
+			name:           "files under the threshold are kept in memory",
+			threshold:      4096,
+			expectInMemory: true,
+		},
+		{
+			name:           "files over the threshold are read from disk",
+			threshold:      10,
+			expectInMemory: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cacheDir := t.TempDir()
+			img := NewImage(v1Image, cacheDir, WithMemoryThreshold(test.threshold))
+			if err := img.Read(); err != nil {
+				t.Fatalf("could not read image: %+v", err)
+			}
+
+			refs := img.SquashedTree().AllFiles()
+			assert.NotEmpty(t, refs)
+
+			// remove the layer tar cache; only in-memory contents should remain readable
+			assert.NoError(t, os.RemoveAll(cacheDir))
+
+			for _, ref := range refs {
+				reader, err := img.FileContentsByRef(ref)
+				assert.NoError(t, err)
+				contents, err := ioutil.ReadAll(reader)
+				if test.expectInMemory {
+					assert.NoError(t, err)
+					assert.Len(t, contents, 1024)
+				} else {
+					assert.Error(t, err)
+				}
+			}
+		})
+	}
+}
+
+func TestImage_UniqueContentSize(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest: "sha256:a",
+			paths:  []string{"/bin/busybox", "/bin/sh", "/etc/motd"},
+			contents: map[string]string{
+				"/bin/busybox": "busybox-binary",
+				"/bin/sh":      "busybox-binary",
+				"/etc/motd":    "welcome",
+			},
+		},
+		testLayer{
+			digest: "sha256:b",
+			paths:  []string{"/etc/motd"},
+			contents: map[string]string{
+				"/etc/motd": "hi",
+			},
+		},
+	)
+
+	size, err := img.UniqueContentSize()
+	assert.NoError(t, err)
+	// the duplicate busybox binary is only counted once and the overwritten motd is not counted at all
+	assert.Equal(t, int64(len("busybox-binary")+len("hi")), size)
+}
+
+// newTarLayer creates a layer with a single regular file entry for each of the given tar header names and contents
+// (or a directory entry for names with a trailing slash).
+func newTarLayer(t testing.TB, files map[string]string) v1.Layer {
+	t.Helper()
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := &bytes.Buffer{}
+	writer := tar.NewWriter(buf)
+	for _, name := range names {
+		contents := files[name]
+		header := &tar.Header{
+			Name:     name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(contents)),
+		}
+		if strings.HasSuffix(name, "/") {
+			header.Typeflag = tar.TypeDir
+			header.Mode = 0755
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatalf("could not write tar header: %+v", err)
+		}
+		if _, err := writer.Write([]byte(contents)); err != nil {
+			t.Fatalf("could not write tar contents: %+v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("could not close tar writer: %+v", err)
+	}
+
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatalf("could not create layer: %+v", err)
+	}
+	return layer
+}
+
+func TestImage_WithHeaderTransform(t *testing.T) {
+	v1Image, err := mutate.AppendLayers(empty.Image, newTarLayer(t, map[string]string{
+		"rootfs/etc/os-release": "ID=alpine",
+		"rootfs/bin/busybox":    "busybox-binary",
+		"rootfs/tmp/scratch":    "ignore me",
+	}))
+	if err != nil {
+		t.Fatalf("could not create image: %+v", err)
+	}
+
+	transform := func(header *tar.Header) (*tar.Header, bool) {
+		if strings.HasPrefix(header.Name, "rootfs/tmp/") {
+			return nil, false
+		}
+		header.Name = strings.TrimPrefix(header.Name, "rootfs/")
+		header.Uid = 1000
+		return header, true
+	}
+
+	img := NewImage(v1Image, t.TempDir(), WithHeaderTransform(transform))
+	if err := img.Read(); err != nil {
+		t.Fatalf("could not read image: %+v", err)
+	}
+
+	var paths []string
+	for _, ref := range img.SquashedTree().AllFiles(file.TypeReg) {
+		paths = append(paths, string(ref.RealPath))
+
+		entry, err := img.FileCatalog.Get(ref)
+		assert.NoError(t, err)
+		assert.Equal(t, 1000, entry.Metadata.UserID)
+	}
+	sort.Strings(paths)
+	assert.Equal(t, []string{"/bin/busybox", "/etc/os-release"}, paths)
+
+	// contents are still read from the original tar entry
+	reader, err := img.FileContentsFromSquash("/etc/os-release")
+	assert.NoError(t, err)
+	contents, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "ID=alpine", string(contents))
+}
+
+func TestImage_WithIgnorePaths(t *testing.T) {
+	v1Image, err := mutate.AppendLayers(empty.Image,
+		newTarLayer(t, map[string]string{
+			"etc/os-release":         "ID=alpine",
+			"proc/1/status":          "running",
+			"var/cache/apk/index":    "index",
+			"var/lib/apk/db/scripts": "scripts",
+			"usr/lib/python/a.pyc":   "bytecode",
+			"usr/lib/python/a.py":    "source",
+		}),
+		newTarLayer(t, map[string]string{
+			// whiteouts are honored for surviving files, and are a no-op for ignored files
+			"var/lib/apk/db/.wh.scripts": "",
+			"var/cache/.wh.apk":          "",
+		}),
+	)
+	if err != nil {
+		t.Fatalf("could not create image: %+v", err)
+	}
+
+	img := NewImage(v1Image, t.TempDir(), WithIgnorePaths("/proc", "var/cache", "**/*.pyc"))
+	if err := img.Read(); err != nil {
+		t.Fatalf("could not read image: %+v", err)
+	}
+
+	var paths []string
+	for _, ref := range img.SquashedTree().AllFiles(file.TypeReg) {
+		paths = append(paths, string(ref.RealPath))
+	}
+	sort.Strings(paths)
+	assert.Equal(t, []string{"/etc/os-release", "/usr/lib/python/a.py"}, paths)
+
+	for _, layer := range img.Layers {
+		for _, ref := range layer.Tree.AllFiles(file.AllTypes...) {
+			assert.NotContains(t, string(ref.RealPath), "/proc")
+			assert.NotContains(t, string(ref.RealPath), "/var/cache")
+		}
+	}
+
+	// whiteouts for ignored paths are dropped from the upper layer, while other whiteouts are kept
+	assert.False(t, img.Layers[1].Tree.HasPath("/var/cache/.wh.apk"))
+	assert.True(t, img.Layers[1].Tree.HasPath("/var/lib/apk/db/.wh.scripts"))
+}
+
+func TestWithIgnorePaths_InvalidPattern(t *testing.T) {
+	img := &Image{}
+	assert.Error(t, WithIgnorePaths("/var/[cache")(img))
+}
+
+func TestImage_WithInternedPaths(t *testing.T) {
+	v1Image, err := mutate.AppendLayers(empty.Image,
+		newTarLayer(t, map[string]string{
+			"usr/lib/node_modules/a/index.js": "a",
+			"usr/lib/node_modules/b/index.js": "b",
+		}),
+		newTarLayer(t, map[string]string{
+			"usr/lib/node_modules/a/index.js": "a2",
+			"usr/lib/node_modules/c/index.js": "c",
+		}),
+	)
+	if err != nil {
+		t.Fatalf("could not create image: %+v", err)
+	}
+
+	expected := NewImage(v1Image, t.TempDir())
+	if err := expected.Read(); err != nil {
+		t.Fatalf("could not read image: %+v", err)
+	}
+
+	img := NewImage(v1Image, t.TempDir(), WithInternedPaths())
+	if err := img.Read(); err != nil {
+		t.Fatalf("could not read image: %+v", err)
+	}
+
+	assert.True(t, expected.SquashedTree().Equal(img.SquashedTree()))
+	for idx := range img.Layers {
+		assert.True(t, expected.Layers[idx].Tree.Equal(img.Layers[idx].Tree))
+	}
+	// all layer trees, squash trees, and catalog entries share the same set of distinct paths
+	assert.Equal(t, len(img.SquashedTree().AllRealPaths()), img.pathInterner.Len())
+
+	contents, err := img.ReadFile("/usr/lib/node_modules/a/index.js")
+	assert.NoError(t, err)
+	assert.Equal(t, "a2", string(contents))
+}
+
+func TestImage_BrokenHardlinks(t *testing.T) {
+	newLayer := func(t *testing.T, headers ...*tar.Header) v1.Layer {
+		buf := &bytes.Buffer{}
+		writer := tar.NewWriter(buf)
+		for _, header := range headers {
+			require.NoError(t, writer.WriteHeader(header))
+			if header.Size > 0 {
+				_, err := writer.Write(bytes.Repeat([]byte("x"), int(header.Size)))
+				require.NoError(t, err)
+			}
+		}
+		require.NoError(t, writer.Close())
+
+		layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+		})
+		require.NoError(t, err)
+		return layer
+	}
+
+	tests := []struct {
+		name          string
+		headers       []*tar.Header
+		options       []AdditionalMetadata
+		wantErr       *ErrBrokenHardlink
+		expectedPaths []file.Path
+	}{
+		{
+			name: "valid hardlink",
+			headers: []*tar.Header{
+				{Name: "usr/bin/perl", Typeflag: tar.TypeReg, Mode: 0755, Size: 3},
+				{Name: "usr/bin/perl5", Typeflag: tar.TypeLink, Linkname: "usr/bin/perl"},
+			},
+			expectedPaths: []file.Path{"/usr/bin/perl", "/usr/bin/perl5"},
+		},
+		{
+			name: "forward hardlink reference",
+			headers: []*tar.Header{
+				{Name: "usr/bin/perl5", Typeflag: tar.TypeLink, Linkname: "usr/bin/perl"},
+				{Name: "usr/bin/perl", Typeflag: tar.TypeReg, Mode: 0755, Size: 3},
+			},
+			options: []AdditionalMetadata{WithStrictHardlinks()},
+			wantErr: &ErrBrokenHardlink{Path: "/usr/bin/perl5", Target: "/usr/bin/perl"},
+		},
+		{
+			name: "missing hardlink target",
+			headers: []*tar.Header{
+				{Name: "usr/bin/perl5", Typeflag: tar.TypeLink, Linkname: "./usr/bin/missing"},
+			},
+			options: []AdditionalMetadata{WithStrictHardlinks()},
+			wantErr: &ErrBrokenHardlink{Path: "/usr/bin/perl5", Target: "/usr/bin/missing"},
+		},
+		{
+			name: "broken hardlinks are skipped by default",
+			headers: []*tar.Header{
+				{Name: "usr/bin/perl5", Typeflag: tar.TypeLink, Linkname: "usr/bin/perl"},
+				{Name: "usr/bin/perl", Typeflag: tar.TypeReg, Mode: 0755, Size: 3},
+			},
+			expectedPaths: []file.Path{"/usr/bin/perl"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v1Image, err := mutate.AppendLayers(empty.Image, newLayer(t, test.headers...))
+			require.NoError(t, err)
+
+			img := NewImage(v1Image, t.TempDir(), test.options...)
+			err = img.Read()
+			if test.wantErr != nil {
+				var brokenErr *ErrBrokenHardlink
+				require.True(t, errors.As(err, &brokenErr), "expected ErrBrokenHardlink, got: %+v", err)
+				assert.Equal(t, test.wantErr, brokenErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var actual []file.Path
+			for _, ref := range img.SquashedTree().AllFiles(file.TypeReg, file.TypeHardLink) {
+				actual = append(actual, ref.RealPath)
+			}
+			assert.ElementsMatch(t, test.expectedPaths, actual)
+		})
+	}
+}
+
+func TestLayer_OpenTar(t *testing.T) {
+	v1Image, err := mutate.AppendLayers(empty.Image, newTarLayer(t, map[string]string{
+		"etc/os-release": "ID=alpine",
+		"bin/busybox":    "busybox-binary",
+	}))
+	if err != nil {
+		t.Fatalf("could not create image: %+v", err)
+	}
+
+	img := NewImage(v1Image, t.TempDir())
+	if err := img.Read(); err != nil {
+		t.Fatalf("could not read image: %+v", err)
+	}
+
+	readTar := func() map[string]string {
+		reader, err := img.Layers[0].OpenTar()
+		require.NoError(t, err)
+		defer reader.Close()
+
+		contents := make(map[string]string)
+		tr := tar.NewReader(reader)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			require.NoError(t, err)
+			b, err := ioutil.ReadAll(tr)
+			require.NoError(t, err)
+			contents[header.Name] = string(b)
+		}
+		return contents
+	}
+
+	expected := map[string]string{
+		"etc/os-release": "ID=alpine",
+		"bin/busybox":    "busybox-binary",
+	}
+	assert.Equal(t, expected, readTar())
+
+	// the tar is re-fetched after the content has been released
+	require.NoError(t, img.ReleaseContent())
+	assert.Equal(t, expected, readTar())
+}
+
+func TestLayer_DecompressionProgress(t *testing.T) {
+	tests := []struct {
+		name           string
+		threshold      int64
+		compressedSize int64
+		expectProgress bool
+	}{
+		{
+			name:           "small layer with default threshold",
+			compressedSize: 1024,
+		},
+		{
+			name:           "large layer with default threshold",
+			compressedSize: DefaultDecompressionProgressThreshold,
+			expectProgress: true,
+		},
+		{
+			name:           "layer above custom threshold",
+			threshold:      10,
+			compressedSize: 20,
+			expectProgress: true,
+		},
+		{
+			name:           "disabled",
+			threshold:      -1,
+			compressedSize: DefaultDecompressionProgressThreshold,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			layer := &Layer{
+				Metadata:                       LayerMetadata{CompressedSize: test.compressedSize},
+				decompressionProgressThreshold: test.threshold,
+			}
+			monitor := layer.trackDecompressionProgress()
+			if !test.expectProgress {
+				assert.Nil(t, monitor)
+				return
+			}
+			require.NotNil(t, monitor)
+
+			contents := "some layer content"
+			b, err := ioutil.ReadAll(internal.NewProgressReader(strings.NewReader(contents), monitor))
+			require.NoError(t, err)
+			assert.Equal(t, contents, string(b))
+			assert.Equal(t, int64(len(contents)), monitor.Current())
+		})
+	}
+}
+
+func TestImage_RepeatedLayer(t *testing.T) {
+	base := newTarLayer(t, map[string]string{
+		"etc/config": "base",
+	})
+	override := newTarLayer(t, map[string]string{
+		"etc/config": "override",
+	})
+
+	// the base layer appears twice (same diffID at two positions)
+	v1Image, err := mutate.AppendLayers(empty.Image, base, override, base)
+	if err != nil {
+		t.Fatalf("could not create image: %+v", err)
+	}
+
+	img := NewImage(v1Image, t.TempDir(), WithMemoryThreshold(0))
+	if err := img.Read(); err != nil {
+		t.Fatalf("could not read image: %+v", err)
+	}
+	require.Len(t, img.Layers, 3)
+	assert.Equal(t, img.Layers[0].Metadata.Digest, img.Layers[2].Metadata.Digest)
+	assert.Empty(t, img.Validate())
+
+	readLayerSquash := func(idx int) string {
+		reader, err := img.Layers[idx].FileContentsFromSquash("/etc/config")
+		require.NoError(t, err)
+		defer reader.Close()
+		contents, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		return string(contents)
+	}
+
+	assertContents := func() {
+		assert.Equal(t, "base", readLayerSquash(0))
+		assert.Equal(t, "override", readLayerSquash(1))
+		assert.Equal(t, "base", readLayerSquash(2))
+
+		ref, layerIndex, err := img.FileReferenceFromSquash("/etc/config")
+		require.NoError(t, err)
+		assert.Equal(t, uint(2), layerIndex)
+
+		contents, err := img.FileContentsByRef(*ref)
+		require.NoError(t, err)
+		defer contents.Close()
+		b, err := ioutil.ReadAll(contents)
+		require.NoError(t, err)
+		assert.Equal(t, "base", string(b))
+	}
+
+	assertContents()
+
+	// the layer tar cache is shared by both positions, so releasing and re-fetching must work for either
+	require.NoError(t, img.ReleaseContent())
+	assertContents()
+
+	// both positions may re-fetch the shared cache at the same time
+	require.NoError(t, img.ReleaseContent())
+	var wg sync.WaitGroup
+	results := make([]string, 3)
+	for _, idx := range []int{0, 2} {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			reader, err := img.Layers[idx].FileContentsFromSquash("/etc/config")
+			if err != nil {
+				return
+			}
+			defer reader.Close()
+			contents, _ := ioutil.ReadAll(reader)
+			results[idx] = string(contents)
+		}(idx)
+	}
+	wg.Wait()
+	assert.Equal(t, "base", results[0])
+	assert.Equal(t, "base", results[2])
+}
+
+func TestImage_WithRetainCompressedLayers(t *testing.T) {
+	v1Image, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatalf("could not create random image: %+v", err)
+	}
+
+	cacheDir := t.TempDir()
+	img := NewImage(v1Image, cacheDir, WithRetainCompressedLayers())
+	if err := img.Read(); err != nil {
+		t.Fatalf("could not read image: %+v", err)
+	}
+
+	v1Layers, err := v1Image.Layers()
+	if err != nil {
+		t.Fatalf("could not get layers: %+v", err)
+	}
+
+	for idx, layer := range img.Layers {
+		assert.NotEmpty(t, layer.compressedBlobPath)
+		assert.FileExists(t, layer.compressedBlobPath)
+
+		expected, err := v1Layers[idx].Digest()
+		if err != nil {
+			t.Fatalf("could not get layer digest: %+v", err)
+		}
+
+		reader, err := layer.CompressedContent()
+		if err != nil {
+			t.Fatalf("could not get compressed content: %+v", err)
+		}
+		actual, _, err := v1.SHA256(reader)
+		assert.NoError(t, err)
+		assert.NoError(t, reader.Close())
+		assert.Equal(t, expected, actual)
+	}
+}
+
+func TestImage_FileContentsFromSquash_NormalizesPath(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest:   "sha256:a",
+			paths:    []string{"/etc/passwd"},
+			contents: map[string]string{"/etc/passwd": "root:x:0:0"},
+		},
+	)
+
+	for _, p := range []file.Path{"/etc/passwd", "/etc/./passwd", "/etc/passwd/", "etc/passwd", "/var/../etc/passwd"} {
+		t.Run(string(p), func(t *testing.T) {
+			reader, err := img.FileContentsFromSquash(p)
+			if err != nil {
+				t.Fatalf("could not get contents: %+v", err)
+			}
+			contents, err := ioutil.ReadAll(reader)
+			assert.NoError(t, err)
+			assert.Equal(t, "root:x:0:0", string(contents))
+
+			ref, _, err := img.FileReferenceFromSquash(p)
+			assert.NoError(t, err)
+			assert.Equal(t, file.Path("/etc/passwd"), ref.RealPath)
+		})
+	}
+
+	_, err := img.FileContentsFromSquash("etc/./shadow")
+	assert.EqualError(t, err, `could not find file path in Tree: /etc/shadow (normalized from "etc/./shadow")`)
+}
+
+func TestImage_ReleaseContent(t *testing.T) {
+	v1Image, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatalf("could not create random image: %+v", err)
+	}
+
+	cacheDir := t.TempDir()
+	img := NewImage(v1Image, cacheDir, WithMemoryThreshold(4096))
+	if err := img.Read(); err != nil {
+		t.Fatalf("could not read image: %+v", err)
+	}
+
+	refs := img.SquashedTree().AllFiles()
+	assert.NotEmpty(t, refs)
+	for _, layer := range img.Layers {
+		assert.NotEmpty(t, layer.inMemoryContent)
+	}
+
+	expected := make(map[file.ID][]byte)
+	for _, ref := range refs {
+		reader, err := img.FileContentsByRef(ref)
+		assert.NoError(t, err)
+		expected[ref.ID()], err = ioutil.ReadAll(reader)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, img.ReleaseContent())
+
+	for _, layer := range img.Layers {
+		assert.Nil(t, layer.inMemoryContent)
+		assert.NoFileExists(t, path.Join(cacheDir, layer.Metadata.Digest+".tar"))
+	}
+
+	// metadata is still available without content
+	assert.Len(t, img.SquashedTree().AllFiles(), len(refs))
+
+	// content is re-fetched from the image source on demand
+	for _, ref := range refs {
+		reader, err := img.FileContentsByRef(ref)
+		assert.NoError(t, err)
+		actual, err := ioutil.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, expected[ref.ID()], actual)
+	}
+}
+
+func TestImage_TypedErrors(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest: "sha256:a",
+			paths:  []string{"/no-contents"},
+		},
+	)
+
+	_, err := img.FileContentsFromSquash("/missing")
+	assert.True(t, errors.Is(err, ErrPathNotFound), "expected ErrPathNotFound, got: %+v", err)
+	assert.EqualError(t, err, "could not find file path in Tree: /missing")
+
+	_, err = img.FileContentsFromSquash("/no-contents")
+	assert.True(t, errors.Is(err, ErrContentNotCached), "expected ErrContentNotCached, got: %+v", err)
+
+	_, err = img.FileContentsByRef(*file.NewFileReference("/not-cataloged"))
+	assert.True(t, errors.Is(err, ErrFileNotFound), "expected ErrFileNotFound, got: %+v", err)
+
+	ref, _, err := img.FileReferenceFromSquash("/no-contents")
+	assert.NoError(t, err)
+
+	_, err = img.ResolveLinkByLayerSquash(*ref, 1)
+	assert.True(t, errors.Is(err, ErrLayerOutOfRange), "expected ErrLayerOutOfRange, got: %+v", err)
+
+	_, err = img.ResolveLinkByLayerSquash(*ref, -1)
+	assert.True(t, errors.Is(err, ErrLayerOutOfRange), "expected ErrLayerOutOfRange, got: %+v", err)
+
+	_, err = (&Image{}).ResolveLinkByImageSquash(*ref)
+	assert.True(t, errors.Is(err, ErrLayerOutOfRange), "expected ErrLayerOutOfRange, got: %+v", err)
+}
+
+func TestImage_PrivilegedFiles(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest: "sha256:a",
+			paths:  []string{"/usr/bin/passwd", "/usr/bin/wall", "/usr/bin/ls", "/tmp/marker", "/usr/bin/sudo"},
+			modes: map[string]os.FileMode{
+				"/usr/bin/passwd": 0755 | os.ModeSetuid,
+				"/usr/bin/wall":   0755 | os.ModeSetgid,
+				"/usr/bin/ls":     0755,
+				"/tmp/marker":     0644 | os.ModeSticky,
+				"/usr/bin/sudo":   0755 | os.ModeSetuid | os.ModeSetgid,
+			},
+		},
+		testLayer{
+			// privileges dropped in an upper layer are not reported
+			digest: "sha256:b",
+			paths:  []string{"/usr/bin/sudo"},
+			modes: map[string]os.FileMode{
+				"/usr/bin/sudo": 0755,
+			},
+		},
+	)
+
+	results, err := img.PrivilegedFiles()
+	assert.NoError(t, err)
+
+	var actual []string
+	for _, r := range results {
+		actual = append(actual, fmt.Sprintf("%s setuid=%v setgid=%v sticky=%v", r.Reference.RealPath, r.IsSetuid(), r.IsSetgid(), r.IsSticky()))
+	}
+
+	assert.Equal(t, []string{
+		"/tmp/marker setuid=false setgid=false sticky=true",
+		"/usr/bin/passwd setuid=true setgid=false sticky=false",
+		"/usr/bin/wall setuid=false setgid=true sticky=false",
+	}, actual)
+}
+
+// foreignLayer is a v1.Layer that is described as a foreign (non-distributable) layer.
+type foreignLayer struct {
+	v1.Layer
+	urls []string
+}
+
+func (l *foreignLayer) MediaType() (types.MediaType, error) {
+	return types.DockerForeignLayer, nil
+}
+
+func (l *foreignLayer) Descriptor() (*v1.Descriptor, error) {
+	digest, err := l.Digest()
+	if err != nil {
+		return nil, err
+	}
+	size, err := l.Size()
+	if err != nil {
+		return nil, err
+	}
+	return &v1.Descriptor{
+		MediaType: types.DockerForeignLayer,
+		Digest:    digest,
+		Size:      size,
+		URLs:      l.urls,
+	}, nil
+}
+
+func TestImage_WithForeignLayerPolicy(t *testing.T) {
+	urls := []string{"https://example.com/foreign-layer.tar.gz"}
+	v1Image, err := mutate.AppendLayers(empty.Image,
+		&foreignLayer{
+			Layer: newTarLayer(t, map[string]string{"Files/Windows/System32/kernel32.dll": "foreign contents"}),
+			urls:  urls,
+		},
+		newTarLayer(t, map[string]string{"app/server.exe": "app contents"}),
+	)
+	if err != nil {
+		t.Fatalf("could not create image: %+v", err)
+	}
+
+	tests := []struct {
+		name          string
+		options       []AdditionalMetadata
+		expectedErr   error
+		expectedPaths []string
+	}{
+		{
+			name:          "foreign layers are read by default",
+			expectedPaths: []string{"/Files/Windows/System32/kernel32.dll", "/app/server.exe"},
+		},
+		{
+			name:          "fetch foreign layers",
+			options:       []AdditionalMetadata{WithForeignLayerPolicy(FetchForeignLayers)},
+			expectedPaths: []string{"/Files/Windows/System32/kernel32.dll", "/app/server.exe"},
+		},
+		{
+			name:        "reject foreign layers",
+			options:     []AdditionalMetadata{WithForeignLayerPolicy(RejectForeignLayers)},
+			expectedErr: ErrForeignLayer,
+		},
+		{
+			name:          "skip foreign layers",
+			options:       []AdditionalMetadata{WithForeignLayerPolicy(SkipForeignLayers)},
+			expectedPaths: []string{"/app/server.exe"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img := NewImage(v1Image, t.TempDir(), test.options...)
+			err := img.Read()
+			if test.expectedErr != nil {
+				assert.True(t, errors.Is(err, test.expectedErr), "expected %v, got: %+v", test.expectedErr, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			assert.True(t, img.Layers[0].Metadata.Foreign)
+			assert.Equal(t, urls, img.Layers[0].Metadata.URLs)
+			assert.False(t, img.Layers[1].Metadata.Foreign)
+
+			var paths []string
+			for _, ref := range img.SquashedTree().AllFiles() {
+				paths = append(paths, string(ref.RealPath))
+			}
+			sort.Strings(paths)
+			assert.Equal(t, test.expectedPaths, paths)
+		})
+	}
+}
+
+func TestImage_Symlinks(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest: "sha256:a",
+			paths:  []string{"/usr/bin/busybox", "/usr/lib/libc.so"},
+			links: map[string]string{
+				"/bin":           "/usr/bin",
+				"/usr/bin/sh":    "busybox",
+				"/lib":           "usr/lib",
+				"/usr/bin/stale": "../../missing",
+			},
+		},
+		testLayer{
+			// links may be replaced in upper layers
+			digest: "sha256:b",
+			paths:  []string{"/usr/bin/stale"},
+		},
+	)
+
+	results, err := img.Symlinks()
+	assert.NoError(t, err)
+
+	var actual []string
+	for _, r := range results {
+		actual = append(actual, fmt.Sprintf("%s -> %s", r.Reference.RealPath, r.Linkname))
+	}
+
+	assert.Equal(t, []string{
+		"/bin -> /usr/bin",
+		"/lib -> usr/lib",
+		"/usr/bin/sh -> busybox",
+	}, actual)
+}
+
+func TestImage_CanonicalPath(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest: "sha256:a",
+			paths:  []string{"/usr/lib/x86_64/libc.so", "/usr/bin/busybox"},
+			links: map[string]string{
+				"/lib":             "usr/lib",
+				"/usr/lib64":       "/usr/lib/x86_64",
+				"/usr/lib/current": "../lib64",
+				"/bin":             "/usr/bin",
+				"/usr/bin/sh":      "busybox",
+			},
+		},
+	)
+
+	tests := []struct {
+		path     file.Path
+		expected file.Path
+		notFound bool
+	}{
+		{path: "/usr/lib/x86_64/libc.so", expected: "/usr/lib/x86_64/libc.so"},
+		{path: "/lib/x86_64/libc.so", expected: "/usr/lib/x86_64/libc.so"},
+		{path: "/usr/lib64/libc.so", expected: "/usr/lib/x86_64/libc.so"},
+		// multiple levels of directory links
+		{path: "/lib/current/libc.so", expected: "/usr/lib/x86_64/libc.so"},
+		{path: "lib//x86_64/./libc.so", expected: "/usr/lib/x86_64/libc.so"},
+		// basename links are not followed
+		{path: "/bin/sh", expected: "/usr/bin/sh"},
+		{path: "/lib", expected: "/lib"},
+		{path: "/lib/missing", notFound: true},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.path), func(t *testing.T) {
+			actual, err := img.CanonicalPath(test.path)
+			if test.notFound {
+				assert.True(t, errors.Is(err, ErrPathNotFound), "expected ErrPathNotFound, got: %+v", err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+func TestFileCatalog_WriteNDJSON(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest:   "sha256:a",
+			paths:    []string{"/etc/hosts", "/bin/busybox"},
+			contents: map[string]string{"/etc/hosts": "localhost", "/bin/busybox": "elf"},
+			modes:    map[string]os.FileMode{"/bin/busybox": 0755},
+			links:    map[string]string{"/bin/sh": "busybox"},
+		},
+		testLayer{
+			digest:   "sha256:b",
+			paths:    []string{"/etc/hosts"},
+			contents: map[string]string{"/etc/hosts": "other"},
+		},
+	)
+
+	digestOf := func(contents string) string {
+		return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(contents)))
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, img.FileCatalog.WriteNDJSON(&buf))
+
+	expected := []string{
+		`{"path":"/bin/busybox","layer":"sha256:a","layerIndex":0,"digest":"` + digestOf("elf") + `","size":3,"mode":"-rwxr-xr-x","type":"RegularFile"}`,
+		`{"path":"/bin/sh","layer":"sha256:a","layerIndex":0,"size":0,"mode":"----------","type":"SymbolicLink","linkTarget":"busybox"}`,
+		`{"path":"/etc/hosts","layer":"sha256:a","layerIndex":0,"digest":"` + digestOf("localhost") + `","size":9,"mode":"----------","type":"RegularFile"}`,
+		`{"path":"/etc/hosts","layer":"sha256:b","layerIndex":1,"digest":"` + digestOf("other") + `","size":5,"mode":"----------","type":"RegularFile"}`,
+	}
+
+	// records are written in catalog order, which is not sorted
+	assert.True(t, strings.HasSuffix(buf.String(), "\n"))
+	assert.ElementsMatch(t, expected, strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"))
+}
+
+func TestImage_ReadFile(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest:   "sha256:a",
+			paths:    []string{"/lib/apk/db/installed", "/var/lib/dpkg/status"},
+			contents: map[string]string{"/lib/apk/db/installed": "P:musl", "/var/lib/dpkg/status": "Package: libc6"},
+		},
+	)
+
+	contents, err := img.ReadFile("/lib/apk/db/installed")
+	assert.NoError(t, err)
+	assert.Equal(t, "P:musl", string(contents))
+
+	_, err = img.ReadFile("/lib/missing")
+	assert.True(t, errors.Is(err, ErrPathNotFound), "expected ErrPathNotFound, got: %+v", err)
+
+	require.NoError(t, WithMaxReadFileSize(6)(img))
+
+	contents, err = img.ReadFile("/lib/apk/db/installed")
+	assert.NoError(t, err)
+	assert.Equal(t, "P:musl", string(contents))
+
+	_, err = img.ReadFile("/var/lib/dpkg/status")
+	assert.True(t, errors.Is(err, ErrFileTooLarge), "expected ErrFileTooLarge, got: %+v", err)
+}
+
+func TestImage_FileContentsFromSquashBounded(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest:   "sha256:a",
+			paths:    []string{"/etc/hosts", "/var/log/huge.log"},
+			contents: map[string]string{"/etc/hosts": "localhost", "/var/log/huge.log": "a very large log file"},
+			links:    map[string]string{"/etc/hosts.link": "hosts"},
+		},
+	)
+
+	tests := []struct {
+		name        string
+		path        file.Path
+		maxBytes    int64
+		expected    string
+		expectedErr error
+	}{
+		{
+			name:     "within bounds",
+			path:     "/etc/hosts",
+			maxBytes: 100,
+			expected: "localhost",
+		},
+		{
+			name:     "exactly the bound",
+			path:     "/etc/hosts",
+			maxBytes: int64(len("localhost")),
+			expected: "localhost",
+		},
+		{
+			name:     "symlink within bounds",
+			path:     "/etc/hosts.link",
+			maxBytes: 100,
+			expected: "localhost",
+		},
+		{
+			name:        "exceeds bounds",
+			path:        "/var/log/huge.log",
+			maxBytes:    10,
+			expectedErr: ErrFileTooLarge,
+		},
+		{
+			name:        "missing",
+			path:        "/etc/missing",
+			maxBytes:    10,
+			expectedErr: ErrPathNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader, err := img.FileContentsFromSquashBounded(test.path, test.maxBytes)
+			if test.expectedErr != nil {
+				assert.True(t, errors.Is(err, test.expectedErr), "expected %+v, got: %+v", test.expectedErr, err)
+				return
+			}
+			require.NoError(t, err)
+			defer reader.Close()
+
+			actual, err := ioutil.ReadAll(reader)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, string(actual))
+		})
+	}
+}
+
+func TestImage_ResolveFromSquash(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest:   "sha256:a",
+			paths:    []string{"/usr/lib/libc.so"},
+			contents: map[string]string{"/usr/lib/libc.so": "elf"},
+			links: map[string]string{
+				"/lib/libc.so":   "/usr/lib/libc.so",
+				"/lib/libc.so.6": "libc.so",
+				"/lib/dead.so":   "/usr/lib/missing.so",
+				"/lib64":         "usr/lib",
+			},
+		},
+	)
+
+	tests := []struct {
+		name             string
+		path             file.Path
+		options          []filetree.LinkResolutionOption
+		expectedLink     file.Path
+		expectedTarget   file.Path
+		expectedContents string
+		expectedErr      error
+	}{
+		{
+			name:             "regular file",
+			path:             "/usr/lib/libc.so",
+			expectedLink:     "/usr/lib/libc.so",
+			expectedTarget:   "/usr/lib/libc.so",
+			expectedContents: "elf",
+		},
+		{
+			name:             "symlink",
+			path:             "/lib/libc.so",
+			expectedLink:     "/lib/libc.so",
+			expectedTarget:   "/usr/lib/libc.so",
+			expectedContents: "elf",
+		},
+		{
+			name:         "dead symlink",
+			path:         "/lib/dead.so",
+			expectedLink: "/lib/dead.so",
+		},
+		{
+			name:         "dead symlink without following dead links",
+			path:         "/lib/dead.so",
+			options:      []filetree.LinkResolutionOption{filetree.DoNotFollowDeadBasenameLinks},
+			expectedLink: "/lib/dead.so",
+		},
+		{
+			name:             "chained symlinks",
+			path:             "/lib/libc.so.6",
+			expectedLink:     "/lib/libc.so.6",
+			expectedTarget:   "/usr/lib/libc.so",
+			expectedContents: "elf",
+		},
+		{
+			name:             "symlinked parent directory",
+			path:             "/lib64/libc.so",
+			expectedLink:     "/usr/lib/libc.so",
+			expectedTarget:   "/usr/lib/libc.so",
+			expectedContents: "elf",
+		},
+		{
+			name:        "missing",
+			path:        "/lib/missing.so",
+			expectedErr: ErrPathNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			linkRef, targetRef, contents, err := img.ResolveFromSquash(test.path, test.options...)
+			if test.expectedErr != nil {
+				assert.True(t, errors.Is(err, test.expectedErr), "expected %+v, got: %+v", test.expectedErr, err)
+				return
+			}
+			require.NoError(t, err)
+
+			require.NotNil(t, linkRef)
+			assert.Equal(t, test.expectedLink, linkRef.RealPath)
+
+			if test.expectedTarget == "" {
+				assert.Nil(t, targetRef)
+			} else {
+				require.NotNil(t, targetRef)
+				assert.Equal(t, test.expectedTarget, targetRef.RealPath)
+			}
+
+			if test.expectedContents == "" {
+				assert.Nil(t, contents)
+				return
+			}
+			require.NotNil(t, contents)
+			defer contents.Close()
+			actual, err := ioutil.ReadAll(contents)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedContents, string(actual))
+		})
+	}
+}
+
+func TestImage_TryMultipleFileContentsFromSquash(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest:   "sha256:a",
+			paths:    []string{"/etc/app/config.yaml", "/etc/uncached"},
+			contents: map[string]string{"/etc/app/config.yaml": "debug: true"},
+			links:    map[string]string{"/etc/config.yaml": "app/config.yaml"},
+		},
+	)
+
+	contents, missing, err := img.TryMultipleFileContentsFromSquash("/etc/app/config.yaml", "/etc/config.yaml", "/etc/app/config.yml")
+	require.NoError(t, err)
+	assert.Equal(t, []file.Path{"/etc/app/config.yml"}, missing)
+	require.Len(t, contents, 1)
+	for ref, reader := range contents {
+		assert.Equal(t, file.Path("/etc/app/config.yaml"), ref.RealPath)
+		b, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, "debug: true", string(b))
+		reader.Close()
+	}
+
+	// a file that exists but cannot be read is a real failure
+	_, _, err = img.TryMultipleFileContentsFromSquash("/etc/app/config.yaml", "/etc/uncached")
+	assert.True(t, errors.Is(err, ErrContentNotCached), "expected ErrContentNotCached, got: %+v", err)
+}
+
+func TestImage_WarmCache(t *testing.T) {
+	v1Image, err := mutate.AppendLayers(empty.Image,
+		newTarLayer(t, map[string]string{"etc/a": "a-contents", "etc/b": "b-contents", "etc/c": "c-contents"}),
+		newTarLayer(t, map[string]string{"etc/d": "d-contents"}),
+	)
+	require.NoError(t, err)
+
+	cacheDir := t.TempDir()
+	img := NewImage(v1Image, cacheDir)
+	require.NoError(t, img.Read())
+
+	var warm []file.Reference
+	for _, p := range []file.Path{"/etc/a", "/etc/c", "/etc/d"} {
+		_, ref, err := img.SquashedTree().File(p)
+		require.NoError(t, err)
+		require.NotNil(t, ref)
+		warm = append(warm, *ref)
+	}
+
+	for _, layer := range img.Layers {
+		assert.Empty(t, layer.inMemoryContent)
+	}
+
+	require.NoError(t, img.WarmCache(warm...))
+	assert.Len(t, img.Layers[0].inMemoryContent, 2)
+	assert.Len(t, img.Layers[1].inMemoryContent, 1)
+
+	// warmed content is served without the layer tar cache
+	for _, layer := range img.Layers {
+		require.NoError(t, os.Remove(path.Join(cacheDir, layer.Metadata.Digest+".tar")))
+	}
+	for _, ref := range warm {
+		reader, err := img.FileContentsByRef(ref)
+		require.NoError(t, err)
+		actual, err := ioutil.ReadAll(reader)
+		require.NoError(t, err)
+		assert.Equal(t, path.Base(string(ref.RealPath))+"-contents", string(actual))
+	}
+
+	err = img.WarmCache(*file.NewFileReference("/etc/missing"))
+	assert.True(t, errors.Is(err, ErrFileNotFound), "expected ErrFileNotFound, got: %+v", err)
+}
+
+func TestImage_WarmCache_SkipsLargeFiles(t *testing.T) {
+	large := strings.Repeat("large-contents\n", 100)
+	v1Image, err := mutate.AppendLayers(empty.Image,
+		newTarLayer(t, map[string]string{"etc/small": "small", "etc/large": large}),
+	)
+	require.NoError(t, err)
+
+	img := NewImage(v1Image, t.TempDir(), WithMemoryThreshold(64))
+	require.NoError(t, img.Read())
+
+	var refs []file.Reference
+	for _, p := range []file.Path{"/etc/small", "/etc/large"} {
+		_, ref, err := img.SquashedTree().File(p)
+		require.NoError(t, err)
+		require.NotNil(t, ref)
+		refs = append(refs, *ref)
+	}
+
+	require.NoError(t, img.WarmCache(refs...))
+
+	// only the small file is held in memory, the large file is still served from the layer tar cache
+	layer := img.Layers[0]
+	require.Len(t, layer.inMemoryContent, 1)
+	for _, contents := range layer.inMemoryContent {
+		assert.Equal(t, "small", string(contents))
+	}
+
+	reader, err := img.FileContentsByRef(refs[1])
+	require.NoError(t, err)
+	actual, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, large, string(actual))
+}
+
+func TestImage_VerifyContentAvailable(t *testing.T) {
+	v1Image, err := mutate.AppendLayers(empty.Image,
+		newTarLayer(t, map[string]string{"etc/a": "a-contents", "etc/b": "b-contents", "etc/c": "c-contents"}),
+		newTarLayer(t, map[string]string{"etc/d": "d-contents", "etc/e/": ""}),
+	)
+	require.NoError(t, err)
+
+	cacheDir := t.TempDir()
+	img := NewImage(v1Image, cacheDir)
+	require.NoError(t, img.Read())
+	require.NoError(t, img.VerifyContentAvailable())
+
+	// content that is released is re-fetched from the image source
+	require.NoError(t, img.ReleaseContent())
+	require.NoError(t, img.VerifyContentAvailable())
+
+	// truncate the first layer tar cache just after the contents of the first file
+	require.NoError(t, os.Truncate(path.Join(cacheDir, img.Layers[0].Metadata.Digest+".tar"), 1024))
+
+	err = img.VerifyContentAvailable()
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, ErrContentUnavailable), "expected ErrContentUnavailable, got: %+v", err)
+
+	var unavailable []string
+	for _, p := range []file.Path{"/etc/a", "/etc/b", "/etc/c", "/etc/d", "/etc/e"} {
+		_, ref, err := img.SquashedTree().File(p)
+		require.NoError(t, err)
+		require.NotNil(t, ref)
+		if img.VerifyContentAvailable(*ref) != nil {
+			unavailable = append(unavailable, string(p))
+		}
+	}
+	assert.Equal(t, []string{"/etc/b", "/etc/c"}, unavailable)
+
+	err = img.VerifyContentAvailable(*file.NewFileReference("/etc/missing"))
+	assert.True(t, errors.Is(err, ErrContentUnavailable), "expected ErrContentUnavailable, got: %+v", err)
+}
+
+func TestImage_StreamContents(t *testing.T) {
+	v1Image, err := mutate.AppendLayers(empty.Image,
+		newTarLayer(t, map[string]string{"etc/a": "a-contents", "etc/b": "b-contents", "etc/c": "c-contents"}),
+		newTarLayer(t, map[string]string{"etc/d": "d-contents", "etc/e/": ""}),
+	)
+	require.NoError(t, err)
+
+	img := NewImage(v1Image, t.TempDir())
+	require.NoError(t, img.Read())
+
+	var refs []file.Reference
+	for _, p := range []file.Path{"/etc/d", "/etc/c", "/etc/e", "/etc/a", "/etc/a"} {
+		_, ref, err := img.SquashedTree().File(p)
+		require.NoError(t, err)
+		require.NotNil(t, ref)
+		refs = append(refs, *ref)
+	}
+
+	var paths []file.Path
+	contents := make(map[file.Path]string)
+	err = img.StreamContents(refs, func(ref file.Reference, reader io.Reader) error {
+		b, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, ref.RealPath)
+		contents[ref.RealPath] = string(b)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// each reference is streamed once in layer and tar order (directories are ignored)
+	assert.Equal(t, []file.Path{"/etc/a", "/etc/c", "/etc/d"}, paths)
+	assert.Equal(t, map[file.Path]string{"/etc/a": "a-contents", "/etc/c": "c-contents", "/etc/d": "d-contents"}, contents)
+
+	stop := fmt.Errorf("stop")
+	var calls int
+	err = img.StreamContents(refs, func(file.Reference, io.Reader) error {
+		calls++
+		return stop
+	})
+	assert.True(t, errors.Is(err, stop), "expected stop error, got: %+v", err)
+	assert.Equal(t, 1, calls)
+
+	// references without a tar entry in the layer are reported once the layer has been streamed
+	missing := map[int64]file.Reference{999: *file.NewFileReference("/etc/missing")}
+	err = img.Layers[0].streamContent(missing, func(file.Reference, io.Reader) error {
+		return nil
+	})
+	assert.True(t, errors.Is(err, ErrFileNotFound), "expected ErrFileNotFound, got: %+v", err)
+}
+
+func TestImage_WithPrecomputeDigests(t *testing.T) {
+	files := map[string]string{
+		"etc/small": "small-contents",
+		"etc/empty": "",
+		// larger than what is needed for MIME type detection
+		"etc/large": strings.Repeat("large-contents\n", 4096),
+	}
+	v1Image, err := mutate.AppendLayers(empty.Image, newTarLayer(t, files))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		options []AdditionalMetadata
+		digests bool
+	}{
+		{
+			name: "digests are lazy by default",
+		},
+		{
+			name:    "precompute digests",
+			options: []AdditionalMetadata{WithPrecomputeDigests()},
+			digests: true,
+		},
+		{
+			name:    "precompute digests for in-memory content",
+			options: []AdditionalMetadata{WithPrecomputeDigests(), WithMemoryThreshold(1024 * 1024)},
+			digests: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img := NewImage(v1Image, t.TempDir(), test.options...)
+			require.NoError(t, img.Read())
+
+			for name, contents := range files {
+				_, ref, err := img.SquashedTree().File(file.Path("/" + name))
+				require.NoError(t, err)
+				require.NotNil(t, ref)
+
+				entry, err := img.FileCatalog.Get(*ref)
+				require.NoError(t, err)
+
+				if !test.digests {
+					assert.Empty(t, entry.Metadata.Digest, "path=%s", name)
+					continue
+				}
+				assert.Equal(t, fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(contents))), entry.Metadata.Digest, "path=%s", name)
+
+				// the contents are still available after being digested
+				reader, err := img.FileContentsByRef(*ref)
+				require.NoError(t, err)
+				actual, err := ioutil.ReadAll(reader)
+				require.NoError(t, err)
+				assert.Equal(t, contents, string(actual), "path=%s", name)
+			}
+		})
+	}
+}
+
+func TestImage_LegacyRegularFileEntries(t *testing.T) {
+	contents := "legacy-contents"
+	v1Image, err := mutate.AppendLayers(empty.Image, newTarLayer(t, map[string]string{"etc/legacy": contents}))
+	require.NoError(t, err)
+
+	// the tar reader normalizes TypeRegA on read, so the legacy type flag is injected via a header transform
+	legacy := func(header *tar.Header) (*tar.Header, bool) {
+		if header.Typeflag == tar.TypeReg {
+			header.Typeflag = tar.TypeRegA //nolint:staticcheck
+		}
+		return header, true
+	}
+
+	cacheDir := t.TempDir()
+	img := NewImage(v1Image, cacheDir,
+		WithHeaderTransform(legacy),
+		WithPrecomputeDigests(),
+		WithMemoryThreshold(1024),
+	)
+	require.NoError(t, img.Read())
+
+	_, ref, err := img.SquashedTree().File("/etc/legacy")
+	require.NoError(t, err)
+	require.NotNil(t, ref)
+
+	entry, err := img.FileCatalog.Get(*ref)
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(contents))), entry.Metadata.Digest)
+
+	// the contents were kept in memory, so they are readable without the layer tar cache
+	require.NoError(t, os.RemoveAll(cacheDir))
+	reader, err := img.FileContentsByRef(*ref)
+	require.NoError(t, err)
+	actual, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, contents, string(actual))
+}
+
+func TestImage_Read_DeviceEntries(t *testing.T) {
+	v1Image, err := mutate.AppendLayers(empty.Image, newLayerFromHeaders(t, []*tar.Header{
+		{Name: "dev/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0666, Devmajor: 1, Devminor: 3},
+		{Name: "dev/sda", Typeflag: tar.TypeBlock, Mode: 0660, Devmajor: 8, Devminor: 0},
+		{Name: "etc/hosts", Typeflag: tar.TypeReg, Mode: 0644},
+	}, map[string]string{"etc/hosts": "localhost"}))
+	require.NoError(t, err)
+
+	img := NewImage(v1Image, t.TempDir())
+	require.NoError(t, img.Read())
+
+	tests := []struct {
+		path             file.Path
+		expectedType     file.Type
+		expectedDevMajor int64
+		expectedDevMinor int64
+	}{
+		{
+			path:             "/dev/null",
+			expectedType:     file.TypeCharacterDevice,
+			expectedDevMajor: 1,
+			expectedDevMinor: 3,
+		},
+		{
+			path:             "/dev/sda",
+			expectedType:     file.TypeBlockDevice,
+			expectedDevMajor: 8,
+		},
+		{
+			path:         "/etc/hosts",
+			expectedType: file.TypeReg,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.path), func(t *testing.T) {
+			_, ref, err := img.SquashedTree().File(test.path)
+			require.NoError(t, err)
+			require.NotNil(t, ref)
+
+			entry, err := img.FileCatalog.Get(*ref)
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedType, entry.Type())
+			assert.Equal(t, test.expectedDevMajor, entry.Metadata.DevMajor)
+			assert.Equal(t, test.expectedDevMinor, entry.Metadata.DevMinor)
+		})
+	}
+
+	devices := img.SquashedTree().AllFiles(file.TypeCharacterDevice, file.TypeBlockDevice)
+	var paths []string
+	for _, ref := range devices {
+		paths = append(paths, string(ref.RealPath))
+	}
+	sort.Strings(paths)
+	assert.Equal(t, []string{"/dev/null", "/dev/sda"}, paths)
+}
+
+func TestImage_WalkSquash(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{digest: "sha256:a", paths: []string{"/etc/hosts", "/bin/sh", "/usr/lib/os-release", "/README"}},
+		testLayer{digest: "sha256:b", paths: []string{"/etc/passwd"}},
+	)
+
+	type visit struct {
+		path  file.Path
+		depth int
+	}
+
+	var visited []visit
+	err := img.WalkSquash(func(ref file.Reference, depth int) error {
+		visited = append(visited, visit{path: ref.RealPath, depth: depth})
+		return nil
+	})
+	require.NoError(t, err)
+
+	// note: parent directories without a tar entry ("/bin", "/etc", "/usr", "/usr/lib") have no reference
+	assert.Equal(t, []visit{
+		{path: "/README", depth: 1},
+		{path: "/bin/sh", depth: 2},
+		{path: "/etc/hosts", depth: 2},
+		{path: "/etc/passwd", depth: 2},
+		{path: "/usr/lib/os-release", depth: 3},
+	}, visited)
+
+	// returning an error stops the walk
+	stop := fmt.Errorf("stop")
+	var count int
+	err = img.WalkSquash(func(ref file.Reference, depth int) error {
+		count++
+		return stop
+	})
+	assert.True(t, errors.Is(err, stop), "expected stop error, got: %+v", err)
+	assert.Equal(t, 1, count)
+}
+
+func TestImage_WithMaterializeSymlinks(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest:   "sha256:a",
+			paths:    []string{"/usr/lib/libc.so"},
+			contents: map[string]string{"/usr/lib/libc.so": "elf"},
+			links:    map[string]string{"/lib": "usr/lib"},
+		},
+	)
+	assert.NotContains(t, img.SquashedTree().AllRealPaths(), file.Path("/lib/libc.so"))
+
+	require.NoError(t, WithMaterializeSymlinks()(img))
+	require.NoError(t, img.squash(&progress.Manual{}))
+
+	assert.Contains(t, img.SquashedTree().AllRealPaths(), file.Path("/lib/libc.so"))
+	assert.NotContains(t, img.Layers[0].Tree.AllRealPaths(), file.Path("/lib/libc.so"))
+
+	reader, err := img.FileContentsFromSquash("/lib/libc.so")
+	require.NoError(t, err)
+	actual, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "elf", string(actual))
+}
+
+func TestImage_IsScratch(t *testing.T) {
+	tests := []struct {
+		name     string
+		layers   []testLayer
+		history  []v1.History
+		expected bool
+	}{
+		{
+			name:     "no layers",
+			expected: true,
+		},
+		{
+			name:     "single layer without history",
+			layers:   []testLayer{{digest: "sha256:a", paths: []string{"/app"}}},
+			expected: true,
+		},
+		{
+			name: "based on another image without history",
+			layers: []testLayer{
+				{digest: "sha256:a", paths: []string{"/etc/os-release"}},
+				{digest: "sha256:b", paths: []string{"/app"}},
+			},
+			expected: false,
+		},
+		{
+			name:   "single layer with config only history",
+			layers: []testLayer{{digest: "sha256:a", paths: []string{"/app"}}},
+			history: []v1.History{
+				{CreatedBy: "COPY app /app"},
+				{CreatedBy: "ENTRYPOINT [\"/app\"]", EmptyLayer: true},
+				{CreatedBy: "USER 65532", EmptyLayer: true},
+			},
+			expected: true,
+		},
+		{
+			name: "inherited layers from a base image",
+			layers: []testLayer{
+				{digest: "sha256:a", paths: []string{"/etc/os-release"}},
+				{digest: "sha256:b", paths: []string{"/app"}},
+			},
+			history: []v1.History{
+				{CreatedBy: "/bin/sh -c #(nop) ADD file:abc in / "},
+				{CreatedBy: "/bin/sh -c #(nop)  CMD [\"/bin/sh\"]", EmptyLayer: true},
+				{CreatedBy: "COPY app /app"},
+			},
+			expected: false,
+		},
+		{
+			name:   "history that does not describe the layers falls back to the layer count",
+			layers: []testLayer{{digest: "sha256:a", paths: []string{"/app"}}},
+			history: []v1.History{
+				{CreatedBy: "COPY app /app"},
+				{CreatedBy: "COPY config /config"},
+			},
+			expected: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img := newTestImage(t, test.layers...)
+			img.Metadata.Config.History = test.history
+			assert.Equal(t, len(test.layers), img.LayerCount())
+			assert.Equal(t, test.expected, img.IsScratch())
+		})
+	}
+}
+
+func TestImage_HardlinkGroups(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest:   "sha256:a",
+			paths:    []string{"/usr/bin/perl", "/usr/bin/other"},
+			contents: map[string]string{"/usr/bin/perl": "elf", "/usr/bin/other": "elf"},
+		},
+	)
+
+	layer := img.Layers[0]
+	hardlinks := map[string]string{
+		"/usr/bin/perl5.30": "usr/bin/perl",
+		"/usr/bin/perl5":    "./usr/bin/perl5.30",
+		"/usr/bin/orphan-1": "usr/bin/deleted",
+		"/usr/bin/orphan-2": "usr/bin/deleted",
+	}
+	for p, linkname := range hardlinks {
+		ref, err := layer.Tree.AddHardLink(file.Path(p), file.Path(linkname))
+		require.NoError(t, err)
+		img.FileCatalog.Add(*ref, file.Metadata{Path: p, Linkname: linkname, TypeFlag: tar.TypeLink}, layer, nil)
+	}
+	require.NoError(t, img.squash(&progress.Manual{}))
+
+	var actual [][]file.Path
+	for _, group := range img.HardlinkGroups() {
+		var paths []file.Path
+		for _, ref := range group {
+			paths = append(paths, ref.RealPath)
+		}
+		actual = append(actual, paths)
+	}
+
+	// files with the same contents are not grouped unless they are hardlinked
+	assert.Equal(t, [][]file.Path{
+		{"/usr/bin/orphan-1", "/usr/bin/orphan-2"},
+		{"/usr/bin/perl", "/usr/bin/perl5", "/usr/bin/perl5.30"},
+	}, actual)
+}
+
+func TestImage_SquashDigest(t *testing.T) {
+	tests := []struct {
+		name        string
+		a           []testLayer
+		b           []testLayer
+		expectEqual bool
+	}{
+		{
+			name: "same files with different layer splits",
+			a: []testLayer{
+				{
+					digest:   "sha256:a",
+					paths:    []string{"/etc/hosts", "/bin/busybox"},
+					contents: map[string]string{"/etc/hosts": "localhost", "/bin/busybox": "elf"},
+					links:    map[string]string{"/bin/sh": "busybox"},
+				},
+			},
+			b: []testLayer{
+				{
+					digest:   "sha256:b",
+					paths:    []string{"/etc/hosts", "/bin/busybox"},
+					contents: map[string]string{"/etc/hosts": "overwritten", "/bin/busybox": "elf"},
+				},
+				{
+					digest:   "sha256:c",
+					paths:    []string{"/etc/hosts"},
+					contents: map[string]string{"/etc/hosts": "localhost"},
+					links:    map[string]string{"/bin/sh": "busybox"},
+				},
+			},
+			expectEqual: true,
+		},
+		{
+			name: "different contents",
+			a: []testLayer{
+				{
+					digest:   "sha256:a",
+					paths:    []string{"/etc/hosts"},
+					contents: map[string]string{"/etc/hosts": "localhost"},
+				},
+			},
+			b: []testLayer{
+				{
+					digest:   "sha256:a",
+					paths:    []string{"/etc/hosts"},
+					contents: map[string]string{"/etc/hosts": "127.0.0.1"},
+				},
+			},
+		},
+		{
+			name: "different modes",
+			a: []testLayer{
+				{
+					digest:   "sha256:a",
+					paths:    []string{"/bin/busybox"},
+					contents: map[string]string{"/bin/busybox": "elf"},
+					modes:    map[string]os.FileMode{"/bin/busybox": 0755},
+				},
+			},
+			b: []testLayer{
+				{
+					digest:   "sha256:a",
+					paths:    []string{"/bin/busybox"},
+					contents: map[string]string{"/bin/busybox": "elf"},
+					modes:    map[string]os.FileMode{"/bin/busybox": 0755 | os.ModeSetuid},
+				},
+			},
+		},
+		{
+			name: "different link destinations",
+			a: []testLayer{
+				{
+					digest: "sha256:a",
+					links:  map[string]string{"/bin/sh": "busybox"},
+				},
+			},
+			b: []testLayer{
+				{
+					digest: "sha256:a",
+					links:  map[string]string{"/bin/sh": "bash"},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, err := newTestImage(t, test.a...).SquashDigest()
+			require.NoError(t, err)
+			b, err := newTestImage(t, test.b...).SquashDigest()
+			require.NoError(t, err)
+
+			assert.True(t, strings.HasPrefix(a, "sha256:"))
+			if test.expectEqual {
+				assert.Equal(t, a, b)
+			} else {
+				assert.NotEqual(t, a, b)
+			}
+		})
+	}
+}
+
+func TestImage_FilesByTypeFromSquash(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest: "sha256:a",
+			paths:  []string{"/usr/bin/busybox", "/etc/hosts"},
+			links:  map[string]string{"/bin/sh": "/usr/bin/busybox"},
+		},
+	)
+
+	tests := []struct {
+		name     string
+		types    []file.Type
+		expected []file.Path
+	}{
+		{
+			name:     "regular files",
+			types:    []file.Type{file.TypeReg},
+			expected: []file.Path{"/etc/hosts", "/usr/bin/busybox"},
+		},
+		{
+			name:     "symlinks",
+			types:    []file.Type{file.TypeSymlink},
+			expected: []file.Path{"/bin/sh"},
+		},
+		{
+			name:     "multiple types",
+			types:    []file.Type{file.TypeSymlink, file.TypeReg},
+			expected: []file.Path{"/bin/sh", "/etc/hosts", "/usr/bin/busybox"},
+		},
+		{
+			name:  "no matches",
+			types: []file.Type{file.TypeFifo},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			refs, err := img.FilesByTypeFromSquash(test.types...)
+			require.NoError(t, err)
+
+			var actual []file.Path
+			for _, ref := range refs {
+				actual = append(actual, ref.RealPath)
+			}
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+func TestImage_Annotate(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest: "sha256:a",
+			paths:  []string{"/etc/shadow", "/bin/busybox"},
+		},
+	)
+
+	shadow, _, err := img.FileReferenceFromSquash("/etc/shadow")
+	require.NoError(t, err)
+	busybox, _, err := img.FileReferenceFromSquash("/bin/busybox")
+	require.NoError(t, err)
+
+	assert.Nil(t, img.Annotations(*shadow))
+
+	img.Annotate(*shadow, "secret", "true")
+	img.Annotate(*shadow, "reviewed", "false")
+	img.Annotate(*shadow, "reviewed", "true")
+	img.Annotate(*busybox, "binary", "true")
+
+	assert.Equal(t, map[string]string{"secret": "true", "reviewed": "true"}, img.Annotations(*shadow))
+	assert.Equal(t, map[string]string{"binary": "true"}, img.Annotations(*busybox))
+
+	// the returned annotations are a copy
+	img.Annotations(*busybox)["binary"] = "false"
+	assert.Equal(t, map[string]string{"binary": "true"}, img.Annotations(*busybox))
+}
+
+func TestImage_CountSquash(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest: "sha256:a",
+			paths:  []string{"/usr/lib/python3/a.py", "/usr/lib/python3/b.py", "/usr/lib/python3/b.pyc", "/etc/hosts"},
+			links:  map[string]string{"/usr/bin/python": "python3", "/usr/bin/python3": "/usr/lib/python3/a.py"},
+		},
+		testLayer{
+			digest: "sha256:b",
+			paths:  []string{"/usr/lib/python3/.wh.b.py"},
+		},
+	)
+
+	tests := []struct {
+		name     string
+		filter   FileFilter
+		expected int
+		wantErr  bool
+	}{
+		{
+			name:     "all files",
+			expected: 5,
+		},
+		{
+			name:     "glob",
+			filter:   FileFilter{Glob: "**/*.py"},
+			expected: 1,
+		},
+		{
+			name:     "type",
+			filter:   FileFilter{Types: []file.Type{file.TypeSymlink}},
+			expected: 2,
+		},
+		{
+			name:     "glob and type",
+			filter:   FileFilter{Glob: "/usr/**", Types: []file.Type{file.TypeReg}},
+			expected: 2,
+		},
+		{
+			name:    "invalid glob",
+			filter:  FileFilter{Glob: "/usr/[lib"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := img.CountSquash(test.filter)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
+
+func TestImage_WithWhiteoutDiagnostics(t *testing.T) {
+	layers := []testLayer{
+		{digest: "sha256:a", paths: []string{"/app/config.yaml", "/app/bin/server", "/etc/hosts"}},
+		{digest: "sha256:b", paths: []string{"/app/" + file.OpaqueWhiteout, "/app/bin/server"}},
+	}
+
+	img := newTestImage(t, layers...)
+	assert.False(t, img.SquashedTree().HasPath("/app/config.yaml"))
+	assert.Empty(t, img.WhiteoutAffectedPaths())
+
+	img = newTestImage(t, layers...)
+	require.NoError(t, WithWhiteoutDiagnostics()(img))
+	require.NoError(t, img.squash(&progress.Manual{}))
+
+	assert.True(t, img.SquashedTree().HasPath("/app/config.yaml"))
+	assert.Equal(t, map[file.Path]uint{"/app/config.yaml": 1}, img.WhiteoutAffectedPaths())
+}
+
+func TestImage_AsOfLayer(t *testing.T) {
+	img := newTestImage(t,
+		testLayer{
+			digest:   "sha256:a",
+			paths:    []string{"/etc/config", "/bin/busybox"},
+			contents: map[string]string{"/etc/config": "base", "/bin/busybox": "elf"},
+		},
+		testLayer{
+			digest:   "sha256:b",
+			paths:    []string{"/etc/config", "/app/main"},
+			contents: map[string]string{"/etc/config": "app", "/app/main": "main"},
+		},
+	)
+	img.Layers[0].Metadata.Size = 7
+	img.Layers[1].Metadata.Size = 7
+	img.Metadata.Size = 14
+	img.Metadata.Config = v1.ConfigFile{
+		RootFS: v1.RootFS{
+			DiffIDs: []v1.Hash{{Algorithm: "sha256", Hex: "a"}, {Algorithm: "sha256", Hex: "b"}},
+		},
+		History: []v1.History{
+			{CreatedBy: "ADD rootfs"},
+			{CreatedBy: "ENV A=B", EmptyLayer: true},
+			{CreatedBy: "COPY app"},
+		},
+	}
+
+	view, err := img.AsOfLayer(0)
+	require.NoError(t, err)
+
+	assert.Len(t, view.Layers, 1)
+	assert.Len(t, img.Layers, 2)
+	assert.Equal(t, int64(7), view.Metadata.Size)
+	assert.Equal(t, []v1.Hash{{Algorithm: "sha256", Hex: "a"}}, view.Metadata.Config.RootFS.DiffIDs)
+	assert.Equal(t, []v1.History{{CreatedBy: "ADD rootfs"}}, view.Metadata.Config.History)
+	// the original image is not modified
+	assert.Len(t, img.Metadata.Config.RootFS.DiffIDs, 2)
+	assert.Equal(t, int64(14), img.Metadata.Size)
+
+	assert.False(t, view.SquashedTree().HasPath("/app/main"))
+	contents, err := view.ReadFile("/etc/config")
+	require.NoError(t, err)
+	assert.Equal(t, "base", string(contents))
+
+	contents, err = img.ReadFile("/etc/config")
+	require.NoError(t, err)
+	assert.Equal(t, "app", string(contents))
+
+	_, err = img.AsOfLayer(2)
+	assert.True(t, errors.Is(err, ErrLayerOutOfRange), "expected ErrLayerOutOfRange, got: %+v", err)
+}
+
+func TestImage_WithMemoryMappedCache(t *testing.T) {
+	files := map[string]string{
+		"etc/hosts":    "localhost",
+		"etc/hostname": "stereoscope",
+		"empty":        "",
+	}
+	v1Image, err := mutate.AppendLayers(empty.Image, newTarLayer(t, files))
+	require.NoError(t, err)
+
+	img := NewImage(v1Image, t.TempDir(), WithMemoryMappedCache())
+	require.NoError(t, img.Read())
+	require.NotNil(t, img.Layers[0].mappedContent)
+
+	readAll := func(t *testing.T, p string) string {
+		t.Helper()
+		contents, err := img.FileContentsFromSquash(file.Path(p))
+		require.NoError(t, err)
+		defer contents.Close()
+		actual, err := ioutil.ReadAll(contents)
+		require.NoError(t, err)
+		return string(actual)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name, expected := range files {
+				contents, err := img.FileContentsFromSquash(file.Path("/" + name))
+				if !assert.NoError(t, err) {
+					continue
+				}
+				actual, err := ioutil.ReadAll(contents)
+				assert.NoError(t, err)
+				assert.Equal(t, expected, string(actual))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// content is mapped again after being released and re-fetched
+	require.NoError(t, img.ReleaseContent())
+	assert.Nil(t, img.Layers[0].mappedContent)
+	assert.Equal(t, "localhost", readAll(t, "/etc/hosts"))
+	assert.NotNil(t, img.Layers[0].mappedContent)
+
+	// readers opened before cleanup fail, however, later reads fall back to the layer tar cache
+	opened, err := img.FileContentsFromSquash(file.Path("/etc/hostname"))
+	require.NoError(t, err)
+	require.NoError(t, img.Cleanup())
+	assert.Nil(t, img.Layers[0].mappedContent)
+
+	_, err = ioutil.ReadAll(opened)
+	assert.ErrorIs(t, err, file.ErrMemoryMapClosed)
+	assert.Equal(t, "stereoscope", readAll(t, "/etc/hostname"))
+	assert.Nil(t, img.Layers[0].mappedContent)
+}
+
+// BenchmarkImage_FileContentsFromSquash compares reading file contents from the regular (file-based) layer tar cache
+// with reading them from the memory mapped layer tar cache (see WithMemoryMappedCache), for small and large files. Run
+// with "-bench FileContentsFromSquash -benchmem" and compare the "file cache" and "memory mapped cache" results for
+// each file size.
+func BenchmarkImage_FileContentsFromSquash(b *testing.B) {
+	caches := []struct {
+		name    string
+		options []AdditionalMetadata
+	}{
+		{
+			name: "file cache",
+		},
+		{
+			name:    "memory mapped cache",
+			options: []AdditionalMetadata{WithMemoryMappedCache()},
+		},
+	}
+
+	for _, fileSize := range []int{4 * 1024, 1024 * 1024} {
+		files := make(map[string]string)
+		var paths []file.Path
+		for i := 0; i < 100; i++ {
+			name := fmt.Sprintf("file-%d.txt", i)
+			files[name] = strings.Repeat("x", fileSize)
+			paths = append(paths, file.Path("/"+name))
+		}
+		v1Image, err := mutate.AppendLayers(empty.Image, newTarLayer(b, files))
+		require.NoError(b, err)
+
+		for _, cache := range caches {
+			b.Run(fmt.Sprintf("%s/%dKiB", cache.name, fileSize/1024), func(b *testing.B) {
+				img := NewImage(v1Image, b.TempDir(), cache.options...)
+				require.NoError(b, img.Read())
+				defer img.Cleanup()
+
+				if img.memoryMappedCache && img.Layers[0].mappedContent == nil {
+					b.Skip("memory mapped files are not supported on this platform")
+				}
+
+				b.SetBytes(int64(fileSize))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					contents, err := img.FileContentsFromSquash(paths[i%len(paths)])
+					if err != nil {
+						b.Fatalf("unable to open contents: %+v", err)
+					}
+					if _, err := io.Copy(ioutil.Discard, contents); err != nil {
+						b.Fatalf("unable to read contents: %+v", err)
+					}
+					contents.Close()
+				}
+			})
+		}
+	}
+}
+
+func TestImage_ReadLayerByDigest(t *testing.T) {
+	lower := newTarLayer(t, map[string]string{"etc/hosts": "localhost"})
+	upper := newTarLayer(t, map[string]string{"etc/hostname": "stereoscope", "usr/bin/app": "app"})
+	v1Image, err := mutate.AppendLayers(empty.Image, lower, unavailableLayer{Layer: upper})
+	require.NoError(t, err)
+
+	diffID, err := lower.DiffID()
+	require.NoError(t, err)
+	blobDigest, err := lower.Digest()
+	require.NoError(t, err)
+
+	for _, digest := range []v1.Hash{diffID, blobDigest} {
+		t.Run(digest.String(), func(t *testing.T) {
+			img := NewImage(v1Image, t.TempDir())
+
+			// the upper layer cannot be fetched, so reading it would fail
+			layer, err := img.ReadLayerByDigest(digest.String())
+			require.NoError(t, err)
+
+			assert.Equal(t, uint(0), layer.Metadata.Index)
+			assert.Equal(t, diffID.String(), layer.Metadata.Digest)
+			assert.Nil(t, layer.SquashedTree)
+			assert.Empty(t, img.Layers)
+			assert.Empty(t, img.FileCatalog.catalog)
+
+			contents, err := layer.FileContents("/etc/hosts")
+			require.NoError(t, err)
+			actual, err := ioutil.ReadAll(contents)
+			require.NoError(t, err)
+			assert.Equal(t, "localhost", string(actual))
+		})
+	}
+
+	img := NewImage(v1Image, t.TempDir())
+	_, err = img.ReadLayerByDigest("sha256:0000000000000000000000000000000000000000000000000000000000000000")
+	assert.ErrorIs(t, err, ErrLayerNotFound)
+}
+
+func TestImage_FileCountAndDistinctContentCount(t *testing.T) {
+	lower := newTarLayer(t, map[string]string{
+		"etc/":         "",
+		"etc/hosts":    "localhost",
+		"etc/hostname": "stereoscope",
+	})
+	upper := newTarLayer(t, map[string]string{
+		"etc/hosts":        "localhost",
+		"etc/issue":        "stereoscope",
+		"etc/motd":         "welcome",
+		"etc/.wh.hostname": "",
+		"usr/":             "",
+		"usr/bin/sh":       "elf",
+	})
+	v1Image, err := mutate.AppendLayers(empty.Image, lower, upper)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		options []AdditionalMetadata
+	}{
+		{
+			name: "digests computed on demand",
+		},
+		{
+			name:    "with precomputed digests",
+			options: []AdditionalMetadata{WithPrecomputeDigests()},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img := NewImage(v1Image, t.TempDir(), test.options...)
+			require.NoError(t, img.Read())
+
+			assert.Equal(t, 9, img.FileCount())
+
+			// localhost, stereoscope, welcome, elf (the whiteout marker is not content)
+			distinct, err := img.DistinctContentCount()
+			require.NoError(t, err)
+			assert.Equal(t, 4, distinct)
+
+			// computed digests are cached in the catalog
+			_, ref, err := img.SquashedTree().File("/etc/motd")
+			require.NoError(t, err)
+			require.NotNil(t, ref)
+			entry, err := img.FileCatalog.Get(*ref)
+			require.NoError(t, err)
+			assert.Equal(t, fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("welcome"))), entry.Metadata.Digest)
+		})
+	}
+}
+
+// BenchmarkImage_Read_InternedPaths reports the heap retained by a read image (retained-B/op) with and without interned
+// paths, for an image where every layer touches the same deep set of paths (as with repeated package installs).
+func BenchmarkImage_Read_InternedPaths(b *testing.B) {
+	var layers []v1.Layer
+	for l := 0; l < 5; l++ {
+		files := make(map[string]string)
+		for i := 0; i < 200; i++ {
+			files[fmt.Sprintf("usr/lib/python3.9/site-packages/package-%d/module/__init__.py", i)] = "x"
+		}
+		layers = append(layers, newTarLayer(b, files))
+	}
+	v1Image, err := mutate.AppendLayers(empty.Image, layers...)
+	require.NoError(b, err)
+
+	benchmarks := []struct {
+		name    string
+		options []AdditionalMetadata
+	}{
+		{
+			name: "plain paths",
+		},
+		{
+			name:    "interned paths",
+			options: []AdditionalMetadata{WithInternedPaths()},
+		},
+	}
+
+	for _, bm := range benchmarks {
+		b.Run(bm.name, func(b *testing.B) {
+			var retained uint64
+			var before, after runtime.MemStats
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				img := NewImage(v1Image, b.TempDir(), bm.options...)
+				if err := img.Read(); err != nil {
+					b.Fatalf("unable to read image: %+v", err)
+				}
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+				runtime.KeepAlive(img)
+				if after.HeapAlloc > before.HeapAlloc {
+					retained += after.HeapAlloc - before.HeapAlloc
+				}
+			}
+			b.ReportMetric(float64(retained)/float64(b.N), "retained-B/op")
+		})
+	}
+}
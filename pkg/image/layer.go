@@ -2,21 +2,66 @@ package image
 
 import (
 	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
+	"sort"
+	"sync"
 
+	"github.com/anchore/stereoscope/internal"
 	"github.com/anchore/stereoscope/internal/bus"
 	"github.com/anchore/stereoscope/internal/log"
 	"github.com/anchore/stereoscope/pkg/event"
 	"github.com/anchore/stereoscope/pkg/file"
 	"github.com/anchore/stereoscope/pkg/filetree"
+	"github.com/bmatcuk/doublestar/v4"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/wagoodman/go-partybus"
 	"github.com/wagoodman/go-progress"
 )
 
+// ErrForeignLayer is returned when reading a foreign (non-distributable) layer while foreign layers are rejected (see
+// RejectForeignLayers).
+var ErrForeignLayer = fmt.Errorf("layer is a foreign (non-distributable) layer")
+
+// ErrBrokenHardlink is returned when a layer tar contains a hardlink to a target that does not appear earlier in the same
+// layer tar (hardlink targets must always precede the hardlink itself) and strict hardlinks are requested (see
+// WithStrictHardlinks).
+type ErrBrokenHardlink struct {
+	// Path is the path of the hardlink
+	Path string
+	// Target is the (missing) path the hardlink refers to
+	Target string
+}
+
+func (e *ErrBrokenHardlink) Error() string {
+	return fmt.Sprintf("hardlink=%q refers to target=%q which does not precede it in the layer tar", e.Path, e.Target)
+}
+
+// ForeignLayerPolicy describes how foreign (non-distributable) layers are handled when reading an image. These layers
+// are not stored in the registry and may only be fetched from external URLs declared in the manifest.
+type ForeignLayerPolicy int
+
+const (
+	// FetchForeignLayers reads foreign layers like any other layer (the default). The layer content is read from the
+	// image source, which may fetch it from the declared external URLs (e.g. registry images) or may already have it
+	// locally (e.g. images saved from a docker daemon).
+	FetchForeignLayers ForeignLayerPolicy = iota
+	// RejectForeignLayers fails reading the image if any foreign layers are found.
+	RejectForeignLayers
+	// SkipForeignLayers represents foreign layers as empty layers (their contents are not part of any file tree).
+	SkipForeignLayers
+)
+
+// HeaderTransform is a function that may rewrite the given tar header before it is indexed. Returning false indicates
+// the entry should be skipped.
+type HeaderTransform func(*tar.Header) (*tar.Header, bool)
+
 // Layer represents a single layer within a container image.
 type Layer struct {
 	// layer is the raw layer metadata and content provider from the GCR lib
@@ -32,6 +77,44 @@ type Layer struct {
 	SquashedTree *filetree.FileTree
 	// fileCatalog contains all file metadata for all files in all layers (not just this layer)
 	fileCatalog *FileCatalog
+	// memoryThreshold is the max size in bytes of regular file contents to keep in memory (0 means never)
+	memoryThreshold int64
+	// headerTransform optionally rewrites or filters tar headers before they are indexed
+	headerTransform HeaderTransform
+	// ignorePaths are glob patterns for paths that are not indexed
+	ignorePaths []string
+	// pathInterner optionally deduplicates path strings across the layer tree and file catalog
+	pathInterner *file.PathInterner
+	// retainCompressed indicates if the original (compressed) layer blob should be kept in the cache directory
+	retainCompressed bool
+	// compressedBlobPath is the location of the cached original layer blob (only set when retained)
+	compressedBlobPath string
+	// whiteoutAffectedPaths are the lower paths that opaque whiteouts in this layer did not remove from the squashed
+	// tree (only populated in whiteout diagnostics mode)
+	whiteoutAffectedPaths []file.Path
+	// foreignLayerPolicy describes how to handle the layer if it is a foreign layer
+	foreignLayerPolicy ForeignLayerPolicy
+	// strictHardlinks indicates that hardlinks to missing targets fail the read instead of being skipped
+	strictHardlinks bool
+	// precomputeDigests indicates that regular file content digests are computed while indexing the layer tar
+	precomputeDigests bool
+	// decompressionProgressThreshold is the smallest compressed layer size to report decompression progress for (0 means
+	// DefaultDecompressionProgressThreshold, less than 0 means never)
+	decompressionProgressThreshold int64
+	// memoryMapped indicates that the layer tar cache is memory mapped for reading file contents
+	memoryMapped bool
+	// warnings are the non-fatal anomalies found while reading the layer
+	warnings []Warning
+	// contentLock guards access to the in-memory content and the layer tar cache (which may be released)
+	contentLock sync.Mutex
+	// cacheDir is where the uncompressed layer tar cache is stored
+	cacheDir string
+	// inMemoryContent contains the contents of small regular files by tar sequence (see memoryThreshold)
+	inMemoryContent map[int64][]byte
+	// mappedContent is the memory mapping of the layer tar cache (only set when memory mapped)
+	mappedContent *file.MemoryMappedFile
+	// contentReleased indicates that the layer tar cache has been removed and must be re-fetched before reading content
+	contentReleased bool
 }
 
 // NewLayer provides a new, unread layer object.
@@ -56,24 +139,89 @@ func (l *Layer) uncompressedTarCache(uncompressedLayersCacheDir string) (string,
 	if err != nil {
 		return "", err
 	}
+	defer rawReader.Close()
 
-	fh, err := os.Create(tarPath)
-	if err != nil {
-		return "", fmt.Errorf("unable to create layer cache dir=%q : %w", tarPath, err)
+	var reader io.Reader = rawReader
+	monitor := l.trackDecompressionProgress()
+	if monitor != nil {
+		reader = internal.NewProgressReader(rawReader, monitor)
 	}
 
-	if _, err := io.Copy(fh, rawReader); err != nil {
-		return "", fmt.Errorf("unable to populate layer cache dir=%q : %w", tarPath, err)
+	err = writeCacheFile(tarPath, reader)
+	if monitor != nil {
+		if err != nil {
+			monitor.Err = err
+		} else {
+			monitor.SetCompleted()
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to populate layer cache=%q : %w", tarPath, err)
 	}
 
 	return tarPath, nil
 }
 
+// writeCacheFile writes the given content to a temporary file next to the given destination, which is then renamed to
+// the destination. Since the same layer may appear multiple times within an image (sharing the same cache files), this
+// ensures that readers never observe a partially written cache file.
+func writeCacheFile(destination string, reader io.Reader) error {
+	fh, err := ioutil.TempFile(path.Dir(destination), path.Base(destination)+".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fh, reader)
+	if closeErr := fh.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(fh.Name(), destination)
+	}
+	if err != nil {
+		_ = os.Remove(fh.Name())
+		return err
+	}
+	return nil
+}
+
+func (l *Layer) compressedBlobCache(cacheDir string) (string, error) {
+	if cacheDir == "" {
+		return "", fmt.Errorf("no cache directory given")
+	}
+
+	digest, err := l.layer.Digest()
+	if err != nil {
+		return "", fmt.Errorf("unable to get layer blob digest: %w", err)
+	}
+
+	blobPath := path.Join(cacheDir, digest.String()+".blob")
+
+	if _, err := os.Stat(blobPath); !os.IsNotExist(err) {
+		return blobPath, nil
+	}
+
+	rawReader, err := l.layer.Compressed()
+	if err != nil {
+		return "", err
+	}
+	defer rawReader.Close()
+
+	if err := writeCacheFile(blobPath, rawReader); err != nil {
+		return "", fmt.Errorf("unable to populate layer blob cache=%q : %w", blobPath, err)
+	}
+
+	return blobPath, nil
+}
+
 // Read parses information from the underlying layer tar into this struct. This includes layer metadata, the layer
 // file tree, and the layer squash tree.
 func (l *Layer) Read(catalog *FileCatalog, imgMetadata Metadata, idx int, uncompressedLayersCacheDir string) error {
 	var err error
 	l.Tree = filetree.NewFileTree()
+	if l.pathInterner != nil {
+		l.Tree.SetPathInterner(l.pathInterner)
+	}
 	l.fileCatalog = catalog
 	l.Metadata, err = newLayerMetadata(imgMetadata, l.layer, idx)
 	if err != nil {
@@ -87,21 +235,81 @@ func (l *Layer) Read(catalog *FileCatalog, imgMetadata Metadata, idx int, uncomp
 
 	monitor := trackReadProgress(l.Metadata)
 
+	if l.Metadata.Foreign {
+		switch l.foreignLayerPolicy {
+		case SkipForeignLayers:
+			l.warn(SkippedForeignLayerWarning, "", "foreign layer not read (urls=%+v)", l.Metadata.URLs)
+			monitor.SetCompleted()
+			return nil
+		case RejectForeignLayers:
+			return fmt.Errorf("%w: layer=%q urls=%+v", ErrForeignLayer, l.Metadata.Digest, l.Metadata.URLs)
+		default:
+			log.Debugf("reading foreign layer=%q (urls=%+v)", l.Metadata.Digest, l.Metadata.URLs)
+		}
+	}
+
+	l.cacheDir = uncompressedLayersCacheDir
 	tarFilePath, err := l.uncompressedTarCache(uncompressedLayersCacheDir)
 	if err != nil {
 		return err
 	}
 
+	if l.retainCompressed {
+		l.compressedBlobPath, err = l.compressedBlobCache(uncompressedLayersCacheDir)
+		if err != nil {
+			return err
+		}
+	}
+
 	l.indexedContent, err = file.NewTarIndex(tarFilePath, l.indexer(monitor))
 	if err != nil {
 		return fmt.Errorf("failed to read layer=%q tar : %w", l.Metadata.Digest, err)
 	}
 
+	if l.memoryMapped {
+		l.contentLock.Lock()
+		err = l.mapContent(tarFilePath)
+		l.contentLock.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+
 	monitor.SetCompleted()
 
 	return nil
 }
 
+// CompressedContent returns a reader for the original (potentially compressed) layer blob, as it would be pushed to a
+// registry. When the image was read with WithRetainCompressedLayers the blob is read from the cache directory,
+// otherwise it is fetched from the original image source (which may no longer be available).
+func (l *Layer) CompressedContent() (io.ReadCloser, error) {
+	if l.compressedBlobPath != "" {
+		return os.Open(l.compressedBlobPath)
+	}
+	return l.layer.Compressed()
+}
+
+// OpenTar returns a reader for the uncompressed layer tar, read from the layer tar cache when available (re-fetching the
+// layer if the content has been released) and otherwise from the original image source. This is the raw layer tar, so
+// any header transforms or ignored paths configured for the image do not apply.
+func (l *Layer) OpenTar() (io.ReadCloser, error) {
+	if l.cacheDir == "" {
+		return l.layer.Uncompressed()
+	}
+
+	l.contentLock.Lock()
+	defer l.contentLock.Unlock()
+
+	tarPath, err := l.uncompressedTarCache(l.cacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch tar for layer=%q: %w", l.Metadata.Digest, err)
+	}
+	l.contentReleased = false
+
+	return os.Open(tarPath)
+}
+
 // FetchContents reads the file contents for the given path from the underlying layer blob, relative to the layers "diff tree".
 // An error is returned if there is no file at the given path and layer or the read operation cannot continue.
 func (l *Layer) FileContents(path file.Path) (io.ReadCloser, error) {
@@ -114,6 +322,16 @@ func (l *Layer) FileContentsFromSquash(path file.Path) (io.ReadCloser, error) {
 	return fetchFileContentsByPath(l.SquashedTree, l.fileCatalog, path)
 }
 
+// FilesByGlob returns all files that match the given glob pattern relative to the layers "diff tree".
+func (l *Layer) FilesByGlob(query string, options ...filetree.LinkResolutionOption) ([]filetree.GlobResult, error) {
+	return l.Tree.FilesByGlob(query, options...)
+}
+
+// FilesByGlobFromSquash returns all files that match the given glob pattern relative to the layers squashed file tree.
+func (l *Layer) FilesByGlobFromSquash(query string, options ...filetree.LinkResolutionOption) ([]filetree.GlobResult, error) {
+	return l.SquashedTree.FilesByGlob(query, options...)
+}
+
 // FilesByMIMEType returns file references for files that match at least one of the given MIME types relative to each layer tree.
 func (l *Layer) FilesByMIMEType(mimeTypes ...string) ([]file.Reference, error) {
 	var refs []file.Reference
@@ -141,17 +359,69 @@ func (l *Layer) FilesByMIMETypeFromSquash(mimeTypes ...string) ([]file.Reference
 }
 
 func (l *Layer) indexer(monitor *progress.Manual) file.TarIndexVisitor {
+	// all paths found so far in the layer tar, used to validate hardlink targets
+	seen := make(map[file.Path]struct{})
 	return func(index file.TarIndexEntry) error {
 		var err error
 		var entry = index.ToTarFileEntry()
 
-		var contents = index.Open()
+		if l.headerTransform != nil {
+			header, keep := l.headerTransform(&entry.Header)
+			if !keep {
+				monitor.N++
+				return nil
+			}
+			if header != nil {
+				entry.Header = *header
+			}
+		}
+
+		entryPath := file.Path(path.Clean(file.DirSeparator + entry.Header.Name))
+		if l.isIgnoredPath(entryPath) {
+			seen[entryPath] = struct{}{}
+			monitor.N++
+			return nil
+		}
+
+		if entry.Header.Typeflag == tar.TypeLink {
+			target := hardlinkTarget(entry.Header.Linkname)
+			if _, ok := seen[target]; !ok {
+				if l.strictHardlinks {
+					return &ErrBrokenHardlink{Path: string(entryPath), Target: string(target)}
+				}
+				l.warn(BrokenHardlinkWarning, entryPath, "skipped hardlink to missing target=%q", target)
+				monitor.N++
+				return nil
+			}
+		}
+		if _, ok := seen[entryPath]; ok {
+			l.warn(DuplicatePathWarning, entryPath, "more than one tar entry for the path (sequence=%d wins)", entry.Sequence)
+		}
+		seen[entryPath] = struct{}{}
+
+		opener, err := l.contentOpener(index, entry.Sequence, entry.Header)
+		if err != nil {
+			return err
+		}
+
+		var contents = opener()
 		defer func() {
 			if err := contents.Close(); err != nil {
 				log.Warnf("unable to close file while indexing layer: %+v", err)
 			}
 		}()
-		metadata := file.NewMetadata(entry.Header, entry.Sequence, contents)
+		var metadata file.Metadata
+		if l.precomputeDigests && file.TypeFromTarType(entry.Header.Typeflag) == file.TypeReg {
+			metadata, err = newMetadataWithDigest(entry, contents)
+			if err != nil {
+				return err
+			}
+		} else {
+			metadata = file.NewMetadata(entry.Header, entry.Sequence, contents)
+		}
+		if l.pathInterner != nil {
+			metadata.Path = string(l.pathInterner.Intern(file.Path(metadata.Path)))
+		}
 
 		// note: the tar header name is independent of surrounding structure, for example, there may be a tar header entry
 		// for /some/path/to/file.txt without any entries to constituent paths (/some, /some/path, /some/path/to ).
@@ -180,6 +450,11 @@ func (l *Layer) indexer(monitor *progress.Manual) file.TarIndexVisitor {
 			if err != nil {
 				return err
 			}
+		case tar.TypeChar, tar.TypeBlock:
+			fileReference, err = l.Tree.AddDevice(file.Path(metadata.Path), file.Type(metadata.TypeFlag))
+			if err != nil {
+				return err
+			}
 		default:
 			fileReference, err = l.Tree.AddFile(file.Path(metadata.Path))
 			if err != nil {
@@ -191,13 +466,315 @@ func (l *Layer) indexer(monitor *progress.Manual) file.TarIndexVisitor {
 		}
 
 		l.Metadata.Size += metadata.Size
-		l.fileCatalog.Add(*fileReference, metadata, l, index.Open)
+		l.fileCatalog.Add(*fileReference, metadata, l, opener)
 
 		monitor.N++
 		return nil
 	}
 }
 
+// newMetadataWithDigest creates the file metadata for the given tar entry, hashing the contents as they are read for MIME
+// type detection so that the contents are only read once.
+func newMetadataWithDigest(entry file.TarFileEntry, contents io.Reader) (file.Metadata, error) {
+	hasher := sha256.New()
+	reader := io.TeeReader(contents, hasher)
+
+	metadata := file.NewMetadata(entry.Header, entry.Sequence, reader)
+
+	// MIME type detection only reads the head of the file, the remainder is read to complete the digest
+	if _, err := io.Copy(ioutil.Discard, reader); err != nil {
+		return file.Metadata{}, fmt.Errorf("unable to digest contents for path=%q: %w", entry.Header.Name, err)
+	}
+	metadata.Digest = fmt.Sprintf("sha256:%x", hasher.Sum(nil))
+	return metadata, nil
+}
+
+// isIgnoredPath indicates if the given path (or any of its parent directories) matches any of the ignore path patterns.
+// Whiteout entries are matched by the path that they remove, so a whiteout is only ignored when everything it would
+// remove is ignored as well.
+func (l *Layer) isIgnoredPath(p file.Path) bool {
+	if len(l.ignorePaths) == 0 {
+		return false
+	}
+	if p.IsWhiteout() && !p.IsWhiteoutMetadata() {
+		target, err := p.UnWhiteoutPath()
+		if err != nil {
+			return false
+		}
+		p = target
+	}
+	for _, pattern := range l.ignorePaths {
+		for _, candidate := range []string{pattern, pattern + "/**"} {
+			if matched, _ := doublestar.Match(candidate, string(p)); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contentOpener returns the file.Opener to use for the given tar entry. Regular files at or below the memory threshold
+// are read into memory once, all other content is read from the layer tar cache on demand.
+func (l *Layer) contentOpener(index file.TarIndexEntry, sequence int64, header tar.Header) (file.Opener, error) {
+	opener := func() io.ReadCloser {
+		return l.openContent(index, sequence)
+	}
+
+	if l.memoryThreshold <= 0 || file.TypeFromTarType(header.Typeflag) != file.TypeReg || header.Size > l.memoryThreshold {
+		return opener, nil
+	}
+
+	reader := index.Open()
+	defer reader.Close()
+
+	contents, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read contents for path=%q: %w", header.Name, err)
+	}
+
+	l.contentLock.Lock()
+	defer l.contentLock.Unlock()
+	if l.inMemoryContent == nil {
+		l.inMemoryContent = make(map[int64][]byte)
+	}
+	l.inMemoryContent[sequence] = contents
+
+	return opener, nil
+}
+
+// openContent returns a reader for the given tar entry, preferring in-memory content. If the layer content has been
+// released then the layer tar cache is re-fetched from the image source first.
+func (l *Layer) openContent(index file.TarIndexEntry, sequence int64) io.ReadCloser {
+	l.contentLock.Lock()
+	defer l.contentLock.Unlock()
+
+	if contents, ok := l.inMemoryContent[sequence]; ok {
+		return ioutil.NopCloser(bytes.NewReader(contents))
+	}
+
+	if l.contentReleased {
+		log.Debugf("re-fetching released content for layer=%q", l.Metadata.Digest)
+		if _, err := l.uncompressedTarCache(l.cacheDir); err != nil {
+			return &errorReadCloser{err: fmt.Errorf("unable to re-fetch released content for layer=%q: %w", l.Metadata.Digest, err)}
+		}
+		l.contentReleased = false
+	}
+
+	if l.memoryMapped && l.mappedContent == nil {
+		if err := l.mapContent(path.Join(l.cacheDir, l.Metadata.Digest+".tar")); err != nil {
+			return &errorReadCloser{err: err}
+		}
+	}
+	if l.mappedContent != nil {
+		return index.OpenMapped(l.mappedContent)
+	}
+
+	return index.Open()
+}
+
+// mapContent memory maps the given layer tar cache (the content lock must be held). If memory mapping is not supported
+// then the layer tar cache is read from disk as usual.
+func (l *Layer) mapContent(tarPath string) error {
+	mapped, err := file.MapFile(tarPath)
+	if errors.Is(err, file.ErrMemoryMapUnsupported) {
+		log.Debugf("unable to memory map layer=%q, reading from the layer tar cache instead: %+v", l.Metadata.Digest, err)
+		l.memoryMapped = false
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to memory map layer=%q: %w", l.Metadata.Digest, err)
+	}
+	l.mappedContent = mapped
+	return nil
+}
+
+// unmapContent releases the memory mapping of the layer tar cache (if any). Later content reads do not map the layer tar
+// cache again.
+func (l *Layer) unmapContent() error {
+	l.contentLock.Lock()
+	defer l.contentLock.Unlock()
+
+	l.memoryMapped = false
+	return l.releaseMapping()
+}
+
+// releaseMapping unmaps the layer tar cache (the content lock must be held).
+func (l *Layer) releaseMapping() error {
+	if l.mappedContent == nil {
+		return nil
+	}
+	mapped := l.mappedContent
+	l.mappedContent = nil
+	if err := mapped.Close(); err != nil {
+		return fmt.Errorf("unable to unmap layer=%q: %w", l.Metadata.Digest, err)
+	}
+	return nil
+}
+
+// warmContent reads the contents of the tar entries with the given sequences into memory in a single pass over the layer
+// tar, so that subsequent reads of those entries do not need to seek into the layer tar cache. Entries already held in
+// memory are not read again, and entries larger than the given size are skipped (left to be read from the layer tar
+// cache).
+func (l *Layer) warmContent(sequences map[int64]file.Reference, maxSize int64) error {
+	l.contentLock.Lock()
+	pending := make(map[int64]struct{})
+	for sequence := range sequences {
+		if _, ok := l.inMemoryContent[sequence]; !ok {
+			pending[sequence] = struct{}{}
+		}
+	}
+	l.contentLock.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	reader, err := l.OpenTar()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return file.IterateTar(reader, func(entry file.TarFileEntry) error {
+		if _, ok := pending[entry.Sequence]; !ok {
+			return nil
+		}
+
+		if entry.Header.Size > maxSize {
+			log.Debugf("not warming content for path=%q (size=%d exceeds %d bytes)", entry.Header.Name, entry.Header.Size, maxSize)
+			delete(pending, entry.Sequence)
+			if len(pending) == 0 {
+				return file.ErrTarStopIteration
+			}
+			return nil
+		}
+
+		contents, err := ioutil.ReadAll(entry.Reader)
+		if err != nil {
+			return fmt.Errorf("unable to read contents for path=%q: %w", entry.Header.Name, err)
+		}
+
+		l.contentLock.Lock()
+		if l.inMemoryContent == nil {
+			l.inMemoryContent = make(map[int64][]byte)
+		}
+		l.inMemoryContent[entry.Sequence] = contents
+		l.contentLock.Unlock()
+
+		delete(pending, entry.Sequence)
+		if len(pending) == 0 {
+			return file.ErrTarStopIteration
+		}
+		return nil
+	})
+}
+
+// streamContent invokes the given function with the contents of the tar entry for each of the given references (by tar
+// sequence) in a single pass over the layer tar. An ErrFileNotFound is returned if any of the references have no tar
+// entry in the layer.
+func (l *Layer) streamContent(refs map[int64]file.Reference, fn func(file.Reference, io.Reader) error) error {
+	reader, err := l.OpenTar()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	pending := make(map[int64]struct{}, len(refs))
+	for sequence := range refs {
+		pending[sequence] = struct{}{}
+	}
+
+	err = file.IterateTar(reader, func(entry file.TarFileEntry) error {
+		ref, ok := refs[entry.Sequence]
+		if !ok {
+			return nil
+		}
+
+		if err := fn(ref, entry.Reader); err != nil {
+			return err
+		}
+
+		delete(pending, entry.Sequence)
+		if len(pending) == 0 {
+			return file.ErrTarStopIteration
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(pending) > 0 {
+		var missing []string
+		for sequence := range pending {
+			missing = append(missing, string(refs[sequence].RealPath))
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("%w: %+v", ErrFileNotFound, missing)
+	}
+	return nil
+}
+
+// checkContent verifies that the contents of the tar entry with the given metadata can be fetched (without reading the
+// contents). If the layer content has been released then the layer tar cache is re-fetched from the image source first.
+func (l *Layer) checkContent(m file.Metadata) error {
+	l.contentLock.Lock()
+	defer l.contentLock.Unlock()
+
+	if _, ok := l.inMemoryContent[m.TarSequence]; ok {
+		return nil
+	}
+
+	if l.indexedContent == nil {
+		return fmt.Errorf("layer=%q has not been indexed", l.Metadata.Digest)
+	}
+
+	if l.contentReleased {
+		if _, err := l.uncompressedTarCache(l.cacheDir); err != nil {
+			return fmt.Errorf("unable to re-fetch released content for layer=%q: %w", l.Metadata.Digest, err)
+		}
+		l.contentReleased = false
+	}
+
+	return l.indexedContent.CheckEntry(m.TarHeaderName, m.TarSequence)
+}
+
+// releaseContent drops all in-memory content and removes the layer tar cache, keeping all layer metadata.
+func (l *Layer) releaseContent() error {
+	l.contentLock.Lock()
+	defer l.contentLock.Unlock()
+
+	l.inMemoryContent = nil
+
+	if err := l.releaseMapping(); err != nil {
+		return err
+	}
+
+	if l.cacheDir == "" || l.contentReleased {
+		return nil
+	}
+
+	tarPath := path.Join(l.cacheDir, l.Metadata.Digest+".tar")
+	if err := os.Remove(tarPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to remove layer cache=%q : %w", tarPath, err)
+	}
+	l.contentReleased = true
+	return nil
+}
+
+// errorReadCloser is an io.ReadCloser that always returns the given error on read.
+type errorReadCloser struct {
+	err error
+}
+
+func (e *errorReadCloser) Read([]byte) (int, error) {
+	return 0, e.err
+}
+
+func (e *errorReadCloser) Close() error {
+	return nil
+}
+
 func trackReadProgress(metadata LayerMetadata) *progress.Manual {
 	p := &progress.Manual{}
 
@@ -209,3 +786,26 @@ func trackReadProgress(metadata LayerMetadata) *progress.Manual {
 
 	return p
 }
+
+// trackDecompressionProgress publishes a monitorable event for decompressing the layer tar when the layer is at least
+// as large as the configured threshold, otherwise nil is returned. The total size is unknown until the layer has been
+// fully decompressed, so only the number of uncompressed bytes read so far is reported.
+func (l *Layer) trackDecompressionProgress() *progress.Manual {
+	threshold := l.decompressionProgressThreshold
+	if threshold == 0 {
+		threshold = DefaultDecompressionProgressThreshold
+	}
+	if threshold < 0 || l.Metadata.CompressedSize < threshold {
+		return nil
+	}
+
+	p := &progress.Manual{}
+
+	bus.Publish(partybus.Event{
+		Type:   event.DecompressLayer,
+		Source: l.Metadata,
+		Value:  progress.Monitorable(p),
+	})
+
+	return p
+}
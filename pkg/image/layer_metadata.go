@@ -1,7 +1,9 @@
 package image
 
 import (
+	"github.com/anchore/stereoscope/internal/log"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
 	v1Types "github.com/google/go-containerregistry/pkg/v1/types"
 )
 
@@ -11,8 +13,15 @@ type LayerMetadata struct {
 	// Digest is the sha256 digest of the layer contents (the docker "diff id")
 	Digest    string
 	MediaType v1Types.MediaType
-	// Size in bytes of the layer content size
+	// Size in bytes of the uncompressed layer content (the sum of all file sizes within the layer tar)
 	Size int64
+	// CompressedSize in bytes of the layer blob as stored in the registry / archive (the "on-the-wire" size)
+	CompressedSize int64
+	// Foreign indicates that the layer is a foreign (non-distributable) layer, which is typically not stored in the
+	// registry and instead is fetched from external URLs (e.g. Windows base layers)
+	Foreign bool
+	// URLs are the external locations the layer blob may be fetched from (only applicable for foreign layers)
+	URLs []string
 }
 
 // newLayerMetadata aggregates pertinent layer metadata information.
@@ -22,11 +31,39 @@ func newLayerMetadata(imgMetadata Metadata, layer v1.Layer, idx int) (LayerMetad
 		return LayerMetadata{}, err
 	}
 
+	// note: this is the size of the (potentially compressed) layer blob, not the uncompressed content
+	compressedSize, err := layer.Size()
+	if err != nil {
+		return LayerMetadata{}, err
+	}
+
+	var urls []string
+	foreign := isForeignLayerMediaType(mediaType)
+	if foreign {
+		if descriptor, err := partial.Descriptor(layer); err == nil {
+			urls = descriptor.URLs
+		} else {
+			log.Debugf("unable to get descriptor for foreign layer: %+v", err)
+		}
+	}
+
 	// digest = diff-id = a digest of the uncompressed layer content
 	diffIDHash := imgMetadata.Config.RootFS.DiffIDs[idx]
 	return LayerMetadata{
-		Index:     uint(idx),
-		Digest:    diffIDHash.String(),
-		MediaType: mediaType,
+		Index:          uint(idx),
+		Digest:         diffIDHash.String(),
+		MediaType:      mediaType,
+		CompressedSize: compressedSize,
+		Foreign:        foreign,
+		URLs:           urls,
 	}, nil
 }
+
+// isForeignLayerMediaType indicates if the given layer media type describes a foreign (non-distributable) layer.
+func isForeignLayerMediaType(mediaType v1Types.MediaType) bool {
+	switch mediaType {
+	case v1Types.DockerForeignLayer, v1Types.OCIRestrictedLayer, v1Types.OCIUncompressedRestrictedLayer:
+		return true
+	}
+	return false
+}
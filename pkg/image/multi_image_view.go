@@ -0,0 +1,93 @@
+package image
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/anchore/stereoscope/pkg/filetree"
+)
+
+// MultiImageView provides a unified, read-only query surface over several images overlaid on top of one another (for
+// example a base image and an application image whose files are copied on top of it).
+//
+// Images are given in priority order: the first image has the highest priority. Overlaying follows the same semantics
+// as squashing layers within an image; a path in a higher priority image replaces the same path in all lower priority
+// images (a non-directory replacing a directory hides all of the directory contents). Whiteout files are only
+// interpreted within the image they are found in (while squashing that image's layers), so an image cannot remove
+// files from other images in the view.
+type MultiImageView struct {
+	images []*Image
+	tree   *filetree.FileTree
+}
+
+// NewMultiImageView creates a view of the given (already read) images, where the first image has the highest priority.
+func NewMultiImageView(images ...*Image) (*MultiImageView, error) {
+	unionTree := filetree.NewUnionFileTree()
+	// the union tree expects the lowest layer first
+	for idx := len(images) - 1; idx >= 0; idx-- {
+		unionTree.PushTree(images[idx].SquashedTree())
+	}
+
+	tree, err := unionTree.Squash()
+	if err != nil {
+		return nil, fmt.Errorf("unable to overlay images: %w", err)
+	}
+
+	return &MultiImageView{
+		images: images,
+		tree:   tree,
+	}, nil
+}
+
+// SquashedTree returns the file tree representing all images overlaid in priority order.
+func (v *MultiImageView) SquashedTree() *filetree.FileTree {
+	return v.tree
+}
+
+// FileContentsFromSquash fetches file contents for a single path, relative to the overlaid tree of all images.
+// If the path does not exist an error is returned.
+func (v *MultiImageView) FileContentsFromSquash(path file.Path) (io.ReadCloser, error) {
+	ref, err := fetchFileReferenceByPath(v.tree, path)
+	if err != nil {
+		return nil, err
+	}
+	return v.FileContentsByRef(*ref)
+}
+
+// FileContentsByRef fetches file contents for a single file reference from whichever image in the view the
+// reference originated from.
+func (v *MultiImageView) FileContentsByRef(ref file.Reference) (io.ReadCloser, error) {
+	img := v.ImageByRef(ref)
+	if img == nil {
+		return nil, fmt.Errorf("%w: %+v", ErrFileNotFound, ref.RealPath)
+	}
+	return img.FileCatalog.FileContents(ref)
+}
+
+// ImageByRef returns the image in the view that the given file reference originated from (or nil if the reference
+// is not from any image in the view).
+func (v *MultiImageView) ImageByRef(ref file.Reference) *Image {
+	for _, img := range v.images {
+		if img.FileCatalog.Exists(ref) {
+			return img
+		}
+	}
+	return nil
+}
+
+// FilesByMIMETypeFromSquash returns file references for files that match at least one of the given MIME types,
+// relative to the overlaid tree of all images.
+func (v *MultiImageView) FilesByMIMETypeFromSquash(mimeTypes ...string) ([]file.Reference, error) {
+	var refs []file.Reference
+	for _, img := range v.images {
+		for _, ty := range mimeTypes {
+			refsForType, err := fetchFilesByMIMEType(v.tree, &img.FileCatalog, ty)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, refsForType...)
+		}
+	}
+	return refs, nil
+}
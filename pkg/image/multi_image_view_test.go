@@ -0,0 +1,95 @@
+package image
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiImageView(t *testing.T) {
+	base := newTestImage(t,
+		testLayer{
+			digest: "sha256:base-1",
+			paths:  []string{"/etc/os-release", "/etc/motd", "/app/placeholder"},
+			contents: map[string]string{
+				"/etc/os-release":  "ID=debian",
+				"/etc/motd":        "base motd",
+				"/app/placeholder": "placeholder",
+			},
+		},
+	)
+
+	app := newTestImage(t,
+		testLayer{
+			digest: "sha256:app-1",
+			paths:  []string{"/etc/motd", "/app/server"},
+			contents: map[string]string{
+				"/etc/motd":   "app motd",
+				"/app/server": "server binary",
+			},
+		},
+	)
+
+	view, err := NewMultiImageView(app, base)
+	if err != nil {
+		t.Fatalf("could not create view: %+v", err)
+	}
+
+	tests := []struct {
+		path          file.Path
+		expected      string
+		expectedImage *Image
+	}{
+		{
+			// only in the base image
+			path:          "/etc/os-release",
+			expected:      "ID=debian",
+			expectedImage: base,
+		},
+		{
+			// the higher priority image takes precedence
+			path:          "/etc/motd",
+			expected:      "app motd",
+			expectedImage: app,
+		},
+		{
+			// directories are merged across images
+			path:          "/app/placeholder",
+			expected:      "placeholder",
+			expectedImage: base,
+		},
+		{
+			path:          "/app/server",
+			expected:      "server binary",
+			expectedImage: app,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.path), func(t *testing.T) {
+			reader, err := view.FileContentsFromSquash(test.path)
+			if err != nil {
+				t.Fatalf("could not get contents: %+v", err)
+			}
+			contents, err := ioutil.ReadAll(reader)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, string(contents))
+
+			_, ref, err := view.SquashedTree().File(test.path)
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedImage, view.ImageByRef(*ref))
+		})
+	}
+
+	_, err = view.FileContentsFromSquash("/missing")
+	assert.Error(t, err)
+
+	// the original images are not affected by the view
+	reader, err := base.FileContentsFromSquash("/etc/motd")
+	assert.NoError(t, err)
+	contents, err := ioutil.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "base motd", string(contents))
+}
@@ -2,12 +2,23 @@ package oci
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/anchore/stereoscope/internal/log"
 	"github.com/anchore/stereoscope/pkg/file"
 	"github.com/anchore/stereoscope/pkg/image"
+	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/layout"
 )
 
+const (
+	// refNameAnnotation is the OCI index annotation holding the name of the image (e.g. "localhost/app:latest" as
+	// written by buildah, however, tools such as skopeo only write the tag, e.g. "latest")
+	refNameAnnotation = "org.opencontainers.image.ref.name"
+	// containerdImageNameAnnotation is the index annotation holding the full image name as written by containerd
+	containerdImageNameAnnotation = "io.containerd.image.name"
+)
+
 // DirectoryImageProvider is an image.Provider for an OCI image (V1) for an existing tar on disk (from a buildah push <img> oci:<img> command).
 type DirectoryImageProvider struct {
 	path      string
@@ -54,6 +65,10 @@ func (p *DirectoryImageProvider) Provide() (*image.Image, error) {
 		image.WithManifestDigest(manifest.Digest.String()),
 	}
 
+	if tags := tagsFromAnnotations(manifest.Annotations); len(tags) > 0 {
+		metadata = append(metadata, image.WithTags(tags...))
+	}
+
 	// make a best-effort attempt at getting the raw indexManifest
 	rawManifest, err := img.RawManifest()
 	if err == nil {
@@ -67,3 +82,32 @@ func (p *DirectoryImageProvider) Provide() (*image.Image, error) {
 
 	return image.NewImage(img, contentTempDir, metadata...), nil
 }
+
+// tagsFromAnnotations returns the image tags recorded in the annotations of an OCI index manifest entry. Since OCI
+// layouts have no notion of repo tags (as docker archives do), the image name is only available from annotations. Ref
+// names that are only a tag (without a repository, e.g. "latest") cannot be represented as a tag and are ignored.
+func tagsFromAnnotations(annotations map[string]string) []string {
+	var tags []string
+	for _, key := range []string{containerdImageNameAnnotation, refNameAnnotation} {
+		value, ok := annotations[key]
+		if !ok || !isFullyQualifiedRefName(value) {
+			continue
+		}
+		tag, err := name.NewTag(value)
+		if err != nil {
+			log.Debugf("ignoring invalid image name in annotation=%q: %q", key, value)
+			continue
+		}
+		if len(tags) == 0 || tags[0] != tag.String() {
+			tags = append(tags, tag.String())
+		}
+	}
+	return tags
+}
+
+// isFullyQualifiedRefName indicates if the given ref name includes both a repository and a tag (e.g. "app:latest", as
+// opposed to only the tag "latest").
+func isFullyQualifiedRefName(refName string) bool {
+	idx := strings.LastIndex(refName, ":")
+	return idx > 0 && !strings.Contains(refName[idx:], "/")
+}
@@ -0,0 +1,70 @@
+package oci
+
+import (
+	"testing"
+
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectoryImageProvider_Provide_TagsFromAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		expected    []string
+	}{
+		{
+			name:        "buildah ref name",
+			annotations: map[string]string{refNameAnnotation: "localhost/app:1.0"},
+			expected:    []string{"localhost/app:1.0"},
+		},
+		{
+			name: "containerd image name",
+			annotations: map[string]string{
+				containerdImageNameAnnotation: "ghcr.io/anchore/alpine:3.15",
+				refNameAnnotation:             "3.15",
+			},
+			expected: []string{"ghcr.io/anchore/alpine:3.15"},
+		},
+		{
+			name:        "ref name without a repository",
+			annotations: map[string]string{refNameAnnotation: "latest"},
+		},
+		{
+			name:        "registry with port but no tag",
+			annotations: map[string]string{refNameAnnotation: "localhost:5000/app"},
+		},
+		{
+			name: "no annotations",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img, err := random.Image(1024, 1)
+			require.NoError(t, err)
+
+			dir := t.TempDir()
+			p, err := layout.Write(dir, empty.Index)
+			require.NoError(t, err)
+			require.NoError(t, p.AppendImage(img, layout.WithAnnotations(test.annotations)))
+
+			tmpDirGen := file.NewTempDirGenerator()
+			defer tmpDirGen.Cleanup()
+
+			provided, err := NewProviderFromPath(dir, &tmpDirGen).Provide()
+			require.NoError(t, err)
+			require.NoError(t, provided.Read())
+
+			var actual []string
+			for _, tag := range provided.Metadata.Tags {
+				actual = append(actual, tag.String())
+			}
+			assert.Equal(t, test.expected, actual)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package oci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/anchore/stereoscope/internal/log"
+	"github.com/anchore/stereoscope/pkg/image"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+var errReferrersAPINotSupported = errors.New("registry does not support the referrers API")
+
+// referrersIndex is the subset of an OCI image index (as returned by the referrers API or found at a referrers tag)
+// needed to describe each referrer.
+type referrersIndex struct {
+	Manifests []referrerDescriptor `json:"manifests"`
+}
+
+type referrerDescriptor struct {
+	MediaType    types.MediaType   `json:"mediaType"`
+	ArtifactType string            `json:"artifactType,omitempty"`
+	Digest       string            `json:"digest"`
+	Size         int64             `json:"size"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+}
+
+func (i referrersIndex) referrers() []image.Referrer {
+	referrers := make([]image.Referrer, 0, len(i.Manifests))
+	for _, m := range i.Manifests {
+		referrers = append(referrers, image.Referrer{
+			ArtifactType: m.ArtifactType,
+			MediaType:    m.MediaType,
+			Digest:       m.Digest,
+			Size:         m.Size,
+			Annotations:  m.Annotations,
+		})
+	}
+	return referrers
+}
+
+// newReferrersFetcher creates an image.ReferrersFetcher for the image with the given digest within the repository
+// of the given reference.
+func newReferrersFetcher(ref name.Reference, digest v1.Hash, registryOptions *image.RegistryOptions) image.ReferrersFetcher {
+	if registryOptions == nil {
+		registryOptions = &image.RegistryOptions{}
+	}
+	return func() ([]image.Referrer, error) {
+		referrers, err := referrersFromAPI(ref, digest, registryOptions)
+		if err == nil {
+			return referrers, nil
+		}
+		if !errors.Is(err, errReferrersAPINotSupported) {
+			return nil, err
+		}
+
+		log.Debugf("registry does not support the referrers API, falling back to the referrers tag schema")
+		return referrersFromTagSchema(ref, digest, registryOptions)
+	}
+}
+
+// referrersFromAPI queries the OCI distribution spec referrers API for the given digest.
+func referrersFromAPI(ref name.Reference, digest v1.Hash, registryOptions *image.RegistryOptions) ([]image.Referrer, error) {
+	repo := ref.Context()
+
+	authenticator, err := prepareAuthenticator(ref, registryOptions)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve registry credentials: %w", err)
+	}
+
+	rt, err := transport.New(repo.Registry, authenticator, prepareTransport(registryOptions), []string{repo.Scope(transport.PullScope)})
+	if err != nil {
+		return nil, fmt.Errorf("unable to create registry transport: %w", err)
+	}
+
+	u := url.URL{
+		Scheme: repo.Registry.Scheme(),
+		Host:   repo.RegistryStr(),
+		Path:   fmt.Sprintf("/v2/%s/referrers/%s", repo.RepositoryStr(), digest.String()),
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", string(types.OCIImageIndex))
+
+	resp, err := (&http.Client{Transport: rt}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query referrers API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, errReferrersAPINotSupported
+	default:
+		return nil, fmt.Errorf("unexpected status from referrers API: %s", resp.Status)
+	}
+
+	contents, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read referrers API response: %w", err)
+	}
+
+	return parseReferrersIndex(contents)
+}
+
+// referrersFromTagSchema fetches the referrers index from the "<alg>-<hex>" tag, used by registries that do not
+// support the referrers API. If there is no such tag then no referrers are returned.
+func referrersFromTagSchema(ref name.Reference, digest v1.Hash, registryOptions *image.RegistryOptions) ([]image.Referrer, error) {
+	tag := ref.Context().Tag(fmt.Sprintf("%s-%s", digest.Algorithm, digest.Hex))
+
+	descriptor, err := remote.Get(tag, prepareRemoteOptions(ref, registryOptions)...)
+	if err != nil {
+		var transportErr *transport.Error
+		if errors.As(err, &transportErr) && transportErr.StatusCode == http.StatusNotFound {
+			return []image.Referrer{}, nil
+		}
+		return nil, fmt.Errorf("unable to fetch referrers tag=%q: %w", tag, err)
+	}
+
+	return parseReferrersIndex(descriptor.Manifest)
+}
+
+func parseReferrersIndex(contents []byte) ([]image.Referrer, error) {
+	var index referrersIndex
+	if err := json.Unmarshal(contents, &index); err != nil {
+		return nil, fmt.Errorf("unable to parse referrers index: %w", err)
+	}
+	return index.referrers(), nil
+}
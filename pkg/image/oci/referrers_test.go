@@ -0,0 +1,109 @@
+package oci
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anchore/stereoscope/pkg/image"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const referrersIndexFixture = `{
+  "schemaVersion": 2,
+  "mediaType": "application/vnd.oci.image.index.v1+json",
+  "manifests": [
+    {
+      "mediaType": "application/vnd.oci.image.manifest.v1+json",
+      "artifactType": "application/spdx+json",
+      "digest": "sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270",
+      "size": 1234,
+      "annotations": {
+        "org.opencontainers.image.created": "2022-01-01T00:00:00Z"
+      }
+    }
+  ]
+}`
+
+func TestReferrersFetcher(t *testing.T) {
+	digest := v1.Hash{
+		Algorithm: "sha256",
+		Hex:       "6c3c624b58dbbcd3c0dd82b4c53f04194d1247c6eebdaab7c610cf7d66709b3b",
+	}
+
+	expected := []image.Referrer{
+		{
+			ArtifactType: "application/spdx+json",
+			MediaType:    types.OCIManifestSchema1,
+			Digest:       "sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270",
+			Size:         1234,
+			Annotations: map[string]string{
+				"org.opencontainers.image.created": "2022-01-01T00:00:00Z",
+			},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		referrersAPI  bool
+		referrersTag  bool
+		expected      []image.Referrer
+		expectedPaths []string
+	}{
+		{
+			name:          "registry supports the referrers API",
+			referrersAPI:  true,
+			expected:      expected,
+			expectedPaths: []string{"/v2/some/repo/referrers/" + digest.String()},
+		},
+		{
+			name:          "fallback to the referrers tag schema",
+			referrersTag:  true,
+			expected:      expected,
+			expectedPaths: []string{"/v2/some/repo/referrers/" + digest.String(), "/v2/some/repo/manifests/sha256-" + digest.Hex},
+		},
+		{
+			name:          "no referrers available",
+			expected:      []image.Referrer{},
+			expectedPaths: []string{"/v2/some/repo/referrers/" + digest.String(), "/v2/some/repo/manifests/sha256-" + digest.Hex},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requestedPaths []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/v2/" {
+					w.WriteHeader(http.StatusOK)
+					return
+				}
+				requestedPaths = append(requestedPaths, r.URL.Path)
+				switch {
+				case test.referrersAPI && strings.HasPrefix(r.URL.Path, "/v2/some/repo/referrers/"),
+					test.referrersTag && strings.HasPrefix(r.URL.Path, "/v2/some/repo/manifests/"):
+					w.Header().Set("Content-Type", string(types.OCIImageIndex))
+					fmt.Fprint(w, referrersIndexFixture)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			ref, err := name.ParseReference(strings.TrimPrefix(server.URL, "http://")+"/some/repo:latest", name.Insecure)
+			require.NoError(t, err)
+
+			fetcher := newReferrersFetcher(ref, digest, &image.RegistryOptions{InsecureUseHTTP: true})
+
+			actual, err := fetcher()
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, actual)
+			assert.Equal(t, test.expectedPaths, requestedPaths)
+		})
+	}
+}
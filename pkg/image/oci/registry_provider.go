@@ -1,6 +1,7 @@
 package oci
 
 import (
+	"bytes"
 	"crypto/tls"
 	"fmt"
 	"net/http"
@@ -10,9 +11,14 @@ import (
 	"github.com/anchore/stereoscope/pkg/image"
 	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 )
 
+// ErrDigestMismatch is returned when the manifest fetched for a digest reference (repo@sha256:...) does not match the
+// requested digest.
+var ErrDigestMismatch = fmt.Errorf("fetched manifest does not match the requested digest")
+
 // RegistryImageProvider is a image.Provider capable of fetching and representing a container image fetched from a remote registry (described by the OCI distribution spec).
 type RegistryImageProvider struct {
 	imageStr        string
@@ -48,6 +54,16 @@ func (p *RegistryImageProvider) Provide() (*image.Image, error) {
 		return nil, fmt.Errorf("failed to get image descriptor from registry: %+v", err)
 	}
 
+	if err := verifyPinnedDigest(ref, descriptor); err != nil {
+		return nil, err
+	}
+
+	if p.registryOptions != nil && p.registryOptions.SignatureVerificationKey != nil {
+		if err := VerifySignature(ref, descriptor.Digest, p.registryOptions.SignatureVerificationKey, p.registryOptions); err != nil {
+			return nil, fmt.Errorf("image signature verification failed: %w", err)
+		}
+	}
+
 	img, err := descriptor.Image()
 	if err != nil {
 		return nil, fmt.Errorf("failed to get image from registry: %+v", err)
@@ -59,6 +75,7 @@ func (p *RegistryImageProvider) Provide() (*image.Image, error) {
 
 	metadata := []image.AdditionalMetadata{
 		image.WithRepoDigests([]string{repoDigest}),
+		image.WithReferrersFetcher(newReferrersFetcher(ref, descriptor.Digest, p.registryOptions)),
 	}
 
 	// make a best effort to get the manifest, should not block getting an image though if it fails
@@ -69,6 +86,26 @@ func (p *RegistryImageProvider) Provide() (*image.Image, error) {
 	return image.NewImage(img, imageTempDir, metadata...), nil
 }
 
+// verifyPinnedDigest ensures that the fetched manifest is exactly the one requested when pulling by digest, so the
+// analyzed image can never differ from what was pinned (e.g. due to a misbehaving registry or proxy). The digest is
+// computed from the raw manifest instead of trusting the digest reported by the registry.
+func verifyPinnedDigest(ref name.Reference, descriptor *remote.Descriptor) error {
+	pinned, ok := ref.(name.Digest)
+	if !ok {
+		return nil
+	}
+
+	actual, _, err := v1.SHA256(bytes.NewReader(descriptor.Manifest))
+	if err != nil {
+		return fmt.Errorf("unable to digest fetched manifest: %w", err)
+	}
+
+	if actual.String() != pinned.DigestStr() || descriptor.Digest != actual {
+		return fmt.Errorf("%w: requested=%q fetched=%q", ErrDigestMismatch, pinned.DigestStr(), actual.String())
+	}
+	return nil
+}
+
 func prepareReferenceOptions(registryOptions *image.RegistryOptions) []name.Option {
 	var options []name.Option
 	if registryOptions != nil && registryOptions.InsecureUseHTTP {
@@ -78,12 +115,8 @@ func prepareReferenceOptions(registryOptions *image.RegistryOptions) []name.Opti
 }
 
 func prepareRemoteOptions(ref name.Reference, registryOptions *image.RegistryOptions) (opts []remote.Option) {
-	if registryOptions.InsecureSkipTLSVerify {
-		t := &http.Transport{
-			// nolint: gosec
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-		opts = append(opts, remote.WithTransport(t))
+	if registryOptions.InsecureSkipTLSVerify || registryOptions.Retry.MaxAttempts > 1 || registryOptions.Transport != nil {
+		opts = append(opts, remote.WithTransport(prepareTransport(registryOptions)))
 	}
 
 	// note: the authn.Authenticator and authn.Keychain options are mutually exclusive, only one may be provided.
@@ -99,3 +132,46 @@ func prepareRemoteOptions(ref name.Reference, registryOptions *image.RegistryOpt
 
 	return
 }
+
+// prepareTransport returns the base HTTP transport to use for all registry interactions.
+func prepareTransport(registryOptions *image.RegistryOptions) http.RoundTripper {
+	var base = http.DefaultTransport
+	if registryOptions.Transport != nil {
+		base = registryOptions.Transport
+	}
+	if registryOptions.InsecureSkipTLSVerify {
+		base = insecureTransport(base)
+	}
+	if registryOptions.Retry.MaxAttempts > 1 {
+		return newRetryTransport(base, registryOptions.Retry)
+	}
+	return base
+}
+
+// insecureTransport returns a copy of the given transport that skips TLS verification. Only *http.Transport values can
+// be reconfigured, any other transport is returned as-is (the caller is responsible for its TLS configuration).
+func insecureTransport(base http.RoundTripper) http.RoundTripper {
+	httpTransport, ok := base.(*http.Transport)
+	if !ok {
+		log.Warnf("unable to skip TLS verification for custom registry transport (%T)", base)
+		return base
+	}
+	// cloning keeps all other settings, such as the proxy configuration
+	insecure := httpTransport.Clone()
+	if insecure.TLSClientConfig == nil {
+		insecure.TLSClientConfig = &tls.Config{}
+	}
+	// nolint: gosec
+	insecure.TLSClientConfig.InsecureSkipVerify = true
+	return insecure
+}
+
+// prepareAuthenticator returns the explicitly configured authenticator for the given registry, falling back to the
+// default keychain (specified from a docker config file) if there are no matching credentials.
+func prepareAuthenticator(ref name.Reference, registryOptions *image.RegistryOptions) (authn.Authenticator, error) {
+	authenticator := registryOptions.Authenticator(ref.Context().RegistryStr())
+	if authenticator != nil {
+		return authenticator, nil
+	}
+	return authn.DefaultKeychain.Resolve(ref.Context())
+}
@@ -1,12 +1,25 @@
 package oci
 
 import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
 	"reflect"
+	"strings"
 	"testing"
 
+	"github.com/anchore/stereoscope/pkg/file"
 	"github.com/anchore/stereoscope/pkg/image"
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func Test_prepareReferenceOptions(t *testing.T) {
@@ -44,3 +57,172 @@ func Test_prepareReferenceOptions(t *testing.T) {
 		})
 	}
 }
+
+type stubTransport struct{}
+
+func (s *stubTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func Test_prepareTransport(t *testing.T) {
+	custom := &stubTransport{}
+
+	tests := []struct {
+		name   string
+		input  image.RegistryOptions
+		assert func(t *testing.T, rt http.RoundTripper)
+	}{
+		{
+			name:  "default transport",
+			input: image.RegistryOptions{},
+			assert: func(t *testing.T, rt http.RoundTripper) {
+				assert.Equal(t, http.DefaultTransport, rt)
+			},
+		},
+		{
+			name:  "custom transport",
+			input: image.RegistryOptions{}.WithTransport(custom),
+			assert: func(t *testing.T, rt http.RoundTripper) {
+				assert.Equal(t, custom, rt)
+			},
+		},
+		{
+			name:  "custom transport with retries",
+			input: image.RegistryOptions{}.WithTransport(custom).WithRetry(3, 0),
+			assert: func(t *testing.T, rt http.RoundTripper) {
+				retry, ok := rt.(*retryTransport)
+				if assert.True(t, ok, "expected retry transport, got %T", rt) {
+					assert.Equal(t, custom, retry.inner)
+				}
+			},
+		},
+		{
+			name: "insecure transport keeps proxy settings",
+			input: image.RegistryOptions{
+				InsecureSkipTLSVerify: true,
+				Transport:             &http.Transport{Proxy: http.ProxyFromEnvironment},
+			},
+			assert: func(t *testing.T, rt http.RoundTripper) {
+				httpTransport, ok := rt.(*http.Transport)
+				if assert.True(t, ok, "expected http transport, got %T", rt) {
+					assert.True(t, httpTransport.TLSClientConfig.InsecureSkipVerify)
+					assert.NotNil(t, httpTransport.Proxy)
+				}
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.assert(t, prepareTransport(&test.input))
+		})
+	}
+}
+
+func Test_verifyPinnedDigest(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2}`)
+	manifestDigest, _, err := v1.SHA256(bytes.NewReader(manifest))
+	require.NoError(t, err)
+	otherDigest, _, err := v1.SHA256(strings.NewReader("something else"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		ref         string
+		digest      v1.Hash
+		expectedErr bool
+	}{
+		{
+			name:   "tag references are not pinned",
+			ref:    "some/repo:latest",
+			digest: otherDigest,
+		},
+		{
+			name:   "manifest matches the pinned digest",
+			ref:    "some/repo@" + manifestDigest.String(),
+			digest: manifestDigest,
+		},
+		{
+			name:        "manifest does not match the pinned digest",
+			ref:         "some/repo@" + otherDigest.String(),
+			digest:      otherDigest,
+			expectedErr: true,
+		},
+		{
+			name:        "reported digest does not match the manifest",
+			ref:         "some/repo@" + manifestDigest.String(),
+			digest:      otherDigest,
+			expectedErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ref, err := name.ParseReference(test.ref)
+			require.NoError(t, err)
+
+			descriptor := &remote.Descriptor{
+				Descriptor: v1.Descriptor{Digest: test.digest},
+				Manifest:   manifest,
+			}
+
+			err = verifyPinnedDigest(ref, descriptor)
+			if test.expectedErr {
+				assert.True(t, errors.Is(err, ErrDigestMismatch), "expected ErrDigestMismatch, got: %+v", err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRegistryImageProvider_Provide_PinnedDigest(t *testing.T) {
+	// manifests requested by digest from "tampered/repo" are swapped for another manifest, as a misbehaving registry
+	// would. The swapped manifest claims to be a signed schema 1 manifest with the requested digest, for which the
+	// registry client trusts the Docker-Content-Digest header, so the fetch itself succeeds.
+	reg := registry.New()
+	var tampered []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v2/tampered/repo/manifests/sha256:") {
+			w.Header().Set("Content-Type", string(types.DockerManifestSchema1Signed))
+			w.Header().Set("Docker-Content-Digest", path.Base(r.URL.Path))
+			_, _ = w.Write(tampered)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	for _, repo := range []string{"some/repo", "tampered/repo"} {
+		ref, err := name.ParseReference(host+"/"+repo+":latest", name.Insecure)
+		require.NoError(t, err)
+		img, err := random.Image(1024, 1)
+		require.NoError(t, err)
+		require.NoError(t, remote.Write(ref, img))
+	}
+
+	latest, err := name.ParseReference(host+"/some/repo:latest", name.Insecure)
+	require.NoError(t, err)
+	descriptor, err := remote.Get(latest)
+	require.NoError(t, err)
+	digest := descriptor.Digest.String()
+
+	other, err := name.ParseReference(host+"/tampered/repo:latest", name.Insecure)
+	require.NoError(t, err)
+	otherDescriptor, err := remote.Get(other)
+	require.NoError(t, err)
+	tampered = otherDescriptor.Manifest
+
+	tmpDirGen := file.NewTempDirGenerator()
+	defer tmpDirGen.Cleanup()
+	registryOptions := &image.RegistryOptions{InsecureUseHTTP: true}
+
+	img, err := NewProviderFromRegistry(host+"/some/repo@"+digest, &tmpDirGen, registryOptions).Provide()
+	require.NoError(t, err)
+	require.NoError(t, img.Read())
+	assert.Equal(t, digest, img.Metadata.ManifestDigest)
+
+	_, err = NewProviderFromRegistry(host+"/tampered/repo@"+digest, &tmpDirGen, registryOptions).Provide()
+	assert.ErrorIs(t, err, ErrDigestMismatch)
+}
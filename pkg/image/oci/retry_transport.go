@@ -0,0 +1,143 @@
+package oci
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anchore/stereoscope/internal/log"
+	"github.com/anchore/stereoscope/pkg/image"
+)
+
+// maxRetryAfter caps how long a registry may ask us to wait before retrying (via the Retry-After header)
+const maxRetryAfter = 5 * time.Minute
+
+// retryTransport is a http.RoundTripper that retries requests which fail with transient errors, using exponential
+// backoff with jitter (honoring Retry-After headers on 429 responses).
+type retryTransport struct {
+	inner   http.RoundTripper
+	options image.RetryOptions
+	// sleep waits for the given duration or until the request is canceled (replaceable for testing)
+	sleep func(req *http.Request, d time.Duration) error
+}
+
+func newRetryTransport(inner http.RoundTripper, options image.RetryOptions) *retryTransport {
+	return &retryTransport{
+		inner:   inner,
+		options: options,
+		sleep:   sleepWithContext,
+	}
+}
+
+// RoundTrip implements the http.RoundTripper interface, retrying on transport errors, 429, and 5xx responses. Each
+// attempt is made with a copy of the given request (with the body replayed via GetBody), so the caller's request is
+// never modified. If all attempts are exhausted then an error is returned that includes the number of attempts and
+// the last failure (either the transport error or the response status).
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		attemptReq := req.Clone(req.Context())
+		if attempt > 1 && req.Body != nil {
+			if req.GetBody == nil {
+				// the request body cannot be replayed, there is nothing more we can do
+				return nil, fmt.Errorf("registry request failed after %d attempts (body cannot be replayed): %w", attempt-1, lastErr)
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+
+		resp, err := t.inner.RoundTrip(attemptReq)
+
+		var delay time.Duration
+		switch {
+		case err != nil:
+			lastErr = err
+			delay = t.backoff(attempt)
+		case isRetryableStatus(resp.StatusCode):
+			lastErr = fmt.Errorf("unexpected status from registry: %s", resp.Status)
+			delay = t.backoff(attempt)
+			if retryAfter, ok := parseRetryAfter(resp); ok {
+				delay = retryAfter
+			}
+		default:
+			return resp, nil
+		}
+
+		if resp != nil {
+			resp.Body.Close()
+		}
+
+		if attempt >= t.options.MaxAttempts {
+			return nil, fmt.Errorf("registry request failed after %d attempts: %w", attempt, lastErr)
+		}
+
+		log.Debugf("retrying registry request url=%q in %s (attempt %d/%d): %+v", req.URL, delay, attempt, t.options.MaxAttempts, lastErr)
+
+		if err := t.sleep(req, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// backoff returns the exponential delay for the given attempt with up to 50% of random jitter added.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	delay := t.options.BaseDelay << uint(attempt-1)
+	if delay <= 0 {
+		return 0
+	}
+	// nolint: gosec
+	return delay + time.Duration(rand.Int63n(int64(delay)/2+1))
+}
+
+func isRetryableStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// parseRetryAfter returns the delay requested by a 429 response via the Retry-After header (either in seconds or as
+// an HTTP date).
+func parseRetryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = time.Until(date)
+	} else {
+		return 0, false
+	}
+
+	if delay < 0 {
+		delay = 0
+	}
+	if delay > maxRetryAfter {
+		delay = maxRetryAfter
+	}
+	return delay, true
+}
+
+func sleepWithContext(req *http.Request, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-req.Context().Done():
+		return req.Context().Err()
+	case <-timer.C:
+		return nil
+	}
+}
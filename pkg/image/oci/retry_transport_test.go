@@ -0,0 +1,197 @@
+package oci
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anchore/stereoscope/pkg/image"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name             string
+		failures         int
+		failureStatus    int
+		retryAfter       string
+		maxAttempts      int
+		expectedStatus   int
+		expectedErr      string
+		expectedAttempts int
+		expectedDelays   []time.Duration
+	}{
+		{
+			name:             "no failures",
+			maxAttempts:      3,
+			expectedAttempts: 1,
+		},
+		{
+			name:             "recovers from service unavailable",
+			failures:         2,
+			failureStatus:    http.StatusServiceUnavailable,
+			maxAttempts:      3,
+			expectedAttempts: 3,
+		},
+		{
+			name:             "honors retry-after on too many requests",
+			failures:         1,
+			failureStatus:    http.StatusTooManyRequests,
+			retryAfter:       "7",
+			maxAttempts:      3,
+			expectedAttempts: 2,
+			expectedDelays:   []time.Duration{7 * time.Second},
+		},
+		{
+			name:             "gives up after max attempts",
+			failures:         5,
+			failureStatus:    http.StatusBadGateway,
+			maxAttempts:      3,
+			expectedErr:      "registry request failed after 3 attempts: unexpected status from registry: 502 Bad Gateway",
+			expectedAttempts: 3,
+		},
+		{
+			name:             "does not retry client errors",
+			failures:         5,
+			failureStatus:    http.StatusNotFound,
+			maxAttempts:      3,
+			expectedStatus:   http.StatusNotFound,
+			expectedAttempts: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var attempts int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				attempts++
+				if attempts <= test.failures {
+					if test.retryAfter != "" {
+						w.Header().Set("Retry-After", test.retryAfter)
+					}
+					w.WriteHeader(test.failureStatus)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			var delays []time.Duration
+			subject := newRetryTransport(http.DefaultTransport, image.RetryOptions{
+				MaxAttempts: test.maxAttempts,
+				BaseDelay:   time.Millisecond,
+			})
+			subject.sleep = func(_ *http.Request, d time.Duration) error {
+				delays = append(delays, d)
+				return nil
+			}
+
+			req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+			require.NoError(t, err)
+
+			resp, err := subject.RoundTrip(req)
+			assert.Equal(t, test.expectedAttempts, attempts)
+			if test.expectedErr != "" {
+				assert.EqualError(t, err, test.expectedErr)
+				assert.Nil(t, resp)
+				return
+			}
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			expectedStatus := test.expectedStatus
+			if expectedStatus == 0 {
+				expectedStatus = http.StatusOK
+			}
+			assert.Equal(t, expectedStatus, resp.StatusCode)
+
+			if test.expectedDelays != nil {
+				assert.Equal(t, test.expectedDelays, delays)
+			}
+		})
+	}
+}
+
+// failingTransport is a http.RoundTripper that fails every request without a response.
+type failingTransport struct {
+	attempts int
+}
+
+func (f *failingTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	f.attempts++
+	return nil, errors.New("connection refused")
+}
+
+func TestRetryTransport_TransportErrors(t *testing.T) {
+	inner := &failingTransport{}
+	subject := newRetryTransport(inner, image.RetryOptions{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+	})
+	subject.sleep = func(*http.Request, time.Duration) error {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://registry.example/v2/", nil)
+	require.NoError(t, err)
+
+	_, err = subject.RoundTrip(req)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed after 3 attempts")
+	assert.Equal(t, 3, inner.attempts)
+}
+
+func TestRetryTransport_DoesNotModifyRequest(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		bodies = append(bodies, string(body))
+		if len(bodies) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	subject := newRetryTransport(http.DefaultTransport, image.RetryOptions{
+		MaxAttempts: 3,
+		BaseDelay:   time.Millisecond,
+	})
+	subject.sleep = func(*http.Request, time.Duration) error {
+		return nil
+	}
+
+	req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("manifest"))
+	require.NoError(t, err)
+	originalBody := req.Body
+
+	resp, err := subject.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, []string{"manifest", "manifest", "manifest"}, bodies)
+	// every retry replays the body into a copy of the request
+	assert.Equal(t, originalBody, req.Body)
+}
+
+func TestRetryTransport_Backoff(t *testing.T) {
+	subject := newRetryTransport(http.DefaultTransport, image.RetryOptions{
+		MaxAttempts: 5,
+		BaseDelay:   100 * time.Millisecond,
+	})
+
+	for attempt := 1; attempt <= 4; attempt++ {
+		expected := 100 * time.Millisecond << uint(attempt-1)
+		actual := subject.backoff(attempt)
+		assert.GreaterOrEqual(t, int64(actual), int64(expected))
+		assert.LessOrEqual(t, int64(actual), int64(expected+expected/2))
+	}
+}
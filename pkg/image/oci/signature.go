@@ -0,0 +1,146 @@
+package oci
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/anchore/stereoscope/internal/log"
+	"github.com/anchore/stereoscope/pkg/image"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+const (
+	// cosignSignatureAnnotation is the layer annotation holding the base64 encoded signature over the layer payload
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+	// cosignSignatureTagSuffix is appended to the "<alg>-<hex>" tag of the signed image to find its signatures
+	cosignSignatureTagSuffix = ".sig"
+)
+
+// ErrImageNotSigned is returned when there are no signatures for an image that verify against the given public key.
+var ErrImageNotSigned = errors.New("no valid signature found for image")
+
+// simpleSigningPayload is the subset of the "simple signing" payload signed by cosign that binds the signature
+// to a specific image manifest digest.
+type simpleSigningPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest string `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+// VerifySignature checks that the image with the given digest (within the repository of the given reference) has at
+// least one cosign signature that verifies against the given public key (an *ecdsa.PublicKey, *rsa.PublicKey or
+// ed25519.PublicKey). Signatures are discovered using the cosign "<alg>-<hex>.sig" tag schema.
+//
+// Note: only key-based verification is supported. This is implemented with the standard library alone so that
+// stereoscope does not need to depend on sigstore; keyless (certificate and transparency log based) verification
+// requires the sigstore libraries and should be done with cosign directly.
+func VerifySignature(ref name.Reference, digest v1.Hash, publicKey crypto.PublicKey, registryOptions *image.RegistryOptions) error {
+	if registryOptions == nil {
+		registryOptions = &image.RegistryOptions{}
+	}
+
+	tag := ref.Context().Tag(fmt.Sprintf("%s-%s%s", digest.Algorithm, digest.Hex, cosignSignatureTagSuffix))
+
+	sigImage, err := remote.Image(tag, prepareRemoteOptions(ref, registryOptions)...)
+	if err != nil {
+		var transportErr *transport.Error
+		if errors.As(err, &transportErr) && transportErr.StatusCode == http.StatusNotFound {
+			return fmt.Errorf("%w: no signatures found at tag=%q", ErrImageNotSigned, tag)
+		}
+		return fmt.Errorf("unable to fetch signatures tag=%q: %w", tag, err)
+	}
+
+	manifest, err := sigImage.Manifest()
+	if err != nil {
+		return fmt.Errorf("unable to read signatures manifest: %w", err)
+	}
+
+	for _, descriptor := range manifest.Layers {
+		signature, ok := descriptor.Annotations[cosignSignatureAnnotation]
+		if !ok {
+			continue
+		}
+
+		payload, err := signaturePayload(sigImage, descriptor.Digest)
+		if err != nil {
+			return err
+		}
+
+		if err := verifySignaturePayload(payload, signature, digest, publicKey); err != nil {
+			log.Debugf("signature layer=%q does not verify: %+v", descriptor.Digest, err)
+			continue
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("%w: checked %d signature(s) at tag=%q", ErrImageNotSigned, len(manifest.Layers), tag)
+}
+
+func signaturePayload(sigImage v1.Image, layerDigest v1.Hash) ([]byte, error) {
+	layer, err := sigImage.LayerByDigest(layerDigest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch signature layer=%q: %w", layerDigest, err)
+	}
+
+	reader, err := layer.Compressed()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read signature layer=%q: %w", layerDigest, err)
+	}
+	defer reader.Close()
+
+	return ioutil.ReadAll(reader)
+}
+
+// verifySignaturePayload checks the signature over the given payload and that the payload refers to the expected
+// image digest.
+func verifySignaturePayload(payload []byte, encodedSignature string, digest v1.Hash, publicKey crypto.PublicKey) error {
+	signature, err := base64.StdEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return fmt.Errorf("unable to decode signature: %w", err)
+	}
+
+	hash := sha256.Sum256(payload)
+
+	switch key := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hash[:], signature) {
+			return fmt.Errorf("invalid ecdsa signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature); err != nil {
+			return fmt.Errorf("invalid rsa signature: %w", err)
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, payload, signature) {
+			return fmt.Errorf("invalid ed25519 signature")
+		}
+	default:
+		return fmt.Errorf("unsupported public key type: %T", publicKey)
+	}
+
+	var simpleSigning simpleSigningPayload
+	if err := json.Unmarshal(payload, &simpleSigning); err != nil {
+		return fmt.Errorf("unable to parse signature payload: %w", err)
+	}
+
+	if simpleSigning.Critical.Image.DockerManifestDigest != digest.String() {
+		return fmt.Errorf("signature is for digest=%q, not %q", simpleSigning.Critical.Image.DockerManifestDigest, digest)
+	}
+
+	return nil
+}
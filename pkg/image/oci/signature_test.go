@@ -0,0 +1,117 @@
+package oci
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anchore/stereoscope/pkg/image"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pushSignature signs a simple signing payload for the given digest and pushes it to the cosign signature tag.
+func pushSignature(t *testing.T, ref name.Reference, signedDigest, tagDigest v1.Hash, key *ecdsa.PrivateKey) {
+	t.Helper()
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":%q},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":null}`,
+		ref.Context().Name(), signedDigest.String()))
+
+	hash := sha256.Sum256(payload)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	require.NoError(t, err)
+
+	sigImage, err := mutate.Append(empty.Image, mutate.Addendum{
+		Layer: static.NewLayer(payload, "application/vnd.dev.cosign.simplesigning.v1+json"),
+		Annotations: map[string]string{
+			cosignSignatureAnnotation: base64.StdEncoding.EncodeToString(signature),
+		},
+	})
+	require.NoError(t, err)
+
+	tag := ref.Context().Tag(fmt.Sprintf("%s-%s%s", tagDigest.Algorithm, tagDigest.Hex, cosignSignatureTagSuffix))
+	require.NoError(t, remote.Write(tag, sigImage))
+}
+
+func TestVerifySignature(t *testing.T) {
+	signingKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	otherDigest := v1.Hash{
+		Algorithm: "sha256",
+		Hex:       "5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270",
+	}
+
+	tests := []struct {
+		name           string
+		sign           func(t *testing.T, ref name.Reference, digest v1.Hash)
+		expectVerified bool
+	}{
+		{
+			name: "valid signature",
+			sign: func(t *testing.T, ref name.Reference, digest v1.Hash) {
+				pushSignature(t, ref, digest, digest, signingKey)
+			},
+			expectVerified: true,
+		},
+		{
+			name: "not signed",
+			sign: func(t *testing.T, ref name.Reference, digest v1.Hash) {},
+		},
+		{
+			name: "signed with a different key",
+			sign: func(t *testing.T, ref name.Reference, digest v1.Hash) {
+				pushSignature(t, ref, digest, digest, otherKey)
+			},
+		},
+		{
+			name: "signature payload for a different image",
+			sign: func(t *testing.T, ref name.Reference, digest v1.Hash) {
+				pushSignature(t, ref, otherDigest, digest, signingKey)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(registry.New())
+			defer server.Close()
+
+			ref, err := name.ParseReference(strings.TrimPrefix(server.URL, "http://")+"/some/repo:latest", name.Insecure)
+			require.NoError(t, err)
+
+			img, err := random.Image(1024, 1)
+			require.NoError(t, err)
+			require.NoError(t, remote.Write(ref, img))
+
+			digest, err := img.Digest()
+			require.NoError(t, err)
+
+			test.sign(t, ref, digest)
+
+			err = VerifySignature(ref, digest, &signingKey.PublicKey, &image.RegistryOptions{InsecureUseHTTP: true})
+			if test.expectVerified {
+				assert.NoError(t, err)
+				return
+			}
+			assert.True(t, errors.Is(err, ErrImageNotSigned), "expected ErrImageNotSigned, got: %+v", err)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package image
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/anchore/stereoscope/pkg/filetree"
+)
+
+// osReleasePaths are the locations of the os-release file, in order of precedence (see os-release(5)).
+var osReleasePaths = []file.Path{
+	"/etc/os-release",
+	"/usr/lib/os-release",
+}
+
+// OSRelease represents the parsed contents of an os-release file, used for identifying the base OS of an image.
+type OSRelease struct {
+	// ID is the lower-case identifier of the OS (e.g. "alpine", "debian")
+	ID string
+	// VersionID is the lower-case version of the OS (e.g. "3.15.0", "11")
+	VersionID string
+	// PrettyName is the human readable name of the OS including the version (e.g. "Debian GNU/Linux 11 (bullseye)")
+	PrettyName string
+	// Fields contains all key-value pairs found in the file
+	Fields map[string]string
+}
+
+// ErrOSReleaseNotFound is returned when the image squash does not contain any os-release file.
+type ErrOSReleaseNotFound struct {
+	Paths []file.Path
+}
+
+func (e *ErrOSReleaseNotFound) Error() string {
+	return fmt.Sprintf("no os-release file found (paths=%+v)", e.Paths)
+}
+
+// OSRelease finds and parses the os-release file from the squashed tree, checking /etc/os-release and then
+// /usr/lib/os-release. An ErrOSReleaseNotFound is returned if neither file exists.
+func (i *Image) OSRelease() (*OSRelease, error) {
+	for _, p := range osReleasePaths {
+		exists, ref, err := i.SquashedTree().File(p, filetree.FollowBasenameLinks)
+		if err != nil {
+			return nil, err
+		}
+		if !exists || ref == nil {
+			continue
+		}
+
+		reader, err := i.FileCatalog.FileContents(*ref)
+		if err != nil {
+			return nil, err
+		}
+		release, err := parseOSRelease(reader)
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse os-release path=%q: %w", p, err)
+		}
+		return release, nil
+	}
+	return nil, &ErrOSReleaseNotFound{Paths: osReleasePaths}
+}
+
+// parseOSRelease reads newline separated KEY=VALUE assignments, where values may be quoted and comments start with '#'.
+func parseOSRelease(reader io.Reader) (*OSRelease, error) {
+	fields := make(map[string]string)
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fieldParts := strings.SplitN(line, "=", 2)
+		if len(fieldParts) != 2 {
+			continue
+		}
+		fields[strings.TrimSpace(fieldParts[0])] = unquoteOSReleaseValue(strings.TrimSpace(fieldParts[1]))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &OSRelease{
+		ID:         fields["ID"],
+		VersionID:  fields["VERSION_ID"],
+		PrettyName: fields["PRETTY_NAME"],
+		Fields:     fields,
+	}, nil
+}
+
+// unquoteOSReleaseValue removes surrounding single or double quotes, resolving shell escapes within double quotes.
+func unquoteOSReleaseValue(value string) string {
+	if len(value) < 2 {
+		return value
+	}
+	switch {
+	case value[0] == '\'' && value[len(value)-1] == '\'':
+		return value[1 : len(value)-1]
+	case value[0] == '"' && value[len(value)-1] == '"':
+		value = value[1 : len(value)-1]
+		var sb strings.Builder
+		for idx := 0; idx < len(value); idx++ {
+			if value[idx] == '\\' && idx+1 < len(value) && strings.ContainsRune("\"\\$`", rune(value[idx+1])) {
+				idx++
+			}
+			sb.WriteByte(value[idx])
+		}
+		return sb.String()
+	}
+	return value
+}
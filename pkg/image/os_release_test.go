@@ -0,0 +1,126 @@
+package image
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const debianOSRelease = `PRETTY_NAME="Debian GNU/Linux 11 (bullseye)"
+NAME="Debian GNU/Linux"
+VERSION_ID="11"
+VERSION="11 (bullseye)"
+VERSION_CODENAME=bullseye
+ID=debian
+HOME_URL="https://www.debian.org/"
+`
+
+func TestParseOSRelease(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected OSRelease
+	}{
+		{
+			name:  "debian",
+			input: debianOSRelease,
+			expected: OSRelease{
+				ID:         "debian",
+				VersionID:  "11",
+				PrettyName: "Debian GNU/Linux 11 (bullseye)",
+				Fields: map[string]string{
+					"PRETTY_NAME":      "Debian GNU/Linux 11 (bullseye)",
+					"NAME":             "Debian GNU/Linux",
+					"VERSION_ID":       "11",
+					"VERSION":          "11 (bullseye)",
+					"VERSION_CODENAME": "bullseye",
+					"ID":               "debian",
+					"HOME_URL":         "https://www.debian.org/",
+				},
+			},
+		},
+		{
+			name:  "comments, single quotes and escapes",
+			input: "# a comment\n\nID='alpine'\nVERSION_ID=3.15.0\nPRETTY_NAME=\"Alpine \\\"Linux\\\" \\$3.15\"\nnot-an-assignment\n",
+			expected: OSRelease{
+				ID:         "alpine",
+				VersionID:  "3.15.0",
+				PrettyName: `Alpine "Linux" $3.15`,
+				Fields: map[string]string{
+					"ID":          "alpine",
+					"VERSION_ID":  "3.15.0",
+					"PRETTY_NAME": `Alpine "Linux" $3.15`,
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := parseOSRelease(strings.NewReader(test.input))
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, *actual)
+		})
+	}
+}
+
+func TestImage_OSRelease(t *testing.T) {
+	tests := []struct {
+		name       string
+		layers     []testLayer
+		expectedID string
+		notFound   bool
+	}{
+		{
+			name: "etc os-release",
+			layers: []testLayer{
+				{
+					digest:   "sha256:a",
+					paths:    []string{"/etc/os-release", "/usr/lib/os-release"},
+					contents: map[string]string{"/etc/os-release": "ID=alpine", "/usr/lib/os-release": "ID=other"},
+				},
+			},
+			expectedID: "alpine",
+		},
+		{
+			name: "fallback to usr lib os-release",
+			layers: []testLayer{
+				{
+					digest:   "sha256:a",
+					paths:    []string{"/usr/lib/os-release"},
+					contents: map[string]string{"/usr/lib/os-release": debianOSRelease},
+				},
+			},
+			expectedID: "debian",
+		},
+		{
+			name: "no os-release",
+			layers: []testLayer{
+				{
+					digest: "sha256:a",
+					paths:  []string{"/bin/busybox"},
+				},
+			},
+			notFound: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img := newTestImage(t, test.layers...)
+
+			release, err := img.OSRelease()
+			if test.notFound {
+				var notFoundErr *ErrOSReleaseNotFound
+				if !errors.As(err, &notFoundErr) {
+					t.Fatalf("expected ErrOSReleaseNotFound, got: %+v", err)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.expectedID, release.ID)
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package image
+
+import (
+	"fmt"
+
+	v1Types "github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// ErrReferrersNotSupported is returned when referrers are requested for an image from a source that has no concept of
+// referrers (e.g. a docker archive or the docker daemon).
+var ErrReferrersNotSupported = fmt.Errorf("referrers are only supported for registry-backed images")
+
+// Referrer describes an artifact (e.g. a signature, attestation, or SBOM) that refers to an image by digest, as
+// described by the OCI distribution spec referrers API.
+type Referrer struct {
+	// ArtifactType is the type of artifact (e.g. "application/spdx+json"), which may be empty for older artifacts
+	ArtifactType string
+	// MediaType is the media type of the referring manifest
+	MediaType v1Types.MediaType
+	// Digest is the digest of the referring manifest
+	Digest string
+	// Size in bytes of the referring manifest
+	Size        int64
+	Annotations map[string]string
+}
+
+// ReferrersFetcher is a function capable of resolving all referrers for an image from the source it was provided from.
+type ReferrersFetcher func() ([]Referrer, error)
+
+// WithReferrersFetcher provides a means to resolve referrers for the image. This is only expected to be used by
+// providers that are backed by a registry.
+func WithReferrersFetcher(fetcher ReferrersFetcher) AdditionalMetadata {
+	return func(image *Image) error {
+		image.referrersFetcher = fetcher
+		return nil
+	}
+}
+
+// Referrers returns all artifacts that refer to this image by digest. If the image was not provided from a
+// registry then ErrReferrersNotSupported is returned. If the registry does not support the referrers API the
+// referrers tag schema is consulted instead; if neither yield results then an empty set is returned.
+func (i *Image) Referrers() ([]Referrer, error) {
+	if i.referrersFetcher == nil {
+		return nil, ErrReferrersNotSupported
+	}
+	return i.referrersFetcher()
+}
@@ -1,6 +1,10 @@
 package image
 
 import (
+	"crypto"
+	"net/http"
+	"time"
+
 	"github.com/anchore/stereoscope/internal/log"
 	"github.com/google/go-containerregistry/pkg/authn"
 )
@@ -10,6 +14,42 @@ type RegistryOptions struct {
 	InsecureSkipTLSVerify bool
 	InsecureUseHTTP       bool
 	Credentials           []RegistryCredentials
+	// SignatureVerificationKey, when set, requires that images fetched from a registry have a cosign signature that
+	// verifies against this public key. Images without a valid signature are not provided. Images from any other source
+	// cannot be verified, so requesting them with a key set is an error.
+	SignatureVerificationKey crypto.PublicKey
+	// Retry configures retrying registry requests that fail with transient errors (429 and 5xx responses)
+	Retry RetryOptions
+	// Transport, when set, is the base HTTP transport used for all registry interactions (e.g. to configure a proxy,
+	// custom timeouts, or request logging). Registry authentication is always applied on top of this transport. Note
+	// that the default transport honors the HTTP_PROXY, HTTPS_PROXY, and NO_PROXY environment variables, however, a
+	// custom transport only does so if it is configured with http.ProxyFromEnvironment.
+	Transport http.RoundTripper
+}
+
+// RetryOptions describes how registry requests are retried on transient errors, using exponential backoff with
+// jitter. A MaxAttempts of 0 or 1 disables retries.
+type RetryOptions struct {
+	// MaxAttempts is the total number of attempts made for a request (including the first attempt)
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, doubled for each subsequent retry
+	BaseDelay time.Duration
+}
+
+// WithRetry returns a copy of the registry options with retries for transient registry errors enabled.
+func (r RegistryOptions) WithRetry(maxAttempts int, baseDelay time.Duration) RegistryOptions {
+	r.Retry = RetryOptions{
+		MaxAttempts: maxAttempts,
+		BaseDelay:   baseDelay,
+	}
+	return r
+}
+
+// WithTransport returns a copy of the registry options that uses the given base HTTP transport for all registry
+// interactions (see RegistryOptions.Transport).
+func (r RegistryOptions) WithTransport(transport http.RoundTripper) RegistryOptions {
+	r.Transport = transport
+	return r
 }
 
 // Authenticator returns an object capable of authenticating against the given registry. If no credentials match the
@@ -0,0 +1,223 @@
+package remotearchive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/anchore/stereoscope/internal"
+	"github.com/anchore/stereoscope/internal/bus"
+	"github.com/anchore/stereoscope/internal/log"
+	"github.com/anchore/stereoscope/pkg/event"
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/anchore/stereoscope/pkg/image"
+	"github.com/anchore/stereoscope/pkg/image/docker"
+	"github.com/anchore/stereoscope/pkg/image/oci"
+	"github.com/wagoodman/go-partybus"
+	"github.com/wagoodman/go-progress"
+)
+
+// maxDownloadAttempts is the number of times a download is attempted before giving up. Downloads that are interrupted
+// are resumed from where they left off when the server supports range requests.
+const maxDownloadAttempts = 5
+
+// ErrUnsupportedArchive is returned when the downloaded file is neither a docker nor an OCI image archive.
+var ErrUnsupportedArchive = fmt.Errorf("downloaded file is not a docker or OCI image archive")
+
+// URLImageProvider is an image.Provider for a docker or OCI image archive that is downloaded from an http(s) URL (for
+// example, an image artifact stored by a CI system).
+type URLImageProvider struct {
+	url       string
+	tmpDirGen *file.TempDirGenerator
+	client    *http.Client
+}
+
+// NewProviderFromURL creates a new provider instance for the image archive at the given http(s) URL.
+func NewProviderFromURL(url string, tmpDirGen *file.TempDirGenerator) *URLImageProvider {
+	return &URLImageProvider{
+		url:       url,
+		tmpDirGen: tmpDirGen,
+		client:    http.DefaultClient,
+	}
+}
+
+// Provide an image object that represents the image archive at the configured URL. The archive is downloaded to a temp
+// dir (never held in memory) and validated to be a docker or OCI archive before it is processed.
+func (p *URLImageProvider) Provide() (*image.Image, error) {
+	imageTempDir, err := p.tmpDirGen.NewTempDir()
+	if err != nil {
+		return nil, err
+	}
+
+	archivePath := path.Join(imageTempDir, "image.tar")
+	if err := p.download(archivePath); err != nil {
+		return nil, err
+	}
+
+	source, err := image.DetectSourceFromPath(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to detect archive format: %w", err)
+	}
+
+	switch source {
+	case image.DockerTarballSource:
+		return docker.NewProviderFromTarball(archivePath, p.tmpDirGen, nil, nil).Provide()
+	case image.OciTarballSource:
+		return oci.NewProviderFromTarball(archivePath, p.tmpDirGen).Provide()
+	}
+	return nil, fmt.Errorf("%w: url=%q", ErrUnsupportedArchive, p.url)
+}
+
+// download fetches the archive to the given path, resuming interrupted downloads with range requests.
+func (p *URLImageProvider) download(archivePath string) error {
+	fh, err := os.Create(archivePath)
+	if err != nil {
+		return fmt.Errorf("unable to create temp file for image: %w", err)
+	}
+	defer func() {
+		if err := fh.Close(); err != nil {
+			log.Errorf("unable to close temp file (%s): %w", fh.Name(), err)
+		}
+	}()
+
+	prog, stage := p.trackDownloadProgress()
+	stage.Current = "downloading image archive"
+
+	// the validator identifies the archive version being downloaded, so that a resumed download is only appended to
+	// when the archive has not changed since the download started
+	var validator string
+	for attempt := 1; ; attempt++ {
+		err = p.downloadFrom(fh, prog, &validator)
+		if err == nil {
+			break
+		}
+		if attempt >= maxDownloadAttempts || !errors.Is(err, errInterrupted) {
+			prog.Err = err
+			return err
+		}
+		log.Debugf("resuming download of url=%q at byte=%d (attempt %d): %+v", p.url, prog.N, attempt+1, err)
+	}
+
+	if prog.N == 0 {
+		prog.Err = fmt.Errorf("cannot provide an empty image")
+		return prog.Err
+	}
+	prog.SetCompleted()
+	return nil
+}
+
+// errInterrupted indicates a download that failed part way through the response body (and may be resumed).
+var errInterrupted = fmt.Errorf("download interrupted")
+
+// downloadFrom appends the remainder of the archive (from the number of bytes downloaded so far) to the given file. The
+// validator (ETag or Last-Modified) of the first response is recorded and sent as If-Range when resuming, so that the
+// server sends the whole archive again if it has changed in the meantime. Downloads without a validator are restarted
+// from the first byte instead of resumed.
+func (p *URLImageProvider) downloadFrom(fh *os.File, prog *progress.Manual, validator *string) error {
+	req, err := http.NewRequest(http.MethodGet, p.url, nil)
+	if err != nil {
+		return fmt.Errorf("invalid image archive url=%q: %w", p.url, err)
+	}
+	if prog.N > 0 && *validator != "" {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", prog.N))
+		req.Header.Set("If-Range", *validator)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to download image archive: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// the server is sending the whole archive (either this is the first attempt, ranges are not supported, or the
+		// archive has changed since the download started)
+		if err := restart(fh, prog); err != nil {
+			return err
+		}
+		prog.Total = resp.ContentLength
+		*validator = responseValidator(resp)
+	case http.StatusPartialContent:
+		if req.Header.Get("Range") == "" {
+			return fmt.Errorf("unable to download image archive: unexpected partial content")
+		}
+		if total, ok := contentRangeTotal(resp.Header.Get("Content-Range")); ok {
+			prog.Total = total
+		}
+	default:
+		return fmt.Errorf("unable to download image archive: unexpected status %q", resp.Status)
+	}
+
+	if _, err := io.Copy(fh, internal.NewProgressReader(resp.Body, prog)); err != nil {
+		return fmt.Errorf("%w: %v", errInterrupted, err)
+	}
+
+	if prog.Total >= 0 {
+		switch {
+		case prog.N < prog.Total:
+			return fmt.Errorf("%w: received %d of %d bytes", errInterrupted, prog.N, prog.Total)
+		case prog.N > prog.Total:
+			return fmt.Errorf("unable to download image archive: received %d bytes, expected %d", prog.N, prog.Total)
+		}
+	}
+	return nil
+}
+
+// responseValidator returns the value to send as If-Range to resume downloading the same version of the archive: the
+// ETag of the response (when it is a strong ETag) or otherwise the Last-Modified date.
+func responseValidator(resp *http.Response) string {
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return resp.Header.Get("Last-Modified")
+}
+
+// restart truncates the partially downloaded archive so that the download starts over from the first byte.
+func restart(fh *os.File, prog *progress.Manual) error {
+	if err := fh.Truncate(0); err != nil {
+		return fmt.Errorf("unable to truncate image archive: %w", err)
+	}
+	if _, err := fh.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("unable to seek image archive: %w", err)
+	}
+	prog.N = 0
+	return nil
+}
+
+// contentRangeTotal returns the complete length from a "Content-Range: bytes <start>-<end>/<total>" header.
+func contentRangeTotal(header string) (int64, bool) {
+	idx := strings.LastIndex(header, "/")
+	if idx < 0 {
+		return 0, false
+	}
+	total, err := strconv.ParseInt(header[idx+1:], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return total, true
+}
+
+func (p *URLImageProvider) trackDownloadProgress() (*progress.Manual, *progress.Stage) {
+	prog := &progress.Manual{Total: -1}
+	stage := &progress.Stage{}
+
+	bus.Publish(partybus.Event{
+		Type:   event.FetchImage,
+		Source: p.url,
+		Value: progress.StagedProgressable(&struct {
+			progress.Stager
+			*progress.Manual
+		}{
+			Stager: progress.Stager(stage),
+			Manual: prog,
+		}),
+	})
+
+	return prog, stage
+}
@@ -0,0 +1,215 @@
+package remotearchive
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fixtureRef = name.MustParseReference("stereoscope-fixture-remote:latest")
+
+func newDockerArchive(t *testing.T) []byte {
+	t.Helper()
+	img, err := random.Image(1024, 2)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	require.NoError(t, tarball.Write(fixtureRef, img, &buf))
+	return buf.Bytes()
+}
+
+func newTempDirGenerator(t *testing.T) *file.TempDirGenerator {
+	tmpDirGen := file.NewTempDirGenerator()
+	t.Cleanup(func() {
+		assert.NoError(t, tmpDirGen.Cleanup())
+	})
+	return &tmpDirGen
+}
+
+func TestURLImageProvider_Provide(t *testing.T) {
+	archive := newDockerArchive(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "image.tar", time.Time{}, bytes.NewReader(archive))
+	}))
+	defer server.Close()
+
+	img, err := NewProviderFromURL(server.URL+"/image.tar", newTempDirGenerator(t)).Provide()
+	require.NoError(t, err)
+	require.NoError(t, img.Read())
+
+	assert.Len(t, img.Layers, 2)
+	require.Len(t, img.Metadata.Tags, 1)
+	assert.Equal(t, fixtureRef.String(), img.Metadata.Tags[0].String())
+}
+
+func TestURLImageProvider_Provide_ResumesInterruptedDownload(t *testing.T) {
+	archive := newDockerArchive(t)
+	changedArchive := newDockerArchive(t)
+
+	tests := []struct {
+		name           string
+		etag           string
+		changed        bool
+		expectedRanges []string
+		expectedIfs    []string
+	}{
+		{
+			name:           "resumes the same archive",
+			etag:           `"v1"`,
+			expectedRanges: []string{"", "bytes=" + strconv.Itoa(len(archive)/2) + "-"},
+			expectedIfs:    []string{"", `"v1"`},
+		},
+		{
+			name:           "restarts when the archive changed",
+			etag:           `"v1"`,
+			changed:        true,
+			expectedRanges: []string{"", "bytes=" + strconv.Itoa(len(archive)/2) + "-"},
+			expectedIfs:    []string{"", `"v1"`},
+		},
+		{
+			name:           "restarts without a validator",
+			expectedRanges: []string{"", ""},
+			expectedIfs:    []string{"", ""},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var lock sync.Mutex
+			var ranges, ifRanges []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				lock.Lock()
+				ranges = append(ranges, r.Header.Get("Range"))
+				ifRanges = append(ifRanges, r.Header.Get("If-Range"))
+				first := len(ranges) == 1
+				lock.Unlock()
+
+				contents := archive
+				if test.etag != "" {
+					w.Header().Set("ETag", test.etag)
+				}
+				if first {
+					// send only half of the archive before dropping the connection
+					w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+					w.WriteHeader(http.StatusOK)
+					_, _ = w.Write(archive[:len(archive)/2])
+					panic(http.ErrAbortHandler)
+				}
+				if test.changed {
+					contents = changedArchive
+					w.Header().Set("ETag", `"v2"`)
+				}
+				http.ServeContent(w, r, "image.tar", time.Time{}, bytes.NewReader(contents))
+			}))
+			defer server.Close()
+
+			img, err := NewProviderFromURL(server.URL+"/image.tar", newTempDirGenerator(t)).Provide()
+			require.NoError(t, err)
+			require.NoError(t, img.Read())
+			assert.Len(t, img.Layers, 2)
+
+			expected := archive
+			if test.changed {
+				expected = changedArchive
+			}
+			expectedImg, err := tarball.Image(func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(bytes.NewReader(expected)), nil
+			}, nil)
+			require.NoError(t, err)
+			expectedID, err := expectedImg.ConfigName()
+			require.NoError(t, err)
+			assert.Equal(t, expectedID.String(), img.Metadata.ID)
+
+			lock.Lock()
+			defer lock.Unlock()
+			assert.Equal(t, test.expectedRanges, ranges)
+			assert.Equal(t, test.expectedIfs, ifRanges)
+		})
+	}
+}
+
+func TestURLImageProvider_Provide_IncompleteRange(t *testing.T) {
+	archive := newDockerArchive(t)
+	half, threeQuarters := len(archive)/2, len(archive)*3/4
+
+	var lock sync.Mutex
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		attempt := len(ranges)
+		lock.Unlock()
+
+		w.Header().Set("ETag", `"v1"`)
+		switch attempt {
+		case 1:
+			// send only half of the archive before dropping the connection
+			w.Header().Set("Content-Length", strconv.Itoa(len(archive)))
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write(archive[:half])
+			panic(http.ErrAbortHandler)
+		case 2:
+			// send a range that ends before the end of the archive (without any error on the connection)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", half, threeQuarters-1, len(archive)))
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = w.Write(archive[half:threeQuarters])
+		default:
+			http.ServeContent(w, r, "image.tar", time.Time{}, bytes.NewReader(archive))
+		}
+	}))
+	defer server.Close()
+
+	img, err := NewProviderFromURL(server.URL+"/image.tar", newTempDirGenerator(t)).Provide()
+	require.NoError(t, err)
+	require.NoError(t, img.Read())
+	assert.Len(t, img.Layers, 2)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []string{"", fmt.Sprintf("bytes=%d-", half), fmt.Sprintf("bytes=%d-", threeQuarters)}, ranges)
+}
+
+func TestURLImageProvider_Provide_InvalidArchive(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+	}{
+		{
+			name: "not an image archive",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte("<html>not an image</html>"))
+			},
+		},
+		{
+			name: "not found",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.NotFound(w, r)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(test.handler)
+			defer server.Close()
+
+			_, err := NewProviderFromURL(server.URL+"/image.tar", newTempDirGenerator(t)).Provide()
+			assert.Error(t, err)
+		})
+	}
+}
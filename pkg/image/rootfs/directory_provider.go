@@ -0,0 +1,144 @@
+package rootfs
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/anchore/stereoscope/internal/log"
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/anchore/stereoscope/pkg/image"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// DirectoryImageProvider is an image.Provider for a plain directory on disk (e.g. an extracted root filesystem),
+// represented as a synthetic image with a single layer.
+type DirectoryImageProvider struct {
+	path      string
+	tmpDirGen *file.TempDirGenerator
+}
+
+// NewProviderFromPath creates a new provider instance for the root filesystem directory at the given path.
+func NewProviderFromPath(path string, tmpDirGen *file.TempDirGenerator) *DirectoryImageProvider {
+	return &DirectoryImageProvider{
+		path:      path,
+		tmpDirGen: tmpDirGen,
+	}
+}
+
+// Provide an image object with a single layer that contains all files under the directory. Symlinks are kept as
+// links (never followed) and special files (devices and FIFOs) are represented with their original type. The layer
+// is captured once, so changes to the directory after the image is read are not reflected.
+func (p *DirectoryImageProvider) Provide() (*image.Image, error) {
+	pathStat, err := os.Stat(p.path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read directory=%q: %w", p.path, err)
+	}
+	if !pathStat.IsDir() {
+		return nil, fmt.Errorf("path=%q is not a directory", p.path)
+	}
+
+	imageTempDir, err := p.tmpDirGen.NewTempDir()
+	if err != nil {
+		return nil, err
+	}
+
+	layerTarPath := path.Join(imageTempDir, "rootfs.tar")
+	if err := writeDirectoryTar(p.path, layerTarPath); err != nil {
+		return nil, fmt.Errorf("unable to capture directory=%q as a layer: %w", p.path, err)
+	}
+
+	layer, err := tarball.LayerFromFile(layerTarPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create layer from directory=%q: %w", p.path, err)
+	}
+
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create image from directory=%q: %w", p.path, err)
+	}
+
+	contentTempDir, err := p.tmpDirGen.NewTempDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return image.NewImage(img, contentTempDir), nil
+}
+
+// writeDirectoryTar writes all entries under the given root directory to a new tar file at the given path, where each
+// entry name is relative to the root directory.
+func writeDirectoryTar(root, tarPath string) error {
+	fh, err := os.Create(tarPath)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	writer := tar.NewWriter(fh)
+
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		if relPath == "." {
+			return nil
+		}
+
+		var linkname string
+		if info.Mode()&os.ModeSymlink != 0 {
+			linkname, err = os.Readlink(p)
+			if err != nil {
+				return fmt.Errorf("unable to read link=%q: %w", p, err)
+			}
+		}
+
+		header, err := tar.FileInfoHeader(info, linkname)
+		if err != nil {
+			// for example, sockets cannot be represented in a tar
+			log.Warnf("skipping unsupported file=%q: %+v", p, err)
+			return nil
+		}
+		header.Name = filepath.ToSlash(relPath)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err := writer.WriteHeader(header); err != nil {
+			return fmt.Errorf("unable to write header for file=%q: %w", p, err)
+		}
+
+		if header.Typeflag == tar.TypeReg {
+			return copyFileContents(writer, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return writer.Close()
+}
+
+func copyFileContents(writer io.Writer, p string) error {
+	fh, err := os.Open(p)
+	if err != nil {
+		return fmt.Errorf("unable to open file=%q: %w", p, err)
+	}
+	defer fh.Close()
+
+	if _, err := io.Copy(writer, fh); err != nil {
+		return fmt.Errorf("unable to copy file=%q: %w", p, err)
+	}
+	return nil
+}
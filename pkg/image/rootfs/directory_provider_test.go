@@ -0,0 +1,78 @@
+//go:build !windows
+// +build !windows
+
+package rootfs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/anchore/stereoscope/pkg/file"
+	"github.com/anchore/stereoscope/pkg/filetree"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectoryImageProvider(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "etc"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "usr", "bin"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "etc", "os-release"), []byte("ID=custom"), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(root, "usr", "bin", "busybox"), []byte("busybox-binary"), 0755))
+	require.NoError(t, os.Symlink("busybox", filepath.Join(root, "usr", "bin", "sh")))
+	require.NoError(t, os.Symlink("/usr/bin", filepath.Join(root, "bin")))
+	require.NoError(t, syscall.Mkfifo(filepath.Join(root, "etc", "fifo"), 0644))
+
+	generator := file.NewTempDirGenerator()
+	defer generator.Cleanup()
+
+	img, err := NewProviderFromPath(root, &generator).Provide()
+	require.NoError(t, err)
+	require.NoError(t, img.Read())
+
+	assert.Len(t, img.Layers, 1)
+
+	// regular files are readable
+	reader, err := img.FileContentsFromSquash("/etc/os-release")
+	require.NoError(t, err)
+	contents, err := ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "ID=custom", string(contents))
+
+	// symlinks are preserved and resolvable through the squash
+	exists, ref, err := img.SquashedTree().File("/usr/bin/sh")
+	require.NoError(t, err)
+	require.True(t, exists)
+	entry, err := img.FileCatalog.Get(*ref)
+	require.NoError(t, err)
+	assert.Equal(t, file.TypeSymlink, file.Type(entry.Metadata.TypeFlag))
+	assert.Equal(t, "busybox", entry.Metadata.Linkname)
+
+	reader, err = img.FileContentsFromSquash("/bin/sh")
+	require.NoError(t, err)
+	contents, err = ioutil.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "busybox-binary", string(contents))
+
+	// special files keep their type
+	_, ref, err = img.SquashedTree().File("/etc/fifo", filetree.FollowBasenameLinks)
+	require.NoError(t, err)
+	require.NotNil(t, ref)
+	entry, err = img.FileCatalog.Get(*ref)
+	require.NoError(t, err)
+	assert.Equal(t, file.TypeFifo, file.Type(entry.Metadata.TypeFlag))
+}
+
+func TestDirectoryImageProvider_NotADirectory(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "file.txt")
+	require.NoError(t, ioutil.WriteFile(p, []byte("contents"), 0644))
+
+	generator := file.NewTempDirGenerator()
+	defer generator.Cleanup()
+
+	_, err := NewProviderFromPath(p, &generator).Provide()
+	assert.Error(t, err)
+}
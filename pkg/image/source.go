@@ -26,6 +26,8 @@ const (
 	OciTarballSource
 	OciRegistrySource
 	PodmanDaemonSource
+	DirectorySource
+	RemoteArchiveSource
 )
 
 const SchemeSeparator = ":"
@@ -38,6 +40,8 @@ var sourceStr = [...]string{
 	"OciTarball",
 	"OciRegistry",
 	"PodmanDaemon",
+	"Directory",
+	"RemoteArchive",
 }
 
 var AllSources = []Source{
@@ -47,6 +51,8 @@ var AllSources = []Source{
 	OciTarballSource,
 	OciRegistrySource,
 	PodmanDaemonSource,
+	DirectorySource,
+	RemoteArchiveSource,
 }
 
 // Source is a concrete a selection of valid concrete image providers.
@@ -76,6 +82,8 @@ func ParseSourceScheme(source string) Source {
 		return OciTarballSource
 	case "oci-registry", "registry":
 		return OciRegistrySource
+	case "dir":
+		return DirectorySource
 	}
 	return UnknownSource
 }
@@ -89,6 +97,11 @@ func DetectSource(userInput string) (Source, string, error) {
 // DetectSource takes a user string and determines the image source (e.g. the docker daemon, a tar file, etc.) returning the string subset representing the image (or nothing if it is unknown).
 // note: parsing is done relative to the given string and environmental evidence (i.e. the given filesystem) to determine the actual source.
 func detectSource(fs afero.Fs, userInput string) (Source, string, error) {
+	if isRemoteArchiveURL(userInput) {
+		// the URL scheme separator would otherwise be mistaken for a source hint
+		return RemoteArchiveSource, userInput, nil
+	}
+
 	candidates := strings.SplitN(userInput, SchemeSeparator, 2)
 
 	var source Source
@@ -112,7 +125,7 @@ func detectSource(fs afero.Fs, userInput string) (Source, string, error) {
 	}
 
 	switch source {
-	case OciDirectorySource, OciTarballSource, DockerTarballSource:
+	case OciDirectorySource, OciTarballSource, DockerTarballSource, DirectorySource:
 		// since the scheme was explicitly given, that means that home dir tilde expansion would not have been done by the shell (so we have to)
 		location, err = homedir.Expand(location)
 		if err != nil {
@@ -131,6 +144,12 @@ func detectSource(fs afero.Fs, userInput string) (Source, string, error) {
 	return source, location, nil
 }
 
+// isRemoteArchiveURL indicates if the given user input is an http(s) URL to a docker or OCI image archive.
+func isRemoteArchiveURL(userInput string) bool {
+	lower := strings.ToLower(userInput)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
 // DetermineImagePullSource takes an image reference string as input, and
 // determines a Source to use to pull the image. If the input doesn't specify an
 // image reference (i.e. an image that can be _pulled_), UnknownSource is
@@ -217,6 +236,11 @@ func detectSourceFromPath(fs afero.Fs, imgPath string) (Source, error) {
 			"oci-layout",
 			OciTarballSource,
 		},
+		{
+			// legacy docker archives (from before Docker 1.10) have no manifest.json
+			"repositories",
+			DockerTarballSource,
+		},
 	} {
 		if _, err = archive.Seek(0, io.SeekStart); err != nil {
 			return UnknownSource, fmt.Errorf("unable to seek archive=%s: %w", imgPath, err)
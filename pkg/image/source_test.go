@@ -84,6 +84,18 @@ func TestDetectSource(t *testing.T) {
 			source:           UnknownSource,
 			expectedLocation: "",
 		},
+		{
+			name:             "remote-archive",
+			input:            "https://example.com/artifacts/image.tar",
+			source:           RemoteArchiveSource,
+			expectedLocation: "https://example.com/artifacts/image.tar",
+		},
+		{
+			name:             "remote-archive-http",
+			input:            "HTTP://example.com:8080/image.tar",
+			source:           RemoteArchiveSource,
+			expectedLocation: "HTTP://example.com:8080/image.tar",
+		},
 		{
 			name:             "relative-path-1",
 			input:            ".",
@@ -224,6 +236,10 @@ func TestParseScheme(t *testing.T) {
 			source:   "oci-archive",
 			expected: OciTarballSource,
 		},
+		{
+			source:   "dir",
+			expected: DirectorySource,
+		},
 		{
 			// regression for unsupported behavior
 			source:   "oci-tar",
@@ -303,6 +319,12 @@ func TestDetectSourceFromPath(t *testing.T) {
 			sourceType:     "tar",
 			expectedSource: DockerTarballSource,
 		},
+		{
+			name:           "legacy docker tar path",
+			paths:          []string{"repositories"},
+			sourceType:     "tar",
+			expectedSource: DockerTarballSource,
+		},
 		{
 			name:           "no dir paths",
 			paths:          []string{},
@@ -0,0 +1,66 @@
+package image
+
+import "time"
+
+// Timings is the time spent in each phase of reading an image (see WithTimings), which helps to find whether reading a
+// particular image is IO-bound (layer reads) or CPU-bound (squashing).
+type Timings struct {
+	// Metadata is the time spent reading the image manifest and config
+	Metadata time.Duration
+	// Layers is the time spent reading each layer by layer index (fetching, decompressing, caching, and indexing the
+	// layer tar)
+	Layers []time.Duration
+	// Squash is the time spent creating the squash trees for all layers
+	Squash time.Duration
+	// Total is the time spent reading the image from start to finish
+	Total time.Duration
+}
+
+// WithTimings records the time spent in each phase of Image.Read, which is available afterwards via Image.Timings.
+func WithTimings() AdditionalMetadata {
+	return func(image *Image) error {
+		image.timings = &Timings{}
+		return nil
+	}
+}
+
+// Timings returns the time spent in each phase of the last Image.Read. Timings are only recorded when reading with
+// WithTimings, otherwise all durations are zero.
+func (i *Image) Timings() Timings {
+	if i.timings == nil {
+		return Timings{}
+	}
+	return *i.timings
+}
+
+// reset clears all recorded timings. This and all of the following record methods (which record the time since the
+// given start of a phase) are no-ops when timings are not enabled.
+func (t *Timings) reset() {
+	if t != nil {
+		*t = Timings{}
+	}
+}
+
+func (t *Timings) recordMetadata(start time.Time) {
+	if t != nil {
+		t.Metadata = time.Since(start)
+	}
+}
+
+func (t *Timings) recordLayer(start time.Time) {
+	if t != nil {
+		t.Layers = append(t.Layers, time.Since(start))
+	}
+}
+
+func (t *Timings) recordSquash(start time.Time) {
+	if t != nil {
+		t.Squash = time.Since(start)
+	}
+}
+
+func (t *Timings) recordTotal(start time.Time) {
+	if t != nil {
+		t.Total = time.Since(start)
+	}
+}
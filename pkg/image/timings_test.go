@@ -0,0 +1,59 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImage_WithTimings(t *testing.T) {
+	v1Image, err := mutate.AppendLayers(empty.Image,
+		newTarLayer(t, map[string]string{"etc/hosts": "localhost"}),
+		newTarLayer(t, map[string]string{"etc/hostname": "stereoscope"}),
+	)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		options  []AdditionalMetadata
+		recorded bool
+	}{
+		{
+			name:     "timings enabled",
+			options:  []AdditionalMetadata{WithTimings()},
+			recorded: true,
+		},
+		{
+			name: "timings disabled",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			img := NewImage(v1Image, t.TempDir(), test.options...)
+			require.NoError(t, img.Read())
+
+			timings := img.Timings()
+			if !test.recorded {
+				assert.Equal(t, Timings{}, timings)
+				return
+			}
+
+			require.Len(t, timings.Layers, 2)
+			assert.Greater(t, int64(timings.Total), int64(0))
+			assert.Greater(t, int64(timings.Squash), int64(0))
+			for _, d := range timings.Layers {
+				assert.Greater(t, int64(d), int64(0))
+				assert.LessOrEqual(t, int64(d), int64(timings.Total))
+			}
+			assert.LessOrEqual(t, int64(timings.Metadata+timings.Squash), int64(timings.Total))
+
+			// reading again replaces the previous timings
+			require.NoError(t, img.Read())
+			assert.Len(t, img.Timings().Layers, 2)
+		})
+	}
+}
@@ -0,0 +1,181 @@
+package image
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/anchore/stereoscope/pkg/file"
+)
+
+const (
+	passwdPath file.Path = "/etc/passwd"
+	groupPath  file.Path = "/etc/group"
+)
+
+// ErrUserNotFound is returned when the configured user name cannot be found within the image /etc/passwd file.
+var ErrUserNotFound = fmt.Errorf("user not found in image")
+
+// ErrGroupNotFound is returned when the configured group name cannot be found within the image /etc/group file.
+var ErrGroupNotFound = fmt.Errorf("group not found in image")
+
+// passwdEntry is a single account from an /etc/passwd file.
+type passwdEntry struct {
+	name string
+	uid  int
+	gid  int
+}
+
+// groupEntry is a single group from an /etc/group file.
+type groupEntry struct {
+	name string
+	gid  int
+}
+
+// EffectiveUser resolves the user that the image config declares to run as (the USER instruction) to a concrete uid
+// and gid, using the /etc/passwd and /etc/group files from the squashed tree. The following forms are supported (where
+// either part may be a name or a numeric id): "user", "user:group". When no group is given the primary group of the
+// user from /etc/passwd is used (or gid 0 if the user has no entry). An unset USER resolves to root (uid 0, gid 0).
+//
+// The returned name is the user name from /etc/passwd, which is empty if a numeric uid has no entry. Numeric ids are
+// used as-is, so a missing /etc/passwd or /etc/group file is only an error when a name needs to be resolved, in which
+// case ErrUserNotFound or ErrGroupNotFound is returned.
+func (i *Image) EffectiveUser() (int, int, string, error) {
+	configured := strings.TrimSpace(i.Metadata.Config.Config.User)
+	userPart, groupPart := configured, ""
+	if idx := strings.Index(configured, ":"); idx >= 0 {
+		userPart, groupPart = configured[:idx], configured[idx+1:]
+	}
+	if userPart == "" {
+		userPart = "0"
+	}
+
+	accounts, err := i.passwdEntries()
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	var account *passwdEntry
+	uid, err := strconv.Atoi(userPart)
+	if err == nil {
+		for idx := range accounts {
+			if accounts[idx].uid == uid {
+				account = &accounts[idx]
+				break
+			}
+		}
+	} else {
+		for idx := range accounts {
+			if accounts[idx].name == userPart {
+				account = &accounts[idx]
+				break
+			}
+		}
+		if account == nil {
+			return 0, 0, "", fmt.Errorf("%w: user=%q (path=%q)", ErrUserNotFound, userPart, passwdPath)
+		}
+		uid = account.uid
+	}
+
+	var name string
+	gid := 0
+	if account != nil {
+		name = account.name
+		gid = account.gid
+	}
+
+	if groupPart != "" {
+		gid, err = i.resolveGroup(groupPart)
+		if err != nil {
+			return 0, 0, "", err
+		}
+	}
+
+	return uid, gid, name, nil
+}
+
+// resolveGroup returns the gid for the given numeric gid or group name (from /etc/group).
+func (i *Image) resolveGroup(group string) (int, error) {
+	if gid, err := strconv.Atoi(group); err == nil {
+		return gid, nil
+	}
+
+	groups, err := i.groupEntries()
+	if err != nil {
+		return 0, err
+	}
+	for _, g := range groups {
+		if g.name == group {
+			return g.gid, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: group=%q (path=%q)", ErrGroupNotFound, group, groupPath)
+}
+
+// passwdEntries returns all well-formed entries from the /etc/passwd file in the squashed tree (none if the file does
+// not exist).
+func (i *Image) passwdEntries() ([]passwdEntry, error) {
+	var entries []passwdEntry
+	err := i.readColonSeparatedFile(passwdPath, func(fields []string) {
+		// name:password:uid:gid:gecos:home:shell
+		if len(fields) < 4 {
+			return
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return
+		}
+		gid, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return
+		}
+		entries = append(entries, passwdEntry{name: fields[0], uid: uid, gid: gid})
+	})
+	return entries, err
+}
+
+// groupEntries returns all well-formed entries from the /etc/group file in the squashed tree (none if the file does
+// not exist).
+func (i *Image) groupEntries() ([]groupEntry, error) {
+	var entries []groupEntry
+	err := i.readColonSeparatedFile(groupPath, func(fields []string) {
+		// name:password:gid:members
+		if len(fields) < 3 {
+			return
+		}
+		gid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return
+		}
+		entries = append(entries, groupEntry{name: fields[0], gid: gid})
+	})
+	return entries, err
+}
+
+// readColonSeparatedFile invokes the given function with the fields of each line of the given file from the squashed
+// tree, skipping blank lines and comments. A missing file is not an error.
+func (i *Image) readColonSeparatedFile(p file.Path, fn func(fields []string)) error {
+	contents, err := i.ReadFile(p)
+	if errors.Is(err, ErrPathNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read path=%q: %w", p, err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(contents))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fn(strings.Split(line, ":"))
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("unable to parse path=%q: %w", p, err)
+	}
+	return nil
+}
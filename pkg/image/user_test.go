@@ -0,0 +1,119 @@
+package image
+
+import (
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPasswd = `root:x:0:0:root:/root:/bin/sh
+# a comment
+daemon:x:1:1:daemon:/usr/sbin:/usr/sbin/nologin
+app:x:1000:1001:app user:/home/app:/bin/sh
+malformed:x:not-a-uid:1
+`
+
+const testGroup = `root:x:0:
+daemon:x:1:
+app:x:1001:
+staff:x:50:app
+`
+
+func TestImage_EffectiveUser(t *testing.T) {
+	tests := []struct {
+		name         string
+		user         string
+		files        map[string]string
+		expectedUID  int
+		expectedGID  int
+		expectedName string
+		expectedErr  error
+	}{
+		{
+			name:         "unset user is root",
+			files:        map[string]string{"etc/passwd": testPasswd},
+			expectedName: "root",
+		},
+		{
+			name:         "user name",
+			user:         "app",
+			files:        map[string]string{"etc/passwd": testPasswd},
+			expectedUID:  1000,
+			expectedGID:  1001,
+			expectedName: "app",
+		},
+		{
+			name:         "uid",
+			user:         "1",
+			files:        map[string]string{"etc/passwd": testPasswd},
+			expectedUID:  1,
+			expectedGID:  1,
+			expectedName: "daemon",
+		},
+		{
+			name:         "user and group names",
+			user:         "app:staff",
+			files:        map[string]string{"etc/passwd": testPasswd, "etc/group": testGroup},
+			expectedUID:  1000,
+			expectedGID:  50,
+			expectedName: "app",
+		},
+		{
+			name:        "uid:gid literal without passwd",
+			user:        "1234:5678",
+			files:       map[string]string{"etc/hosts": "localhost"},
+			expectedUID: 1234,
+			expectedGID: 5678,
+		},
+		{
+			name:        "uid without passwd entry",
+			user:        "1234",
+			files:       map[string]string{"etc/passwd": testPasswd},
+			expectedUID: 1234,
+		},
+		{
+			name:        "user name without passwd",
+			user:        "app",
+			files:       map[string]string{"etc/hosts": "localhost"},
+			expectedErr: ErrUserNotFound,
+		},
+		{
+			name:        "malformed entries are ignored",
+			user:        "malformed",
+			files:       map[string]string{"etc/passwd": testPasswd},
+			expectedErr: ErrUserNotFound,
+		},
+		{
+			name:        "group name without group file",
+			user:        "app:staff",
+			files:       map[string]string{"etc/passwd": testPasswd},
+			expectedErr: ErrGroupNotFound,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v1Image, err := mutate.AppendLayers(empty.Image, newTarLayer(t, test.files))
+			require.NoError(t, err)
+			v1Image, err = mutate.Config(v1Image, v1.Config{User: test.user})
+			require.NoError(t, err)
+
+			img := NewImage(v1Image, t.TempDir())
+			require.NoError(t, img.Read())
+
+			uid, gid, name, err := img.EffectiveUser()
+			if test.expectedErr != nil {
+				require.ErrorIs(t, err, test.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expectedUID, uid)
+			assert.Equal(t, test.expectedGID, gid)
+			assert.Equal(t, test.expectedName, name)
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package image
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/anchore/stereoscope/pkg/file"
+)
+
+// ValidationIssue describes a single internal inconsistency found within an image (see Image.Validate).
+type ValidationIssue struct {
+	// Check is a short identifier of the consistency check that failed (e.g. "layer-count")
+	Check string
+	// Layer is the index of the layer the issue was found in (-1 if the issue does not pertain to a single layer)
+	Layer int
+	// Message is a human readable description of the issue
+	Message string
+}
+
+func (v ValidationIssue) String() string {
+	if v.Layer < 0 {
+		return fmt.Sprintf("%s: %s", v.Check, v.Message)
+	}
+	return fmt.Sprintf("%s (layer=%d): %s", v.Check, v.Layer, v.Message)
+}
+
+// Validate checks the internal consistency of an image that has already been read, returning all issues found (or
+// nil if the image is consistent). This includes:
+//   - the number of diffIDs in the config matches the number of layers
+//   - each layer diffID matches the diffID in the config at the same position (layer ordering)
+//   - the manifest digest matches the digest of the raw manifest (when available)
+//   - the image ID matches the digest of the raw config (when available)
+//   - each layer tree is coherent with the file catalog (every file is cataloged and attributed to the layer)
+//
+// This is useful for diagnosing broken caches or malformed images.
+func (i *Image) Validate() []ValidationIssue {
+	var issues []ValidationIssue
+	issues = append(issues, i.validateLayerOrdering()...)
+	issues = append(issues, i.validateDigests()...)
+	for _, layer := range i.Layers {
+		issues = append(issues, i.validateLayerCatalog(layer)...)
+	}
+	return issues
+}
+
+func (i *Image) validateLayerOrdering() []ValidationIssue {
+	var issues []ValidationIssue
+	diffIDs := i.Metadata.Config.RootFS.DiffIDs
+	if len(diffIDs) != len(i.Layers) {
+		issues = append(issues, ValidationIssue{
+			Check:   "layer-count",
+			Layer:   -1,
+			Message: fmt.Sprintf("config declares %d diffIDs but the image has %d layers", len(diffIDs), len(i.Layers)),
+		})
+	}
+
+	for idx, layer := range i.Layers {
+		if layer.Metadata.Index != uint(idx) {
+			issues = append(issues, ValidationIssue{
+				Check:   "layer-index",
+				Layer:   idx,
+				Message: fmt.Sprintf("layer is at position %d but has index %d", idx, layer.Metadata.Index),
+			})
+		}
+
+		if idx >= len(diffIDs) {
+			continue
+		}
+
+		expected := diffIDs[idx].String()
+		actual := layer.Metadata.Digest
+		if layer.layer != nil {
+			if diffID, err := layer.layer.DiffID(); err == nil {
+				actual = diffID.String()
+			}
+		}
+		if actual != expected {
+			issues = append(issues, ValidationIssue{
+				Check:   "layer-diffid",
+				Layer:   idx,
+				Message: fmt.Sprintf("layer diffID is %q but the config declares %q at this position", actual, expected),
+			})
+		}
+	}
+	return issues
+}
+
+func (i *Image) validateDigests() []ValidationIssue {
+	var issues []ValidationIssue
+	if len(i.Metadata.RawManifest) > 0 && i.Metadata.ManifestDigest != "" {
+		actual := fmt.Sprintf("sha256:%x", sha256.Sum256(i.Metadata.RawManifest))
+		if actual != i.Metadata.ManifestDigest {
+			issues = append(issues, ValidationIssue{
+				Check:   "manifest-digest",
+				Layer:   -1,
+				Message: fmt.Sprintf("raw manifest digest is %q but the manifest digest is %q", actual, i.Metadata.ManifestDigest),
+			})
+		}
+	}
+
+	if len(i.Metadata.RawConfig) > 0 {
+		actual := fmt.Sprintf("sha256:%x", sha256.Sum256(i.Metadata.RawConfig))
+		if actual != i.Metadata.ID {
+			issues = append(issues, ValidationIssue{
+				Check:   "config-digest",
+				Layer:   -1,
+				Message: fmt.Sprintf("raw config digest is %q but the image ID is %q", actual, i.Metadata.ID),
+			})
+		}
+	}
+	return issues
+}
+
+func (i *Image) validateLayerCatalog(layer *Layer) []ValidationIssue {
+	var issues []ValidationIssue
+	if layer.Tree == nil {
+		return []ValidationIssue{
+			{
+				Check:   "layer-catalog",
+				Layer:   int(layer.Metadata.Index),
+				Message: "layer has no file tree",
+			},
+		}
+	}
+
+	for _, ref := range layer.Tree.AllFiles(file.AllTypes...) {
+		entry, err := i.FileCatalog.Get(ref)
+		if err != nil {
+			issues = append(issues, ValidationIssue{
+				Check:   "layer-catalog",
+				Layer:   int(layer.Metadata.Index),
+				Message: fmt.Sprintf("path=%q is not in the file catalog", ref.RealPath),
+			})
+			continue
+		}
+		if entry.Layer != layer {
+			issues = append(issues, ValidationIssue{
+				Check:   "layer-catalog",
+				Layer:   int(layer.Metadata.Index),
+				Message: fmt.Sprintf("path=%q is cataloged under a different layer", ref.RealPath),
+			})
+		}
+	}
+	return issues
+}
@@ -0,0 +1,77 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImage_Validate(t *testing.T) {
+	tests := []struct {
+		name           string
+		corrupt        func(t *testing.T, img *Image)
+		expectedChecks []string
+	}{
+		{
+			name:    "consistent image",
+			corrupt: func(t *testing.T, img *Image) {},
+		},
+		{
+			name: "layers out of order",
+			corrupt: func(t *testing.T, img *Image) {
+				img.Layers[0], img.Layers[1] = img.Layers[1], img.Layers[0]
+			},
+			expectedChecks: []string{"layer-index", "layer-diffid", "layer-index", "layer-diffid"},
+		},
+		{
+			name: "missing layer",
+			corrupt: func(t *testing.T, img *Image) {
+				img.Layers = img.Layers[:1]
+			},
+			expectedChecks: []string{"layer-count"},
+		},
+		{
+			name: "image ID does not match config",
+			corrupt: func(t *testing.T, img *Image) {
+				img.Metadata.ID = "sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270"
+			},
+			expectedChecks: []string{"config-digest"},
+		},
+		{
+			name: "manifest digest does not match manifest",
+			corrupt: func(t *testing.T, img *Image) {
+				img.Metadata.RawManifest = []byte("{}")
+				img.Metadata.ManifestDigest = "sha256:5b0bcabd1ed22e9fb1310cf6c2dec7cdef19f0ad69efa1f392e94a4333501270"
+			},
+			expectedChecks: []string{"manifest-digest"},
+		},
+		{
+			name: "uncataloged file in layer tree",
+			corrupt: func(t *testing.T, img *Image) {
+				_, err := img.Layers[1].Tree.AddFile("/not/cataloged")
+				require.NoError(t, err)
+			},
+			expectedChecks: []string{"layer-catalog"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v1Image, err := random.Image(1024, 2)
+			require.NoError(t, err)
+
+			img := NewImage(v1Image, t.TempDir())
+			require.NoError(t, img.Read())
+
+			test.corrupt(t, img)
+
+			var actualChecks []string
+			for _, issue := range img.Validate() {
+				actualChecks = append(actualChecks, issue.Check)
+			}
+			assert.Equal(t, test.expectedChecks, actualChecks)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package image
+
+import (
+	"fmt"
+
+	"github.com/anchore/stereoscope/internal/log"
+	"github.com/anchore/stereoscope/pkg/file"
+)
+
+// WarningKind describes the kind of non-fatal anomaly found while reading an image.
+type WarningKind string
+
+const (
+	// DuplicatePathWarning indicates a layer tar has more than one entry for the same path (the last entry wins).
+	DuplicatePathWarning WarningKind = "duplicate-path"
+	// BrokenHardlinkWarning indicates a hardlink to a missing target was skipped (see WithStrictHardlinks).
+	BrokenHardlinkWarning WarningKind = "broken-hardlink"
+	// SkippedForeignLayerWarning indicates a foreign layer was not read (see WithForeignLayerPolicy).
+	SkippedForeignLayerWarning WarningKind = "skipped-foreign-layer"
+	// HistoryMismatchWarning indicates the image history does not describe the same number of layers as the image has,
+	// so history entries cannot be attributed to layers.
+	HistoryMismatchWarning WarningKind = "history-mismatch"
+)
+
+// Warning is a non-fatal anomaly found while reading an image, which does not prevent the image from being read but
+// may explain unexpected results (e.g. a file that appears to be missing).
+type Warning struct {
+	Kind WarningKind
+	// Layer is the index of the layer the warning was found in (-1 for warnings about the image as a whole)
+	Layer int
+	// Path is the path within the layer the warning is about (empty if the warning is not about a specific path)
+	Path file.Path
+	// Message describes the warning
+	Message string
+}
+
+func (w Warning) String() string {
+	switch {
+	case w.Layer < 0:
+		return fmt.Sprintf("%s: %s", w.Kind, w.Message)
+	case w.Path == "":
+		return fmt.Sprintf("%s (layer=%d): %s", w.Kind, w.Layer, w.Message)
+	}
+	return fmt.Sprintf("%s (layer=%d path=%q): %s", w.Kind, w.Layer, w.Path, w.Message)
+}
+
+// Warnings returns all non-fatal anomalies found during the last Image.Read, in the order they were found.
+func (i *Image) Warnings() []Warning {
+	warnings := make([]Warning, len(i.warnings))
+	copy(warnings, i.warnings)
+	return warnings
+}
+
+// warn records a warning about the image as a whole.
+func (i *Image) warn(kind WarningKind, format string, args ...interface{}) {
+	w := Warning{Kind: kind, Layer: -1, Message: fmt.Sprintf(format, args...)}
+	log.Warnf("image=%q %s", i.Metadata.ID, w)
+	i.warnings = append(i.warnings, w)
+}
+
+// warn records a warning about the layer (or a path within the layer).
+func (l *Layer) warn(kind WarningKind, p file.Path, format string, args ...interface{}) {
+	w := Warning{Kind: kind, Layer: int(l.Metadata.Index), Path: p, Message: fmt.Sprintf(format, args...)}
+	log.Warnf("layer=%q %s", l.Metadata.Digest, w)
+	l.warnings = append(l.warnings, w)
+}
@@ -0,0 +1,90 @@
+package image
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/anchore/stereoscope/pkg/file"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImage_Warnings(t *testing.T) {
+	buf := &bytes.Buffer{}
+	writer := tar.NewWriter(buf)
+	for _, entry := range []struct {
+		header   tar.Header
+		contents string
+	}{
+		{header: tar.Header{Name: "etc/hosts", Typeflag: tar.TypeReg, Mode: 0644}, contents: "first"},
+		{header: tar.Header{Name: "etc/hosts", Typeflag: tar.TypeReg, Mode: 0644}, contents: "second"},
+		{header: tar.Header{Name: "usr/bin/perl5", Typeflag: tar.TypeLink, Linkname: "usr/bin/perl"}},
+	} {
+		entry.header.Size = int64(len(entry.contents))
+		require.NoError(t, writer.WriteHeader(&entry.header))
+		_, err := writer.Write([]byte(entry.contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, writer.Close())
+
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	require.NoError(t, err)
+
+	v1Image, err := mutate.AppendLayers(empty.Image, newTarLayer(t, map[string]string{"etc/os-release": "linux"}), layer)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		history  []v1.History
+		expected []Warning
+	}{
+		{
+			name:    "history matches layers",
+			history: []v1.History{{CreatedBy: "ADD rootfs.tar /"}, {CreatedBy: "ENV A=b", EmptyLayer: true}, {CreatedBy: "COPY . /"}},
+			expected: []Warning{
+				{Kind: DuplicatePathWarning, Layer: 1, Path: "/etc/hosts", Message: "more than one tar entry for the path (sequence=1 wins)"},
+				{Kind: BrokenHardlinkWarning, Layer: 1, Path: "/usr/bin/perl5", Message: `skipped hardlink to missing target="/usr/bin/perl"`},
+			},
+		},
+		{
+			name:    "history does not match layers",
+			history: []v1.History{{CreatedBy: "COPY . /"}},
+			expected: []Warning{
+				{Kind: HistoryMismatchWarning, Layer: -1, Message: "history describes 1 layer(s), however, the image declares 2 layer(s)"},
+				{Kind: DuplicatePathWarning, Layer: 1, Path: "/etc/hosts", Message: "more than one tar entry for the path (sequence=1 wins)"},
+				{Kind: BrokenHardlinkWarning, Layer: 1, Path: "/usr/bin/perl5", Message: `skipped hardlink to missing target="/usr/bin/perl"`},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config, err := v1Image.ConfigFile()
+			require.NoError(t, err)
+			config = config.DeepCopy()
+			config.History = test.history
+			withHistory, err := mutate.ConfigFile(v1Image, config)
+			require.NoError(t, err)
+
+			img := NewImage(withHistory, t.TempDir())
+			require.NoError(t, img.Read())
+			assert.Equal(t, test.expected, img.Warnings())
+
+			// the last entry for a duplicated path wins
+			contents, err := img.FileContentsFromSquash(file.Path("/etc/hosts"))
+			require.NoError(t, err)
+			actual, err := ioutil.ReadAll(contents)
+			require.NoError(t, err)
+			assert.Equal(t, "second", string(actual))
+		})
+	}
+}
@@ -73,6 +73,10 @@ func TestSimpleImage(t *testing.T) {
 		expectedSet.Add(int(src))
 	}
 	expectedSet.Remove(int(image.OciRegistrySource))
+	// the directory source is not an image format, it is covered by the rootfs package tests
+	expectedSet.Remove(int(image.DirectorySource))
+	// the remote archive source downloads a docker or OCI archive, it is covered by the remotearchive package tests
+	expectedSet.Remove(int(image.RemoteArchiveSource))
 
 	for _, c := range simpleImageTestCases {
 		t.Run(c.name, func(t *testing.T) {